@@ -2,10 +2,11 @@ package datamanager
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"reflect"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -15,14 +16,66 @@ import (
 
 // cache的repo操作
 
-type Fn func() interface{}
+// Fn 加载key的数据，返回错误时不会写入缓存
+type Fn func(ctx context.Context) (interface{}, error)
+
+// LoadFn 加载key的数据，params为key模板中的参数
+type LoadFn func(ctx context.Context, params map[string]string) (interface{}, error)
+
+var ErrNoPolicy = errors.New("no policy for key")
+
+// 后台刷新以及GetValue加载的超时时间
+var defaultLoadTimeout = 5 * time.Second
+
+// DefaultRedisTTL 策略没有设置TTL时redis中的过期时间
+var DefaultRedisTTL = 5 * time.Second
 
 // 触发监听的策略
+// Key可以是模板，例如 user:{id}，数据表修改时只更新该行对应的key
 type Policy struct {
 	Key   string // must unique
 	Table string
 	Field string // "*":全部 "a,b,c,d":指定字段
 	Call  Fn
+	Load  LoadFn // 设置时优先于Call
+
+	TTL   time.Duration // redis中的过期时间，0时使用DefaultRedisTTL，小于0时不过期
+	Codec Codec         // redis中的编码，为空时使用Repo的编码
+
+	LocalTTL       time.Duration // 本地缓存的过期时间，0时不过期
+	InvalidateOnly bool          // 变更时只删除缓存，下次获取时再加载
+
+	tmpl *keyTemplate
+}
+
+func (p *Policy) template() *keyTemplate {
+	if p.tmpl == nil {
+		p.tmpl = parseKeyTemplate(p.Key)
+	}
+	return p.tmpl
+}
+
+func (p *Policy) load(ctx context.Context, params map[string]string) (interface{}, error) {
+	if p.Load != nil {
+		return p.Load(ctx, params)
+	}
+	if p.Call != nil {
+		return p.Call(ctx)
+	}
+	return nil, nil
+}
+
+// matchField 负载中是否包含关注的字段
+func (p *Policy) matchField(log dialet.ILogData) bool {
+	if p.Field == "*" {
+		return true
+	}
+	for key := range log.GetPaylod() {
+		if strings.Contains(p.Field, key) {
+			return true
+		}
+	}
+	return false
 }
 
 type cacheMap struct {
@@ -70,19 +123,28 @@ func (c *cacheMap) Trigger(val dialet.ILogData) {
 }
 
 type Repo struct {
-	CacheFn  sync.Map // map[string]*Policy     //
-	ValueMap sync.Map // map[string]interface{} //
-	WaitMap  map[string]*sync.Cond
+	CacheFn  sync.Map              // map[string]*Policy     //
+	ValueMap sync.Map              // map[string]interface{} //
+	WaitMap  map[string]*sync.Cond // Deprecated: 使用Wait或者WaitFor，GenInstance创建，key的版本更新时广播
 	Chan     chan dialet.ILogData
 	client   *redis.Client
+	codec    Codec
+	types    sync.Map // 策略的key => 加载函数返回值的类型
 
-	onceFlag uint32   // 用于实现安全的双检锁
-	onceMap  sync.Map //  map[string]*keyRepo // 使用map映射
-	lock     sync.Mutex
-}
+	lock sync.Mutex
+
+	flight  flightGroup
+	stale   sync.Map          // 刷新失败仍然使用旧值的key => 需要达到的版本
+	gens    map[string]uint64 // 每次更新、失效时更新，避免旧的加载结果覆盖新的数据
+	lastGen uint64            // 代数全局递增，删除之后重新记录的key不会与之前的代数相同
 
-type keyRepo struct {
-	once uint32
+	seq    uint64               // 最后一个事件的序号
+	states map[string]*keyState // 本地缓存中的key以及正在等待的key的版本
+	floor  uint64               // 没有记录的key的版本，即已经删除的版本中最大的值
+	tx     txTracker
+
+	coherence *coherence // 多个节点之间的缓存一致性，EnableCoherence之后设置
+	local     localIndex // 本地缓存的过期时间以及容量限制
 }
 
 func NewRepo(ch chan dialet.ILogData) *Repo {
@@ -91,9 +153,9 @@ func NewRepo(ch chan dialet.ILogData) *Repo {
 		ValueMap: sync.Map{}, //map[string]interface{}{},
 		WaitMap:  map[string]*sync.Cond{},
 		Chan:     ch,
-		onceFlag: 0,
-		onceMap:  sync.Map{},
 		lock:     sync.Mutex{},
+		gens:     map[string]uint64{},
+		states:   map[string]*keyState{},
 	}
 }
 
@@ -102,119 +164,557 @@ func (r *Repo) InitRedisCache(client *redis.Client) {
 	r.client = client
 }
 
+// SetLocalLimit 限制本地缓存的数量以及大小，超过时按照LRU或者LFU淘汰
+func (r *Repo) SetLocalLimit(limit LocalLimit) *Repo {
+	evicted := r.local.setLimit(limit)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, key := range evicted {
+		r.ValueMap.Delete(key)
+		r.stale.Delete(key)
+		r.forget(key)
+	}
+	return r
+}
+
+// GetValue 加载失败时返回nil，需要错误时使用Get
 func (r *Repo) GetValue(key string) interface{} {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultLoadTimeout)
+	defer cancel()
+
+	v, err := r.Get(ctx, key)
+	if err != nil && err != ErrNoPolicy {
+		logger.DefaultLogger.Error(fmt.Sprintf("load %s: %s", key, err.Error()))
+	}
+	return v
+}
+
+// Get key为具体的key，例如 user:42，匹配 user:{id} 时使用参数id=42调用加载函数
+// 同一个key并发的加载只执行一次，共享第一个调用的ctx，加载失败时不写入缓存
+// 刷新失败的key返回旧值，并在后台重新加载；本地缓存过期的key重新加载
+func (r *Repo) Get(ctx context.Context, key string) (interface{}, error) {
 	// if val, ok := r.ValueMap[key]; ok {
 	if val, ok := r.ValueMap.Load(key); ok {
-		return val
+		if r.local.hit(key) {
+			if _, stale := r.stale.Load(key); stale {
+				r.revalidate(key)
+			}
+			return val, nil
+		}
+		r.expire(key)
 	}
 
-	// if fn, ok := r.CacheFn[key]; !ok {
-	if fn, ok := r.CacheFn.Load(key); !ok {
-		return nil
-	} else {
-		v := fn.(*Policy).Call()
+	policy, params, ok := r.lookup(key)
+	if !ok {
+		return nil, ErrNoPolicy
+	}
+	v, err, _ := r.flight.do(key, func() (interface{}, error) {
+		gen := r.generation(key)
+		v, err := r.loadShared(ctx, key, policy, params)
+		if err != nil {
+			return nil, err
+		}
+		r.recordType(policy, v)
 		// r.ValueMap[key] = v
+		r.store(key, policy, gen, v)
+		return v, nil
+	})
+	return v, err
+}
+
+// revalidate 在后台重新加载，已经有加载在执行时忽略
+func (r *Repo) revalidate(key string) {
+	if r.flight.inflight(key) {
+		return
+	}
+	policy, params, ok := r.lookup(key)
+	if !ok {
+		return
+	}
+
+	go r.flight.do(key, func() (interface{}, error) {
+		var seq uint64
+		if pending, ok := r.stale.Load(key); ok {
+			seq = pending.(uint64)
+		}
+		gen := r.generation(key)
+		ctx, cancel := context.WithTimeout(context.Background(), defaultLoadTimeout)
+		defer cancel()
+
+		v, err := policy.load(ctx, params)
+		if err != nil {
+			logger.DefaultLogger.Error(fmt.Sprintf("revalidate %s: %s", key, err.Error()))
+			return nil, err
+		}
+		if r.store(key, policy, gen, v) {
+			r.publish(key, v, seq)
+		}
+		return v, nil
+	})
+}
+
+func (r *Repo) generation(key string) uint64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.gens[key]
+}
+
+// bump 更新key的代数，之前开始的加载不会再写入缓存
+func (r *Repo) bump(key string) uint64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.gens == nil {
+		r.gens = map[string]uint64{}
+	}
+	r.lastGen++
+	r.gens[key] = r.lastGen
+	return r.lastGen
+}
+
+// forget 删除不在本地缓存中的key的版本以及代数，有等待的版本以及正在加载的代数保留，调用方需要持有锁
+func (r *Repo) forget(key string) {
+	r.dropState(key)
+	if !r.flight.inflight(key) {
+		delete(r.gens, key)
+	}
+}
+
+// store 版本没有变化时写入缓存，超过本地缓存的限制时淘汰其他的key
+// 限制了大小时无法编码的值不写入本地缓存
+func (r *Repo) store(key string, policy *Policy, gen uint64, v interface{}) bool {
+	var (
+		size       int64
+		measurable = true
+	)
+	if r.local.measured() {
+		data, err := r.codecOf(policy).Marshal(v)
+		if err != nil {
+			logger.DefaultLogger.Error(fmt.Sprintf("measure %s: %s", key, err.Error()))
+			measurable = false
+		}
+		size = int64(len(data))
+	}
+	var ttl time.Duration
+	if policy != nil {
+		ttl = policy.LocalTTL
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.gens[key] != gen {
+		return false
+	}
+	if !measurable {
+		r.ValueMap.Delete(key)
+		r.stale.Delete(key)
+		r.local.delete(key)
+		r.forget(key)
+		return true
+	}
+	evicted, admitted := r.local.add(key, ttl, size)
+	for _, k := range evicted {
+		r.ValueMap.Delete(k)
+		r.stale.Delete(k)
+		r.forget(k)
+	}
+	r.stale.Delete(key)
+	if admitted {
 		r.ValueMap.Store(key, v)
-		return v
+	} else {
+		r.ValueMap.Delete(key)
 	}
+	return true
+}
+
+// deleteLocal 删除本地缓存的值
+func (r *Repo) deleteLocal(key string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.ValueMap.Delete(key)
+	r.stale.Delete(key)
+	r.local.delete(key)
+	r.forget(key)
+}
+
+// expire 删除过期的key，期间重新写入的不会删除
+func (r *Repo) expire(key string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.local.expired(key) {
+		r.ValueMap.Delete(key)
+		r.stale.Delete(key)
+		r.local.delete(key)
+		r.forget(key)
+	}
+}
+
+// sweep 删除全部过期的本地缓存，过期的key只在访问时删除，没有访问时需要定期清理
+func (r *Repo) sweep() {
+	for _, key := range r.local.expiredKeys(time.Now()) {
+		r.expire(key)
+	}
+}
+
+// lookup 查找具体的key对应的策略，优先精确匹配
+func (r *Repo) lookup(key string) (*Policy, map[string]string, bool) {
+	// if fn, ok := r.CacheFn[key]; !ok {
+	if val, ok := r.CacheFn.Load(key); ok {
+		// 模板本身不是具体的key
+		policy := val.(*Policy)
+		return policy, nil, !policy.template().isTemplate()
+	}
+
+	var (
+		policy *Policy
+		params map[string]string
+	)
+	r.CacheFn.Range(func(_, value interface{}) bool {
+		p := value.(*Policy)
+		if !p.template().isTemplate() {
+			return true
+		}
+		if res, ok := p.template().match(key); ok {
+			policy, params = p, res
+			return false
+		}
+		return true
+	})
+	return policy, params, policy != nil
 }
 
 // 如果配置了redis就从redis中获取数据，否则从本地缓存中获取数据，
+// redis中的值使用策略的编码解码，本地加载过该key时解码为相同的类型
 func (r *Repo) GetValueV2(key string) interface{} {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultLoadTimeout)
+	defer cancel()
+
+	v, err := r.getV2(ctx, key, nil)
+	if err != nil && err != ErrNoPolicy {
+		logger.DefaultLogger.Error(fmt.Sprintf("load %s: %s", key, err.Error()))
+	}
+	return v
+}
+
+// GetAs 获取key的值并写入dest，dest为指针
+// 无论值来自本地缓存、redis还是加载函数，都会转换为dest的类型
+func (r *Repo) GetAs(ctx context.Context, key string, dest interface{}) error {
+	rv := reflect.ValueOf(dest)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return ErrInvalidDest
+	}
+
+	v, err := r.getV2(ctx, key, rv.Elem().Type())
+	if err != nil {
+		return err
+	}
+	return assignValue(rv.Elem(), v)
+}
+
+func (r *Repo) getV2(ctx context.Context, key string, typ reflect.Type) (interface{}, error) {
 	if r.client == nil {
-		return r.GetValue(key)
+		return r.Get(ctx, key)
+	}
+
+	policy, _, _ := r.lookup(key)
+	if typ == nil {
+		typ = r.typeOf(policy)
+	}
+	if v, ok := r.readRemote(ctx, key, policy, typ); ok {
+		return v, nil
+	}
+
+	v, err := r.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.SetValueV2(key, v); err != nil {
+		logger.DefaultLogger.Error(err.Error())
+	}
+	return v, nil
+}
+
+// SetValueV2 使用key对应策略的编码以及过期时间写入redis
+func (r *Repo) SetValueV2(key string, value interface{}) error {
+	policy, _, _ := r.lookup(key)
+	data, err := r.codecOf(policy).Marshal(value)
+	if err != nil {
+		return err
 	}
 
-	var res interface{}
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	val, err := r.client.Get(ctx, key).Result()
+	return r.client.Set(ctx, key, data, r.ttlOf(policy)).Err()
+}
+
+// SetCodec 设置默认的编码，策略没有指定Codec时使用，默认为JSONCodec
+func (r *Repo) SetCodec(codec Codec) *Repo {
+	r.codec = codec
+	return r
+}
+
+func (r *Repo) codecOf(policy *Policy) Codec {
 	switch {
-	case err == redis.Nil:
-		res = nil
-	case err != nil:
-		res = nil
+	case policy != nil && policy.Codec != nil:
+		return policy.Codec
+	case r.codec != nil:
+		return r.codec
 	default:
-		res = val
+		return JSONCodec
 	}
+}
 
-	if res == nil {
-		res = r.GetValue(key)
-		if err := r.SetValueV2(key, fmt.Sprint(res)); err != nil {
-			logger.DefaultLogger.Error(err.Error())
-		}
+// ttlOf 策略的TTL为0时使用DefaultRedisTTL，小于0时不过期
+func (r *Repo) ttlOf(policy *Policy) time.Duration {
+	switch {
+	case policy == nil || policy.TTL == 0:
+		return DefaultRedisTTL
+	case policy.TTL < 0:
+		return 0
+	default:
+		return policy.TTL
 	}
-	return res
 }
 
-func (r *Repo) SetValueV2(key, value string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	err := r.client.Set(ctx, key, value, 5*time.Second).Err()
-	if err != nil {
-		return err
+// typeOf 本地加载过的值的类型，没有时为nil
+func (r *Repo) typeOf(policy *Policy) reflect.Type {
+	if policy == nil {
+		return nil
+	}
+	if t, ok := r.types.Load(policy.Key); ok {
+		return t.(reflect.Type)
 	}
 	return nil
 }
 
+// recordType 记录加载函数返回值的类型，用于从redis中解码
+func (r *Repo) recordType(policy *Policy, v interface{}) {
+	if v != nil {
+		r.types.Store(policy.Key, reflect.TypeOf(v))
+	}
+}
+
 // 注册key以及处理函数(返回数据，用于更新缓存中的key)
 func (r *Repo) Register(policy *Policy) {
+	policy.tmpl = parseKeyTemplate(policy.Key)
 	// r.CacheFn[policy.Key] = policy
 	r.CacheFn.Store(policy.Key, policy)
 }
 
+// GenInstance 创建key的条件变量，key的版本更新时广播
+// Deprecated: 条件变量会错过调用之前的更新并且无法超时，使用Wait或者WaitFor
 func (r *Repo) GenInstance(key string) {
-	if val, _ := r.onceMap.LoadOrStore(key, &keyRepo{}); atomic.LoadUint32(&val.(*keyRepo).once) == 0 {
-		r.lock.Lock()
-		if v := val.(*keyRepo); v.once == 0 {
-			r.WaitMap[key] = sync.NewCond(&sync.Mutex{})
-			atomic.StoreUint32(&v.once, 1)
-		}
-		r.lock.Unlock()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.WaitMap == nil {
+		r.WaitMap = map[string]*sync.Cond{}
+	}
+	if _, ok := r.WaitMap[key]; !ok {
+		r.WaitMap[key] = sync.NewCond(&sync.Mutex{})
 	}
 }
 
 // 触发key相应的更新操作
+// 固定的key重新加载；key模板根据修改前后的行数据找到具体的key，
+// 已经缓存的key重新加载，删除的行以及修改了key字段的旧key失效
 func (r *Repo) Trigger(log dialet.ILogData) {
+	seq := r.beginEvent(log)
 	r.CacheFn.Range(func(k, value interface{}) bool {
 		key := k.(string)
 		policy := value.(*Policy)
-		if policy.Table != log.GetTable() {
+		if policy.Table != log.GetTable() || !policy.matchField(log) {
 			return true
 		}
 
-		if policy.Field != "*" {
-			invalid := true
-			for key := range log.GetPaylod() {
-				if strings.Contains(policy.Field, key) {
-					invalid = false
-					break
-				}
-			}
-			if invalid {
-				return true
+		// 验证通过，触发缓存执行
+		switch {
+		case policy.template().isTemplate():
+			r.triggerRow(policy, log, seq)
+		case policy.InvalidateOnly:
+			r.invalidate(key, seq)
+		default:
+			r.refresh(key, policy, nil, seq)
+		}
+		return true
+	})
+}
+
+func (r *Repo) triggerRow(policy *Policy, log dialet.ILogData, seq uint64) {
+	tmpl := policy.template()
+	if log.GetLabel() == "truncate" {
+		r.invalidateAll(tmpl, seq)
+		return
+	}
+
+	deleted := log.GetLabel() == "delete"
+	newKey, params, newOk := tmpl.render(log.GetPaylod())
+	oldKey, _, oldOk := tmpl.render(previousImage(log.GetPaylod(), log.GetChange()))
+	if !newOk && !oldOk {
+		// 无法确定具体的行，全部失效
+		r.invalidateAll(tmpl, seq)
+		return
+	}
+
+	if oldOk && (deleted || !newOk || oldKey != newKey) {
+		r.invalidate(oldKey, seq)
+	}
+	if !newOk {
+		return
+	}
+	if deleted || policy.InvalidateOnly {
+		r.invalidate(newKey, seq)
+	} else if _, ok := r.ValueMap.Load(newKey); ok {
+		r.refresh(newKey, policy, params, seq)
+	} else {
+		// 本地没有缓存，删除redis中的旧值，下次获取时加载的就是最新的数据
+		r.invalidate(newKey, seq)
+	}
+}
+
+// refresh 重新加载并写入缓存，失败时保留旧值，下次获取时在后台重新加载，重新加载成功后才更新版本
+func (r *Repo) refresh(key string, policy *Policy, params map[string]string, seq uint64) {
+	r.touch(key, seq)
+	r.reload(key, policy, params, seq)
+}
+
+// reload 开启一致性时获取锁之后加载，其他节点持有锁时可能是在变更之前开始的加载，
+// 保留旧值并等待锁释放之后重新加载，之后才更新版本
+func (r *Repo) reload(key string, policy *Policy, params map[string]string, seq uint64) {
+	gen := r.bump(key)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultLoadTimeout)
+	defer cancel()
+
+	if c := r.coherenceOf(); c != nil {
+		unlock, ok, err := r.tryLock(ctx, c, key)
+		switch {
+		case err != nil:
+			logger.DefaultLogger.Error(err.Error())
+		case !ok:
+			if _, ok := r.ValueMap.Load(key); ok {
+				r.stale.Store(key, seq)
 			}
+			go r.reloadAfterUnlock(c, key, policy, params, seq)
+			return
+		default:
+			defer unlock()
 		}
+	}
 
-		// 验证通过，触发缓存执行
-		// if val, ok := r.CacheFn[key]; ok {
-		if val, ok := r.CacheFn.Load(key); ok {
-			v := val.(*Policy).Call()
-			// v := val.Call()
-			// r.ValueMap[key] = v
-			r.ValueMap.Store(key, v)
-
-			if r.client != nil {
-				if err := r.SetValueV2(key, fmt.Sprint(v)); err != nil {
-					logger.DefaultLogger.Error(err.Error())
-				}
+	v, err := policy.load(ctx, params)
+	if err != nil {
+		if _, ok := r.ValueMap.Load(key); ok {
+			r.stale.Store(key, seq)
+		} else {
+			r.advance(key, seq)
+		}
+		logger.DefaultLogger.Error(fmt.Sprintf("refresh %s: %s", key, err.Error()))
+		return
+	}
+	r.recordType(policy, v)
+	if r.store(key, policy, gen, v) {
+		r.publish(key, v, seq)
+	}
+}
+
+// publish 同步到redis并更新版本，通知其他节点
+func (r *Repo) publish(key string, v interface{}, seq uint64) {
+	if r.client != nil {
+		if err := r.SetValueV2(key, v); err != nil {
+			logger.DefaultLogger.Error(err.Error())
+		}
+	}
+	r.advance(key, seq)
+	r.notifyPeers(opRefresh, seq, key)
+}
+
+// reloadAfterUnlock 等待其他节点释放锁之后重新加载，超时时删除缓存
+func (r *Repo) reloadAfterUnlock(c *coherence, key string, policy *Policy, params map[string]string, seq uint64) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.lockTTL)
+	defer cancel()
+	ticker := time.NewTicker(lockPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if n, err := r.client.Exists(ctx, "lock:"+key).Result(); err == nil && n == 0 {
+				r.reload(key, policy, params, seq)
+				return
 			}
+		case <-ctx.Done():
+			logger.DefaultLogger.Error(fmt.Sprintf("refresh %s: wait for lock timeout", key))
+			r.discard(key, seq)
+			return
+		}
+	}
+}
 
-			r.GenInstance(key)
-			r.WaitMap[key].Broadcast()
+// invalidate 删除缓存，下次获取时重新加载
+func (r *Repo) invalidate(key string, seq uint64) {
+	r.touch(key, seq)
+	r.discard(key, seq)
+}
+
+// discard 删除本地以及redis中的值，更新版本并通知其他节点
+func (r *Repo) discard(key string, seq uint64) {
+	r.bump(key)
+	r.deleteLocal(key)
+
+	if r.client != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := r.client.Del(ctx, key).Err(); err != nil {
+			logger.DefaultLogger.Error(err.Error())
+		}
+	}
+	r.advance(key, seq)
+	r.notifyPeers(opInvalidate, seq, key)
+}
+
+// invalidateAll 删除与模板匹配的全部缓存，包括redis中的值，其他节点删除各自缓存的匹配的key
+func (r *Repo) invalidateAll(tmpl *keyTemplate, seq uint64) {
+	r.deleteRemote(tmpl)
+	r.ValueMap.Range(func(k, _ interface{}) bool {
+		if _, ok := tmpl.match(k.(string)); ok {
+			r.invalidate(k.(string), seq)
 		}
 		return true
 	})
+	r.notifyPeers(opInvalidateAll, seq, tmpl.raw)
+}
+
+// deleteRemote 按照模板的前缀使用SCAN查找并删除redis中匹配的key
+// 模板以参数开头时无法按照前缀查找，不删除
+func (r *Repo) deleteRemote(tmpl *keyTemplate) {
+	prefix := tmpl.prefix()
+	if r.client == nil || prefix == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	var keys []string
+	del := func() {
+		if len(keys) == 0 {
+			return
+		}
+		if err := r.client.Del(ctx, keys...).Err(); err != nil {
+			logger.DefaultLogger.Error(err.Error())
+		}
+		keys = keys[:0]
+	}
+	iter := r.client.Scan(ctx, 0, escapeGlob(prefix)+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		if _, ok := tmpl.match(iter.Val()); ok {
+			keys = append(keys, iter.Val())
+		}
+		if len(keys) >= 100 {
+			del()
+		}
+	}
+	if err := iter.Err(); err != nil {
+		logger.DefaultLogger.Error(err.Error())
+	}
+	del()
 }
 
 // 后台线程 获取操作日志
@@ -223,11 +723,27 @@ func (r *Repo) Trigger(log dialet.ILogData) {
 // 2、更新了某一条记录之后，需要去判断出这条记录如何映射到缓存函数中
 // 操作记录: table、changes、payload；缓存函数：表名、
 // 这样才知道是更新哪一块缓存
+// 队列空闲超过TxIdleTimeout时完成当前的事务，每隔LocalSweepInterval删除过期的本地缓存
 func (r *Repo) Notify(ctx context.Context) {
+	idle := time.NewTimer(TxIdleTimeout)
+	defer idle.Stop()
+	sweep := time.NewTicker(LocalSweepInterval)
+	defer sweep.Stop()
 	for {
 		select {
 		case item := <-r.Chan:
 			r.Trigger(item)
+			if !idle.Stop() {
+				select {
+				case <-idle.C:
+				default:
+				}
+			}
+			idle.Reset(TxIdleTimeout)
+		case <-idle.C:
+			r.flushTx()
+		case <-sweep.C:
+			r.sweep()
 		case <-ctx.Done():
 			return
 		}
@@ -235,11 +751,8 @@ func (r *Repo) Notify(ctx context.Context) {
 }
 
 // wait a cache trigger update
-// 存在多个channel进行调用的情况
-func (r *Repo) Wait(key string) {
-	r.GenInstance(key)
-
-	r.WaitMap[key].L.Lock() // wired 需要先加锁
-	r.WaitMap[key].Wait()
-	r.WaitMap[key].L.Unlock()
+// 等待key的下一次更新，ctx结束时返回错误，调用之前发生的更新会被错过，需要时先记录Version再使用WaitFor
+func (r *Repo) Wait(ctx context.Context, key string) error {
+	_, err := r.WaitFor(ctx, key, r.Version(key)+1)
+	return err
 }
@@ -2,16 +2,35 @@ package datamanager
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/go-redis/redis/v8"
 	"github.com/wwqdrh/datamanager/dialet"
 )
 
 type testLog struct {
-	schema  string
-	table   string
-	payload map[string]interface{}
+	schema   string
+	table    string
+	label    string
+	payload  map[string]interface{}
+	changes  map[string]interface{}
+	txid     string
+	position uint64
+}
+
+func (t *testLog) GetTxId() string {
+	return t.txid
+}
+
+func (t *testLog) GetPosition() uint64 {
+	return t.position
 }
 
 func (t *testLog) GetSchema() string {
@@ -24,7 +43,10 @@ func (t *testLog) GetType() string {
 	return "ddl"
 } // 获取日志记录类型 ddl dml
 func (t *testLog) GetLabel() string {
-	return "insert"
+	if t.label == "" {
+		return "insert"
+	}
+	return t.label
 } // 具体标签 insert update delete | alter column, table
 func (t *testLog) GetTime() time.Time {
 	return time.Now()
@@ -33,7 +55,10 @@ func (t *testLog) GetPaylod() map[string]interface{} {
 	return t.payload
 } // 获取具体的负载对象
 func (t *testLog) GetChange() map[string]interface{} {
-	return map[string]interface{}{}
+	if t.changes == nil {
+		return map[string]interface{}{}
+	}
+	return t.changes
 }
 
 func TestSimpleRegister(t *testing.T) {
@@ -45,8 +70,8 @@ func TestSimpleRegister(t *testing.T) {
 		Key:   "cacheA",
 		Table: "tableA",
 		Field: "*",
-		Call: func() interface{} {
-			return cacheValue
+		Call: func(ctx context.Context) (interface{}, error) {
+			return cacheValue, nil
 		},
 	})
 	go r.Notify(ctx)
@@ -83,8 +108,8 @@ func TestUpdateRecord(t *testing.T) {
 		Key:   "cacheA",
 		Table: "table1",
 		Field: "field1",
-		Call: func() interface{} {
-			return fieldValue
+		Call: func(ctx context.Context) (interface{}, error) {
+			return fieldValue, nil
 		},
 	})
 	go r.Notify(ctx)
@@ -121,8 +146,8 @@ func TestCacheUpdateWait(t *testing.T) {
 		Key:   "cacheA",
 		Table: "table1",
 		Field: "field1",
-		Call: func() interface{} {
-			return fieldValue
+		Call: func(ctx context.Context) (interface{}, error) {
+			return fieldValue, nil
 		},
 	})
 	go r.Notify(ctx)
@@ -134,7 +159,9 @@ func TestCacheUpdateWait(t *testing.T) {
 	fieldValue = "value2"
 	ch <- &lg
 
-	r.Wait("cacheA") // 如果调用的时候错过了通知 会被一直阻塞在这
+	waitCtx, waitCancel := context.WithTimeout(ctx, time.Second)
+	defer waitCancel()
+	r.Wait(waitCtx, "cacheA") // 如果调用的时候错过了通知，超时之后返回
 
 	if val, ok := r.GetValue("cacheA").(string); !ok || val != "value2" {
 		t.Error()
@@ -142,3 +169,481 @@ func TestCacheUpdateWait(t *testing.T) {
 	cancel()
 	time.Sleep(1 * time.Second)
 }
+
+func TestKeyTemplate(t *testing.T) {
+	tmpl := parseKeyTemplate("order:{tenant_id}:{order_no}")
+	params, ok := tmpl.match("order:1:A-2")
+	if !ok || params["tenant_id"] != "1" || params["order_no"] != "A-2" {
+		t.Error(params)
+	}
+	if _, ok := tmpl.match("user:1"); ok {
+		t.Error("should not match")
+	}
+
+	key, _, ok := tmpl.render(map[string]interface{}{"tenant_id": float64(1), "order_no": "A-2"})
+	if !ok || key != "order:1:A-2" {
+		t.Error(key)
+	}
+	if _, _, ok := tmpl.render(map[string]interface{}{"tenant_id": 1}); ok {
+		t.Error("missing field")
+	}
+
+	if prefix := parseKeyTemplate("a*[b]:{id}").prefix(); escapeGlob(prefix) != `a\*\[b\]:` {
+		t.Error(escapeGlob(prefix))
+	}
+
+	// 大于1e6的数字不使用科学计数法
+	key, _, ok = tmpl.render(map[string]interface{}{"tenant_id": float64(1234567), "order_no": json.Number("12345678901")})
+	if !ok || key != "order:1234567:12345678901" {
+		t.Error(key)
+	}
+}
+
+func TestTemplateKeyTrigger(t *testing.T) {
+	loads := map[string]int{}
+	r := NewRepo(make(chan dialet.ILogData))
+	r.Register(&Policy{
+		Key:   "user:{tenant}:{id}",
+		Table: "users",
+		Field: "*",
+		Load: func(ctx context.Context, params map[string]string) (interface{}, error) {
+			key := params["tenant"] + ":" + params["id"]
+			loads[key]++
+			return fmt.Sprintf("%s#%d", key, loads[key]), nil
+		},
+	})
+
+	if val := r.GetValue("user:a:1"); val != "a:1#1" {
+		t.Error(val)
+	}
+	if val := r.GetValue("user:a:2"); val != "a:2#1" {
+		t.Error(val)
+	}
+	if val := r.GetValue("user:{tenant}:{id}"); val != nil {
+		t.Error(val)
+	}
+
+	// 只刷新修改的行
+	r.Trigger(&testLog{table: "users", label: "update", payload: map[string]interface{}{"tenant": "a", "id": 1, "name": "tom"}})
+	if val := r.GetValue("user:a:1"); val != "a:1#2" {
+		t.Error(val)
+	}
+	if val := r.GetValue("user:a:2"); val != "a:2#1" {
+		t.Error(val)
+	}
+
+	// 未缓存的行不加载
+	r.Trigger(&testLog{table: "users", label: "insert", payload: map[string]interface{}{"tenant": "a", "id": 3}})
+	if loads["a:3"] != 0 {
+		t.Error("should load lazily")
+	}
+
+	// 修改了key字段，旧key失效
+	r.Trigger(&testLog{table: "users", label: "update",
+		payload: map[string]interface{}{"tenant": "b", "id": 2},
+		changes: map[string]interface{}{"tenant": "a"},
+	})
+	if _, ok := r.ValueMap.Load("user:a:2"); ok {
+		t.Error("old key should be invalidated")
+	}
+
+	// 删除使用删除前的数据
+	r.Trigger(&testLog{table: "users", label: "delete", payload: map[string]interface{}{"tenant": "a", "id": 1}})
+	if _, ok := r.ValueMap.Load("user:a:1"); ok {
+		t.Error("deleted key should be invalidated")
+	}
+	if val := r.GetValue("user:a:1"); val != "a:1#3" {
+		t.Error(val)
+	}
+
+	r.Trigger(&testLog{table: "users", label: "truncate"})
+	if _, ok := r.ValueMap.Load("user:a:1"); ok {
+		t.Error("truncate should invalidate all keys")
+	}
+}
+
+func TestTemplateKeyRedis(t *testing.T) {
+	if os.Getenv("mode") != "local" {
+		t.Skip("no local env")
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:6379"})
+	defer client.Close()
+	ctx := context.Background()
+	defer client.Del(ctx, "template:1234567")
+
+	r := NewRepo(make(chan dialet.ILogData))
+	r.InitRedisCache(client)
+	r.Register(&Policy{
+		Key:   "template:{id}",
+		Table: "users",
+		Field: "*",
+		Load: func(ctx context.Context, params map[string]string) (interface{}, error) {
+			return params["id"], nil
+		},
+	})
+	if err := r.SetValueV2("template:1234567", "old"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 本地没有缓存的行也删除redis中的旧值
+	r.Trigger(&testLog{table: "users", label: "update", payload: map[string]interface{}{"id": float64(1234567)}})
+	if n, err := client.Exists(ctx, "template:1234567").Result(); err != nil || n != 0 {
+		t.Error("redis key should be deleted", n, err)
+	}
+	if val := r.GetValueV2("template:1234567"); val != "1234567" {
+		t.Error(val)
+	}
+}
+
+func TestSingleflightLoad(t *testing.T) {
+	var calls int32
+	r := NewRepo(make(chan dialet.ILogData))
+	r.Register(&Policy{
+		Key:   "cold",
+		Table: "tableA",
+		Field: "*",
+		Call: func(ctx context.Context) (interface{}, error) {
+			atomic.AddInt32(&calls, 1)
+			time.Sleep(50 * time.Millisecond)
+			return "value", nil
+		},
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if val := r.GetValue("cold"); val != "value" {
+				t.Error(val)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("loader called %d times", n)
+	}
+}
+
+func TestLoadErrorNotCached(t *testing.T) {
+	var fail int32 = 1
+	r := NewRepo(make(chan dialet.ILogData))
+	r.Register(&Policy{
+		Key:   "count",
+		Table: "tableA",
+		Field: "*",
+		Call: func(ctx context.Context) (interface{}, error) {
+			if atomic.LoadInt32(&fail) == 1 {
+				return nil, errors.New("db down")
+			}
+			return 10, nil
+		},
+	})
+
+	if _, err := r.Get(context.Background(), "count"); err == nil {
+		t.Error("expect error")
+	}
+	if _, ok := r.ValueMap.Load("count"); ok {
+		t.Error("error should not be cached")
+	}
+	if _, err := r.Get(context.Background(), "missing"); err != ErrNoPolicy {
+		t.Error(err)
+	}
+
+	atomic.StoreInt32(&fail, 0)
+	if val, err := r.Get(context.Background(), "count"); err != nil || val != 10 {
+		t.Error(val, err)
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	var (
+		fail  int32
+		value int32 = 1
+	)
+	r := NewRepo(make(chan dialet.ILogData))
+	r.Register(&Policy{
+		Key:   "count",
+		Table: "tableA",
+		Field: "*",
+		Call: func(ctx context.Context) (interface{}, error) {
+			if atomic.LoadInt32(&fail) == 1 {
+				return nil, errors.New("db down")
+			}
+			return atomic.LoadInt32(&value), nil
+		},
+	})
+	if val := r.GetValue("count"); val != int32(1) {
+		t.Error(val)
+	}
+
+	// 刷新失败时保留旧值
+	atomic.StoreInt32(&fail, 1)
+	atomic.StoreInt32(&value, 2)
+	r.Trigger(&testLog{table: "tableA"})
+	if val := r.GetValue("count"); val != int32(1) {
+		t.Error(val)
+	}
+
+	// 恢复后获取时在后台重新加载
+	atomic.StoreInt32(&fail, 0)
+	if val := r.GetValue("count"); val != int32(1) {
+		t.Error(val)
+	}
+	deadline := time.Now().Add(time.Second)
+	for r.GetValue("count") != int32(2) {
+		if time.Now().After(deadline) {
+			t.Fatal("not revalidated")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, stale := r.stale.Load("count"); stale {
+		t.Error("should not be stale")
+	}
+}
+
+func TestWaitForVersion(t *testing.T) {
+	var (
+		fail  int32
+		value int32 = 1
+	)
+	r := NewRepo(make(chan dialet.ILogData))
+	r.Register(&Policy{
+		Key:   "count",
+		Table: "tableA",
+		Field: "*",
+		Call: func(ctx context.Context) (interface{}, error) {
+			if atomic.LoadInt32(&fail) == 1 {
+				return nil, errors.New("db down")
+			}
+			return atomic.LoadInt32(&value), nil
+		},
+	})
+	r.GetValue("count")
+
+	// 更新发生在等待之前也不会错过
+	version := r.Version("count")
+	atomic.StoreInt32(&value, 2)
+	r.Trigger(&testLog{table: "tableA"})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if v, err := r.WaitFor(ctx, "count", version+1); err != nil || v != version+1 {
+		t.Error(v, err)
+	}
+	if val := r.GetValue("count"); val != int32(2) {
+		t.Error(val)
+	}
+
+	// 没有更新时超时返回
+	timeout, cancelTimeout := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancelTimeout()
+	if _, err := r.WaitFor(timeout, "count", r.Version("count")+1); err != context.DeadlineExceeded {
+		t.Error(err)
+	}
+
+	// 刷新失败时版本不变，重新加载成功之后才更新
+	version = r.Version("count")
+	atomic.StoreInt32(&fail, 1)
+	r.Trigger(&testLog{table: "tableA"})
+	if r.Version("count") != version {
+		t.Error("stale key should keep version")
+	}
+	atomic.StoreInt32(&fail, 0)
+	r.GetValue("count")
+	if _, err := r.WaitFor(ctx, "count", version+1); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWaitTx(t *testing.T) {
+	var value int32 = 1
+	ch := make(chan dialet.ILogData, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r := NewRepo(ch)
+	r.Register(&Policy{
+		Key:   "user:{id}",
+		Table: "users",
+		Field: "*",
+		Load: func(ctx context.Context, params map[string]string) (interface{}, error) {
+			return fmt.Sprintf("%s-%d", params["id"], atomic.LoadInt32(&value)), nil
+		},
+	})
+	r.Register(&Policy{
+		Key:   "users",
+		Table: "users",
+		Field: "*",
+		Call: func(ctx context.Context) (interface{}, error) {
+			return atomic.LoadInt32(&value), nil
+		},
+	})
+	r.GetValue("user:1")
+	r.GetValue("user:2")
+	r.GetValue("users")
+	go r.Notify(ctx)
+
+	atomic.StoreInt32(&value, 2)
+	ch <- &testLog{table: "users", label: "update", txid: "100", payload: map[string]interface{}{"id": 1}}
+	ch <- &testLog{table: "users", label: "delete", txid: "100", payload: map[string]interface{}{"id": 2}}
+
+	waitCtx, cancelWait := context.WithTimeout(ctx, time.Second)
+	defer cancelWait()
+	if err := r.WaitTx(waitCtx, "100"); err != nil {
+		t.Fatal(err)
+	}
+	if val := r.GetValue("user:1"); val != "1-2" {
+		t.Error(val)
+	}
+	if _, ok := r.ValueMap.Load("user:2"); ok {
+		t.Error("deleted key should be invalidated")
+	}
+	if val := r.GetValue("users"); val != int32(2) {
+		t.Error(val)
+	}
+
+	// 未知的事务等待超时
+	timeout, cancelTimeout := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancelTimeout()
+	if err := r.WaitTx(timeout, "101"); err != context.DeadlineExceeded {
+		t.Error(err)
+	}
+}
+
+func TestTxMerge(t *testing.T) {
+	r := NewRepo(make(chan dialet.ILogData))
+	r.Register(&Policy{
+		Key:   "user:{id}",
+		Table: "users",
+		Field: "*",
+		Load: func(ctx context.Context, params map[string]string) (interface{}, error) {
+			return params["id"], nil
+		},
+	})
+
+	// 收到其他事务的事件之后才完成
+	r.Trigger(&testLog{table: "users", label: "update", txid: "200", payload: map[string]interface{}{"id": 1}})
+	if _, ok := r.TxVersion("200"); ok {
+		t.Error("tx 200 should not be completed")
+	}
+	r.Trigger(&testLog{table: "users", label: "update", txid: "201", payload: map[string]interface{}{"id": 2}})
+	version, ok := r.TxVersion("200")
+	if !ok || version != 1 {
+		t.Error(version, ok)
+	}
+
+	// 再次出现的事务合并到已经完成的记录中
+	r.Trigger(&testLog{table: "users", label: "update", txid: "200", payload: map[string]interface{}{"id": 3}})
+	r.flushTx()
+	if version, _ := r.TxVersion("200"); version != 3 {
+		t.Error(version)
+	}
+	if keys := r.tx.done["200"].keys; len(keys) != 2 || keys["user:1"] != 1 || keys["user:3"] != 3 {
+		t.Error(keys)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := r.WaitTx(ctx, "200"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVersionBounded(t *testing.T) {
+	r := NewRepo(make(chan dialet.ILogData))
+	r.Register(&Policy{
+		Key:   "user:{id}",
+		Table: "users",
+		Field: "*",
+		Load: func(ctx context.Context, params map[string]string) (interface{}, error) {
+			return params["id"], nil
+		},
+	})
+	r.GetValue("user:1")
+
+	// 没有缓存的key不保留版本
+	for i := 2; i < 100; i++ {
+		r.Trigger(&testLog{table: "users", label: "update", payload: map[string]interface{}{"id": i}})
+	}
+	if r.Version("user:1000") != 98 || len(r.states) != 0 || len(r.gens) != 0 {
+		t.Error(r.Version("user:1000"), len(r.states), len(r.gens))
+	}
+
+	// 失效之后删除，版本不会减小
+	r.Trigger(&testLog{table: "users", label: "update", payload: map[string]interface{}{"id": 1}})
+	if r.Version("user:1") != 99 || len(r.states) != 1 {
+		t.Error(r.Version("user:1"), len(r.states))
+	}
+	r.Trigger(&testLog{table: "users", label: "delete", payload: map[string]interface{}{"id": 1}})
+	if r.Version("user:1") != 100 || len(r.states) != 0 || len(r.gens) != 0 {
+		t.Error(r.Version("user:1"), len(r.states), len(r.gens))
+	}
+
+	// 等待结束之后删除
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := r.WaitFor(ctx, "user:5", 101); err != context.DeadlineExceeded {
+		t.Error(err)
+	}
+	if len(r.states) != 0 {
+		t.Error(len(r.states))
+	}
+}
+
+func TestVersionPosition(t *testing.T) {
+	r := NewRepo(make(chan dialet.ILogData))
+	r.Register(&Policy{
+		Key:   "count",
+		Table: "tableA",
+		Field: "*",
+		Call: func(ctx context.Context) (interface{}, error) {
+			return 1, nil
+		},
+	})
+	r.GetValue("count")
+
+	// 版本为事件在源数据库中的位置，写入方使用修改之前的位置等待
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	r.Trigger(&testLog{table: "tableA", position: 1000})
+	if v, err := r.WaitFor(ctx, "count", 990); err != nil || v != 1000 {
+		t.Error(v, err)
+	}
+
+	// 没有位置或者位置更小时继续递增
+	r.Trigger(&testLog{table: "tableA"})
+	r.Trigger(&testLog{table: "tableA", position: 500})
+	if v := r.Version("count"); v != 1002 {
+		t.Error(v)
+	}
+
+	// 其他节点的消息使用处理变更的节点中的序号
+	r.coherence = &coherence{node: "a"}
+	r.applyRemote(coherenceMessage{Node: "b", Op: opInvalidate, Keys: []string{"count"}, Version: 5000})
+	if v := r.Version("count"); v != 5000 {
+		t.Error(v)
+	}
+}
+
+func TestGenInstance(t *testing.T) {
+	r := NewRepo(make(chan dialet.ILogData))
+	r.Register(&Policy{
+		Key:   "count",
+		Table: "tableA",
+		Field: "*",
+		Call: func(ctx context.Context) (interface{}, error) {
+			return 1, nil
+		},
+	})
+	r.GenInstance("count")
+
+	// 兼容旧版本直接使用WaitMap等待
+	cond := r.WaitMap["count"]
+	cond.L.Lock()
+	go r.Trigger(&testLog{table: "tableA"})
+	cond.Wait()
+	cond.L.Unlock()
+	if r.Version("count") == 0 {
+		t.Error("version should be advanced")
+	}
+}
@@ -0,0 +1,138 @@
+package datamanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// 缓存key模板，例如 user:{id}、order:{tenant_id}:{order_no}
+// 参数名为数据表的字段，从日志的负载中取值
+
+var keyParamPattern = regexp.MustCompile(`\{([^{}]+)\}`)
+
+type keyTemplate struct {
+	raw     string
+	fields  []string
+	pattern *regexp.Regexp
+}
+
+func parseKeyTemplate(key string) *keyTemplate {
+	t := &keyTemplate{raw: key}
+	matches := keyParamPattern.FindAllStringSubmatchIndex(key, -1)
+	if len(matches) == 0 {
+		return t
+	}
+
+	var (
+		expr strings.Builder
+		pos  int
+	)
+	expr.WriteString("^")
+	for _, m := range matches {
+		expr.WriteString(regexp.QuoteMeta(key[pos:m[0]]))
+		expr.WriteString("(.+?)")
+		t.fields = append(t.fields, key[m[2]:m[3]])
+		pos = m[1]
+	}
+	expr.WriteString(regexp.QuoteMeta(key[pos:]))
+	expr.WriteString("$")
+	t.pattern = regexp.MustCompile(expr.String())
+	return t
+}
+
+// isTemplate 是否包含参数
+func (t *keyTemplate) isTemplate() bool {
+	return len(t.fields) > 0
+}
+
+// prefix 第一个参数之前的部分
+func (t *keyTemplate) prefix() string {
+	if m := keyParamPattern.FindStringIndex(t.raw); m != nil {
+		return t.raw[:m[0]]
+	}
+	return t.raw
+}
+
+// escapeGlob 转义redis SCAN MATCH中的特殊字符
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// match 从具体的key中解析参数
+func (t *keyTemplate) match(key string) (map[string]string, bool) {
+	if !t.isTemplate() {
+		return nil, key == t.raw
+	}
+
+	values := t.pattern.FindStringSubmatch(key)
+	if values == nil {
+		return nil, false
+	}
+	params := make(map[string]string, len(t.fields))
+	for i, field := range t.fields {
+		if v, ok := params[field]; ok && v != values[i+1] {
+			return nil, false
+		}
+		params[field] = values[i+1]
+	}
+	return params, true
+}
+
+// render 使用行数据生成具体的key，缺少字段或者字段为null时返回false
+func (t *keyTemplate) render(row map[string]interface{}) (string, map[string]string, bool) {
+	if !t.isTemplate() {
+		return t.raw, nil, true
+	}
+
+	params := make(map[string]string, len(t.fields))
+	for _, field := range t.fields {
+		v, ok := row[field]
+		if !ok || v == nil {
+			return "", nil, false
+		}
+		params[field] = formatParam(v)
+	}
+
+	key := keyParamPattern.ReplaceAllStringFunc(t.raw, func(s string) string {
+		return params[s[1:len(s)-1]]
+	})
+	return key, params, true
+}
+
+// formatParam json解码之后数字为float64，不使用科学计数法，例如1234567而不是1.234567e+06
+func formatParam(v interface{}) string {
+	switch v := v.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case json.Number:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// previousImage 修改之前的行数据，changes中记录了变化字段的旧值
+// 删除时负载即为删除前的数据
+func previousImage(payload, changes map[string]interface{}) map[string]interface{} {
+	res := make(map[string]interface{}, len(payload))
+	for k, v := range payload {
+		res[k] = v
+	}
+	for k, v := range changes {
+		res[k] = v
+	}
+	return res
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/wwqdrh/datamanager/dialet/mysql"
+)
+
+var (
+	dsn            *string = flag.String("dsn", "", "用于读取表结构的mysql dsn: [user]:[password]@tcp([host]:[port])/")
+	schemaFile     *string = flag.String("schema", "", "mysqldump --no-data导出的表结构文件，未指定dsn时使用")
+	schemaDb       *string = flag.String("schema-db", "", "表结构文件中没有USE语句时使用的库名")
+	tables         *string = flag.String("tables", "", "只解析这些表，逗号分隔")
+	schemas        *string = flag.String("schemas", "", "只解析这些库，逗号分隔")
+	excludeTables  *string = flag.String("exclude-tables", "", "忽略这些表，逗号分隔")
+	excludeSchemas *string = flag.String("exclude-schemas", "", "忽略这些库，逗号分隔")
+	filter         *string = flag.String("filter", "", "过滤表达式，例如: type = delete and user_id = 42")
+	startPosition  *uint   = flag.Uint("start-position", 0, "第一个文件的起始位置")
+	stopPosition   *uint   = flag.Uint("stop-position", 0, "最后一个文件的结束位置")
+	startDatetime  *string = flag.String("start-datetime", "", "起始时间: 2006-01-02 15:04:05")
+	stopDatetime   *string = flag.String("stop-datetime", "", "结束时间: 2006-01-02 15:04:05")
+	format         *string = flag.String("format", "json", "输出格式: json(每行一条) pretty sql undo transactions(每个事务一条)")
+	output         *string = flag.String("o", "", "输出文件，默认为标准输出")
+	resilient      *bool   = flag.Bool("resilient", false, "跳过无法转换的事件而不是终止")
+	deadLetter     *string = flag.String("dead-letter", "", "resilient模式下无法转换的事件写入该文件")
+)
+
+const datetimeLayout = "2006-01-02 15:04:05"
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] binlog_path...\n  binlog_path可以是文件、目录或者glob表达式\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+}
+
+// binlog转换为json或者sql
+// 1、加载表结构
+// 2、构建过滤条件以及输出
+// 3、按顺序解析所有binlog文件
+func main() {
+	flag.Parse()
+	if flag.NArg() == 0 || (*dsn == "" && *schemaFile == "") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	tableMap, closeTableMap, err := loadTableMap()
+	if err != nil {
+		return err
+	}
+	defer closeTableMap()
+
+	options, err := parseOptions()
+	if err != nil {
+		return err
+	}
+
+	if *deadLetter != "" {
+		f, err := os.Create(*deadLetter)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		options.DeadLetter = mysql.NewJsonDeadLetterSink(f)
+	}
+
+	var stream io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		stream = f
+	}
+
+	chain, flashback, err := buildConsumerChain(stream)
+	if err != nil {
+		return err
+	}
+
+	var binlogFilenames []string
+	for _, path := range flag.Args() {
+		files, err := mysql.ListBinlogFiles(path)
+		if err != nil {
+			return err
+		}
+		binlogFilenames = append(binlogFilenames, files...)
+	}
+
+	var stats mysql.ParseStats
+	if *format == "transactions" {
+		stats, err = mysql.ParseBinlogFilesToTransactions(binlogFilenames, tableMap, options, chain.ConsumeTransaction)
+	} else {
+		stats, err = mysql.ParseBinlogFilesToMessages(binlogFilenames, tableMap, options, chain.Consume)
+	}
+	if err != nil {
+		return err
+	}
+
+	if flashback != nil {
+		if err := flashback.WriteUndoSql(stream); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "files: %d, events: %d, messages: %d, transactions: %d, skipped: %d, dead-lettered: %d\n",
+		stats.Files, stats.Events, stats.Messages, stats.Transactions, stats.Skipped, stats.DeadLettered)
+
+	return nil
+}
+
+func loadTableMap() (mysql.TableMap, func(), error) {
+	if *dsn != "" {
+		db, err := mysql.GetDatabaseInstance(*dsn)
+		if err != nil {
+			return mysql.TableMap{}, nil, err
+		}
+		return mysql.NewTableMap(db), func() { db.Close() }, nil
+	}
+
+	f, err := os.Open(*schemaFile)
+	if err != nil {
+		return mysql.TableMap{}, nil, err
+	}
+	defer f.Close()
+
+	tableMap := mysql.NewTableMap(nil)
+	if err := tableMap.LoadSchemaDump(f, *schemaDb); err != nil {
+		return mysql.TableMap{}, nil, err
+	}
+	return tableMap, func() {}, nil
+}
+
+func parseOptions() (mysql.ParseOptions, error) {
+	options := mysql.ParseOptions{
+		StartPosition: uint32(*startPosition),
+		StopPosition:  uint32(*stopPosition),
+		Resilient:     *resilient,
+	}
+
+	var err error
+	if *startDatetime != "" {
+		if options.StartDatetime, err = time.ParseInLocation(datetimeLayout, *startDatetime, time.Local); err != nil {
+			return options, fmt.Errorf("invalid start-datetime: %w", err)
+		}
+	}
+	if *stopDatetime != "" {
+		if options.StopDatetime, err = time.ParseInLocation(datetimeLayout, *stopDatetime, time.Local); err != nil {
+			return options, fmt.Errorf("invalid stop-datetime: %w", err)
+		}
+	}
+
+	return options, nil
+}
+
+// undo格式需要在解析完成后逆序输出，因此返回flashback
+func buildConsumerChain(stream io.Writer) (mysql.ConsumerChain, *mysql.Flashback, error) {
+	chain := mysql.NewConsumerChain()
+
+	if list := splitList(*tables); len(list) > 0 {
+		chain.IncludeTables(list...)
+	}
+	if list := splitList(*schemas); len(list) > 0 {
+		chain.IncludeSchemas(list...)
+	}
+	if list := splitList(*excludeTables); len(list) > 0 {
+		chain.ExcludeTables(list...)
+	}
+	if list := splitList(*excludeSchemas); len(list) > 0 {
+		chain.ExcludeSchemas(list...)
+	}
+	if *filter != "" {
+		if err := chain.FilterExpression(*filter); err != nil {
+			return chain, nil, err
+		}
+	}
+
+	var flashback *mysql.Flashback
+	switch *format {
+	case "json":
+		chain.CollectAsJson(stream, false)
+	case "pretty":
+		chain.CollectAsJson(stream, true)
+	case "sql":
+		chain.CollectAsSql(stream)
+	case "undo":
+		flashback = mysql.NewFlashback()
+		chain.CollectAsFlashback(flashback)
+	case "transactions":
+		chain.CollectTransactionsAsJson(stream, false)
+	default:
+		return chain, nil, fmt.Errorf("unknown format %s", *format)
+	}
+
+	return chain, flashback, nil
+}
+
+func splitList(value string) []string {
+	var ret []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			ret = append(ret, item)
+		}
+	}
+	return ret
+}
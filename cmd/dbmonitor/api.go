@@ -1,6 +1,13 @@
 package main
 
-import "github.com/gin-gonic/gin"
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wwqdrh/datamanager/transport"
+)
 
 func InitRouter(engine *gin.Engine) {
 	engine.GET("/health", func(ctx *gin.Context) {
@@ -10,6 +17,12 @@ func InitRouter(engine *gin.Engine) {
 	engine.GET("/unregister", UnRegister)
 	engine.GET("/search", Search)
 	engine.POST("/callback", AddCallback)
+	engine.GET("/versions", Versions)
+	engine.GET("/diff", VersionDiff)
+	engine.POST("/restore", Restore)
+	engine.GET("/snapshot", Snapshot)
+	engine.GET("/consistency", Consistency)
+	engine.GET("/verify", Verify)
 }
 
 func Register(ctx *gin.Context) {
@@ -44,6 +57,105 @@ type SearchReq struct {
 	Key   string `json:"key" form:"key"`
 	Value string `json:"value" form:"value"`
 	Table string `json:"table" form:"table"`
+
+	Schema  string   `json:"schema" form:"schema"`
+	Op      string   `json:"op" form:"op"`           // 多个使用逗号分隔
+	Id      string   `json:"id" form:"id"`           // 记录主键
+	Start   string   `json:"start" form:"start"`     // RFC3339
+	End     string   `json:"end" form:"end"`         // RFC3339
+	Where   []string `json:"where" form:"where"`     // 字段条件，例如 age>=18、address.city=beijing
+	Changed string   `json:"changed" form:"changed"` // 发生变更的字段，多个使用逗号分隔
+	Q       string   `json:"q" form:"q"`             // 全文搜索
+	Order   string   `json:"order" form:"order"`     // asc desc
+	Limit   int      `json:"limit" form:"limit"`
+	Offset  int      `json:"offset" form:"offset"`
+}
+
+type SearchResp struct {
+	Total   int                `json:"total"`
+	Records []transport.Record `json:"records"`
+}
+
+var whereOperators = []string{">=", "<=", "!=", "=", ">", "<"}
+
+// Query 转换为存储端的查询条件
+// 没有指定schema时table可以为schema.table，否则schema为public，兼容旧版本的table=public_notes&key=...&value=...
+func (r SearchReq) Query() (transport.Query, error) {
+	q := transport.Query{
+		Schema:   r.Schema,
+		Table:    r.Table,
+		RecordId: r.Id,
+		Text:     r.Q,
+		Desc:     strings.EqualFold(r.Order, "desc"),
+		Limit:    r.Limit,
+		Offset:   r.Offset,
+	}
+	if q.Schema == "" && q.Table != "" {
+		q.Schema, q.Table = splitSearchTable(q.Table)
+	}
+	if q.Limit <= 0 || q.Limit > 1000 {
+		q.Limit = 100
+	}
+
+	var err error
+	if r.Start != "" {
+		if q.Start, err = time.Parse(time.RFC3339, r.Start); err != nil {
+			return q, fmt.Errorf("invalid start: %w", err)
+		}
+	}
+	if r.End != "" {
+		if q.End, err = time.Parse(time.RFC3339, r.End); err != nil {
+			return q, fmt.Errorf("invalid end: %w", err)
+		}
+	}
+	if r.Op != "" {
+		q.Ops = strings.Split(r.Op, ",")
+	}
+	if r.Changed != "" {
+		q.Changed = strings.Split(r.Changed, ",")
+	}
+
+	if r.Key != "" {
+		q.Fields = append(q.Fields, transport.FieldCondition{Path: r.Key, Op: "=", Value: r.Value})
+	}
+	for _, where := range r.Where {
+		cond, err := parseWhere(where)
+		if err != nil {
+			return q, err
+		}
+		q.Fields = append(q.Fields, cond)
+	}
+	return q, nil
+}
+
+// splitSearchTable 表名中可能包含下划线，只有public_前缀按照旧版本的写法拆分
+func splitSearchTable(table string) (string, string) {
+	if index := strings.Index(table, "."); index >= 0 {
+		return table[:index], table[index+1:]
+	}
+	if name := strings.TrimPrefix(table, "public_"); name != table && name != "" {
+		return "public", name
+	}
+	return "public", table
+}
+
+// parseWhere 在最早出现的运算符处拆分，值中可以包含运算符，例如note=a>=b
+func parseWhere(where string) (transport.FieldCondition, error) {
+	index, op := -1, ""
+	for _, candidate := range whereOperators {
+		i := strings.Index(where, candidate)
+		if i > 0 && (index < 0 || i < index || i == index && len(candidate) > len(op)) {
+			index, op = i, candidate
+		}
+	}
+	if index < 0 {
+		return transport.FieldCondition{}, fmt.Errorf("invalid where: %s", where)
+	}
+	return transport.FieldCondition{
+		Path:  strings.TrimSpace(where[:index]),
+		Op:    op,
+		Value: strings.TrimSpace(where[index+len(op):]),
+	}, nil
 }
 
 func Search(ctx *gin.Context) {
@@ -52,16 +164,27 @@ func Search(ctx *gin.Context) {
 		ctx.String(400, "请传入key、value以及table")
 		return
 	}
+	q, err := r.Query()
+	if err != nil {
+		ctx.String(400, err.Error())
+		return
+	}
 
 	if sqlite3transport == nil {
 		ctx.String(500, "未初始化完成，稍后重试")
 		return
 	}
-	if data, err := sqlite3transport.Search(r.Table, r.Key, r.Value); err != nil {
-		ctx.String(200, err.Error())
-	} else {
-		ctx.JSON(200, data)
+	total, err := sqlite3transport.Count(q)
+	if err != nil {
+		ctx.String(400, err.Error())
+		return
+	}
+	records, err := sqlite3transport.Query(q)
+	if err != nil {
+		ctx.String(400, err.Error())
+		return
 	}
+	ctx.JSON(200, SearchResp{Total: total, Records: records})
 }
 
 type AddCallbackReq struct {
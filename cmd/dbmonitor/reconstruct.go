@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wwqdrh/datamanager/restore"
+	"github.com/wwqdrh/datamanager/transport"
+	"github.com/wwqdrh/datamanager/transport/sqlite"
+)
+
+type SnapshotReq struct {
+	Schema string `json:"schema" form:"schema"`
+	Table  string `json:"table" form:"table"`
+	At     string `json:"at" form:"at"`         // RFC3339，为空时为当前状态
+	Format string `json:"format" form:"format"` // json或者csv
+	Into   string `json:"into" form:"into"`     // 写入源数据库中的新表
+}
+
+func (r SnapshotReq) target() (string, string) {
+	return RestoreReq{Schema: r.Schema, Table: r.Table}.target()
+}
+
+// 数据表在某个时间点的状态
+func Snapshot(ctx *gin.Context) {
+	var r SnapshotReq
+	if err := ctx.ShouldBindQuery(&r); err != nil || r.Table == "" {
+		ctx.String(400, "请传入table")
+		return
+	}
+	if restorer == nil {
+		ctx.String(500, "未初始化完成，稍后重试")
+		return
+	}
+	at, err := parseAt(r.At)
+	if err != nil {
+		ctx.String(400, "at格式错误: "+err.Error())
+		return
+	}
+
+	schema, table := r.target()
+	snapshot, err := restorer.Reconstruct(ctx.Request.Context(), schema, table, at)
+	if err != nil {
+		ctx.String(400, err.Error())
+		return
+	}
+	switch {
+	case r.Into != "":
+		count, err := restorer.Materialize(ctx.Request.Context(), snapshot, r.Into)
+		if err != nil {
+			ctx.String(400, err.Error())
+			return
+		}
+		ctx.JSON(200, gin.H{"table": r.Into, "rows": count})
+	case r.Format == "csv":
+		ctx.Header("Content-Type", "text/csv; charset=utf-8")
+		if err := snapshot.WriteCSV(ctx.Writer); err != nil {
+			ctx.String(500, err.Error())
+		}
+	default:
+		ctx.Header("Content-Type", "application/json; charset=utf-8")
+		if err := snapshot.WriteJSON(ctx.Writer); err != nil {
+			ctx.String(500, err.Error())
+		}
+	}
+}
+
+// 比较回放得到的当前状态与实际的数据表
+func Consistency(ctx *gin.Context) {
+	var r SnapshotReq
+	if err := ctx.ShouldBindQuery(&r); err != nil || r.Table == "" {
+		ctx.String(400, "请传入table")
+		return
+	}
+	if restorer == nil {
+		ctx.String(500, "未初始化完成，稍后重试")
+		return
+	}
+
+	schema, table := r.target()
+	if res, err := restorer.Check(ctx.Request.Context(), schema, table); err != nil {
+		ctx.String(400, err.Error())
+	} else {
+		ctx.JSON(200, gin.H{"consistent": res.Consistent(), "result": res})
+	}
+}
+
+// reconstructCommand dbmonitor reconstruct -history data.db -table public.prices -at 2022-07-01T09:00:00+08:00 [-format csv] [-o file] [-into table -dsn ...] [-check -dsn ...]
+func reconstructCommand(args []string) int {
+	fs := flag.NewFlagSet("reconstruct", flag.ExitOnError)
+	var (
+		history = fs.String("history", "data.db", "sqlite历史记录文件")
+		source  = fs.String("source", "", "其他的历史存储端，例如postgres?dsn=...，指定时忽略-history")
+		dsn     = fs.String("dsn", "", "源数据库的postgres dsn，-into以及-check需要")
+		table   = fs.String("table", "", "数据表，schema.table")
+		pk      = fs.String("pk", restore.DefaultPrimaryKey, "主键列名")
+		at      = fs.String("at", "", "时间点，RFC3339或者2006-01-02 15:04:05(本地时间)，为空时为当前状态")
+		format  = fs.String("format", "json", "导出格式，json或者csv")
+		output  = fs.String("o", "", "导出的文件，默认输出到标准输出")
+		into    = fs.String("into", "", "写入源数据库中的新表，schema.table")
+		check   = fs.Bool("check", false, "比较回放得到的当前状态与实际的数据表")
+	)
+	fs.Parse(args)
+	if *table == "" {
+		fs.Usage()
+		return 2
+	}
+	if (*into != "" || *check) && *dsn == "" {
+		fmt.Fprintln(os.Stderr, "-into以及-check需要-dsn")
+		return 2
+	}
+	t, err := parseAt(*at)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	sink, err := openHistory(*history, *source)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer sink.Close()
+
+	var db *sql.DB
+	if *dsn != "" {
+		if db, err = sql.Open("postgres", *dsn); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer db.Close()
+	}
+
+	r := restore.NewRestorer(sink, db).SetPrimaryKey(*pk)
+	schema, name := splitTable(*table)
+	ctx := context.Background()
+
+	if *check {
+		res, err := r.Check(ctx, schema, name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		data, _ := json.MarshalIndent(res, "", "  ")
+		fmt.Println(string(data))
+		if !res.Consistent() {
+			return 1
+		}
+		return 0
+	}
+
+	snapshot, err := r.Reconstruct(ctx, schema, name, t)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if snapshot.Skipped > 0 {
+		fmt.Fprintf(os.Stderr, "%d条记录没有主键，已忽略\n", snapshot.Skipped)
+	}
+
+	if *into != "" {
+		count, err := r.Materialize(ctx, snapshot, *into)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "已写入%s，共%d行\n", *into, count)
+		return 0
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+	if *format == "csv" {
+		err = snapshot.WriteCSV(w)
+	} else {
+		err = snapshot.WriteJSON(w)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// openHistory spec为空时打开sqlite历史记录文件，否则按照存储端配置创建
+func openHistory(path, spec string) (transport.Transport, error) {
+	if spec == "" {
+		return sqlite.NewSqliteTransport(path)
+	}
+	confs, err := transport.ParseConfigs(spec)
+	if err != nil {
+		return nil, err
+	}
+	if len(confs) != 1 {
+		return nil, fmt.Errorf("-source只能指定一个存储端: %s", spec)
+	}
+	return transport.New(confs[0])
+}
+
+func parseAt(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02 15:04:05", s, time.Local)
+}
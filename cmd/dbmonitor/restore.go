@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wwqdrh/datamanager/restore"
+	"github.com/wwqdrh/datamanager/transport/sqlite"
+)
+
+type RestoreReq struct {
+	Schema  string `json:"schema" form:"schema"`
+	Table   string `json:"table" form:"table"`
+	Id      string `json:"id" form:"id"`
+	From    int    `json:"from" form:"from"`
+	To      int    `json:"to" form:"to"`
+	Version int    `json:"version" form:"version"`
+	Confirm bool   `json:"confirm" form:"confirm"`
+}
+
+// 没有指定schema时table为schema.table，不包含.时schema为public
+func (r RestoreReq) target() (string, string) {
+	if r.Schema != "" {
+		return r.Schema, r.Table
+	}
+	return splitTable(r.Table)
+}
+
+// splitTable 只按照.拆分，表名中可能包含下划线
+func splitTable(table string) (string, string) {
+	if index := strings.Index(table, "."); index >= 0 {
+		return table[:index], table[index+1:]
+	}
+	return "public", table
+}
+
+func checkRestorer(ctx *gin.Context, r RestoreReq) bool {
+	if restorer == nil {
+		ctx.String(500, "未初始化完成，稍后重试")
+		return false
+	}
+	if r.Table == "" || r.Id == "" {
+		ctx.String(400, "请传入table以及id")
+		return false
+	}
+	return true
+}
+
+// 查看记录的全部版本
+func Versions(ctx *gin.Context) {
+	var r RestoreReq
+	if err := ctx.ShouldBindQuery(&r); err != nil {
+		ctx.String(400, err.Error())
+		return
+	}
+	if !checkRestorer(ctx, r) {
+		return
+	}
+
+	schema, table := r.target()
+	if versions, err := restorer.Versions(schema, table, r.Id); err != nil {
+		ctx.String(400, err.Error())
+	} else {
+		ctx.JSON(200, versions)
+	}
+}
+
+// 比较两个版本
+func VersionDiff(ctx *gin.Context) {
+	var r RestoreReq
+	if err := ctx.ShouldBindQuery(&r); err != nil {
+		ctx.String(400, err.Error())
+		return
+	}
+	if !checkRestorer(ctx, r) {
+		return
+	}
+
+	schema, table := r.target()
+	if diff, err := restorer.Diff(schema, table, r.Id, r.From, r.To); err != nil {
+		ctx.String(400, err.Error())
+	} else {
+		ctx.JSON(200, diff)
+	}
+}
+
+// 恢复到指定版本，confirm为false时只返回sql
+func Restore(ctx *gin.Context) {
+	var r RestoreReq
+	if err := ctx.ShouldBindJSON(&r); err != nil {
+		ctx.String(400, "请传入table、id以及version")
+		return
+	}
+	if !checkRestorer(ctx, r) {
+		return
+	}
+
+	schema, table := r.target()
+	statements, err := restorer.Restore(ctx.Request.Context(), schema, table, r.Id, r.Version, r.Confirm)
+	if err != nil {
+		ctx.String(400, err.Error())
+		return
+	}
+	ctx.JSON(200, gin.H{"executed": r.Confirm, "statements": statements})
+}
+
+// restoreCommand dbmonitor restore -history data.db -table public.notes -id 14 [-diff 1,2] [-version 1 [-execute -dsn ...]]
+func restoreCommand(args []string) int {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	var (
+		history = fs.String("history", "data.db", "sqlite历史记录文件")
+		dsn     = fs.String("dsn", "", "执行恢复时需要连接的postgres dsn")
+		table   = fs.String("table", "", "数据表，schema.table")
+		id      = fs.String("id", "", "记录主键")
+		pk      = fs.String("pk", restore.DefaultPrimaryKey, "主键列名")
+		diff    = fs.String("diff", "", "比较两个版本，例如1,2")
+		version = fs.Int("version", 0, "恢复到的版本")
+		execute = fs.Bool("execute", false, "执行恢复的sql，执行前需要确认")
+		yes     = fs.Bool("yes", false, "执行前不需要确认")
+	)
+	fs.Parse(args)
+	if *table == "" || *id == "" {
+		fs.Usage()
+		return 2
+	}
+
+	source, err := sqlite.NewSqliteTransport(*history)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer source.Close()
+
+	var db *sql.DB
+	if *execute {
+		if *dsn == "" {
+			fmt.Fprintln(os.Stderr, "-execute需要-dsn")
+			return 2
+		}
+		if db, err = sql.Open("postgres", *dsn); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer db.Close()
+	}
+
+	r := restore.NewRestorer(source, db).SetPrimaryKey(*pk)
+	schema, name := splitTable(*table)
+
+	var output interface{}
+	switch {
+	case *diff != "":
+		from, to, err := parseVersionPair(*diff)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		output, err = r.Diff(schema, name, *id, from, to)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	case *version > 0:
+		statements, err := r.Plan(schema, name, *id, *version)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		for _, stmt := range statements {
+			fmt.Println(stmt)
+		}
+		if len(statements) == 0 {
+			fmt.Fprintln(os.Stderr, "记录已经是该版本的状态")
+			return 0
+		}
+		if !*execute {
+			return 0
+		}
+		if !*yes && !confirm() {
+			fmt.Fprintln(os.Stderr, "已取消")
+			return 1
+		}
+		if _, err := r.Restore(context.Background(), schema, name, *id, *version, true); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Fprintln(os.Stderr, "恢复成功")
+		return 0
+	default:
+		output, err = r.Versions(schema, name, *id)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	data, _ := json.MarshalIndent(output, "", "  ")
+	fmt.Println(string(data))
+	return 0
+}
+
+func parseVersionPair(s string) (int, int, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid diff: %s", s)
+	}
+	from, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid diff: %s", s)
+	}
+	to, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid diff: %s", s)
+	}
+	return from, to, nil
+}
+
+func confirm() bool {
+	fmt.Fprint(os.Stderr, "确认执行以上sql? 输入yes继续: ")
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimSpace(line) == "yes"
+}
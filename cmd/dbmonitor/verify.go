@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wwqdrh/datamanager/transport/sqlite"
+)
+
+// 校验历史记录的哈希链以及检查点
+func Verify(ctx *gin.Context) {
+	if sqlite3transport == nil {
+		ctx.String(500, "未初始化完成，稍后重试")
+		return
+	}
+
+	if res, err := sqlite3transport.Verify(); err != nil {
+		ctx.String(500, err.Error())
+	} else {
+		ctx.JSON(200, res)
+	}
+}
+
+// verifyCommand dbmonitor verify -history data.db [-key-file audit.key] [-every 1000] [-checkpoint]
+func verifyCommand(args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	var (
+		history    = fs.String("history", "data.db", "sqlite历史记录文件")
+		keyFile    = fs.String("key-file", "", "检查点的签名密钥文件，不指定时不校验签名")
+		every      = fs.Int("every", sqlite.DefaultCheckpointEvery, "写入时的检查点间隔，最后一个检查点之后的记录超过间隔时校验失败")
+		checkpoint = fs.Bool("checkpoint", false, "校验通过后为最后一条记录生成检查点，需要-key-file")
+	)
+	fs.Parse(args)
+
+	source, err := sqlite.NewSqliteTransport(*history)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer source.Close()
+
+	if *keyFile != "" {
+		key, err := sqlite.LoadKey(*keyFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		source.SetSigningKey(key, *every)
+	}
+
+	res, err := source.Verify()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	data, _ := json.MarshalIndent(res, "", "  ")
+	fmt.Println(string(data))
+	if !res.Ok {
+		return 1
+	}
+
+	if *checkpoint {
+		c, err := source.Checkpoint()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if c != nil {
+			fmt.Fprintf(os.Stderr, "已生成检查点%d，记录%d\n", c.Id, c.HistoryId)
+		}
+	}
+	return 0
+}
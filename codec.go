@@ -0,0 +1,138 @@
+package datamanager
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+// Codec 缓存的值在redis中的编码，Unmarshal的v为指针
+type Codec interface {
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	// JSONCodec 默认的编码，没有指定类型时数字解析为float64
+	JSONCodec Codec = jsonCodec{}
+	// GobCodec 需要知道值的类型才能解码，使用GetAs或者在本地加载过该key
+	GobCodec Codec = gobCodec{}
+	// MsgpCodec 结构体需要使用msgp生成MarshalMsg以及UnmarshalMsg
+	MsgpCodec Codec = msgpCodec{}
+)
+
+var ErrInvalidDest = errors.New("dest must be a non-nil pointer")
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "json" }
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string { return "gob" }
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type msgpCodec struct{}
+
+func (msgpCodec) Name() string { return "msgp" }
+
+func (msgpCodec) Marshal(v interface{}) ([]byte, error) {
+	if m, ok := v.(msgp.Marshaler); ok {
+		return m.MarshalMsg(nil)
+	}
+	return msgp.AppendIntf(nil, v)
+}
+
+func (msgpCodec) Unmarshal(data []byte, v interface{}) error {
+	if u, ok := v.(msgp.Unmarshaler); ok {
+		_, err := u.UnmarshalMsg(data)
+		return err
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return ErrInvalidDest
+	}
+	res, _, err := msgp.ReadIntfBytes(data)
+	if err != nil {
+		return err
+	}
+	return assignValue(rv.Elem(), res)
+}
+
+// decodeValue typ为空时解码为interface{}
+func decodeValue(codec Codec, data []byte, typ reflect.Type) (interface{}, error) {
+	if typ == nil {
+		var v interface{}
+		err := codec.Unmarshal(data, &v)
+		return v, err
+	}
+
+	p := reflect.New(typ)
+	if err := codec.Unmarshal(data, p.Interface()); err != nil {
+		return nil, err
+	}
+	return p.Elem().Interface(), nil
+}
+
+// assignValue 将v写入dst，类型不一致时数字之间直接转换，其他类型经过json转换
+func assignValue(dst reflect.Value, v interface{}) error {
+	if v == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+
+	rv := reflect.ValueOf(v)
+	switch {
+	case rv.Type().AssignableTo(dst.Type()):
+		dst.Set(rv)
+		return nil
+	case isNumber(rv.Kind()) && isNumber(dst.Kind()):
+		dst.Set(rv.Convert(dst.Type()))
+		return nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("can't assign %T to %s: %w", v, dst.Type(), err)
+	}
+	if err := json.Unmarshal(data, dst.Addr().Interface()); err != nil {
+		return fmt.Errorf("can't assign %T to %s: %w", v, dst.Type(), err)
+	}
+	return nil
+}
+
+func isNumber(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package datamanager
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/wwqdrh/datamanager/dialet"
+)
+
+type testUser struct {
+	Id   int
+	Name string
+	Tags []string
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	user := testUser{Id: 1, Name: "tom", Tags: []string{"a"}}
+	for _, codec := range []Codec{JSONCodec, GobCodec} {
+		data, err := codec.Marshal(user)
+		if err != nil {
+			t.Fatal(codec.Name(), err)
+		}
+		v, err := decodeValue(codec, data, reflect.TypeOf(user))
+		if err != nil || !reflect.DeepEqual(v, user) {
+			t.Error(codec.Name(), v, err)
+		}
+	}
+
+	// msgp没有生成代码时支持基本类型以及map、slice
+	for _, value := range []interface{}{int64(42), "value", map[string]interface{}{"id": int64(1)}} {
+		data, err := MsgpCodec.Marshal(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := decodeValue(MsgpCodec, data, reflect.TypeOf(value))
+		if err != nil || !reflect.DeepEqual(v, value) {
+			t.Error(v, err)
+		}
+	}
+
+	// 没有类型时json解析为通用的类型
+	data, _ := JSONCodec.Marshal(42)
+	if v, err := decodeValue(JSONCodec, data, nil); err != nil || v != float64(42) {
+		t.Error(v, err)
+	}
+}
+
+func TestGetAs(t *testing.T) {
+	r := NewRepo(make(chan dialet.ILogData))
+	r.Register(&Policy{
+		Key:   "user:{id}",
+		Table: "users",
+		Field: "*",
+		Load: func(ctx context.Context, params map[string]string) (interface{}, error) {
+			return map[string]interface{}{"Id": 1, "Name": "tom"}, nil
+		},
+	})
+	r.Register(&Policy{
+		Key:   "count",
+		Table: "users",
+		Field: "*",
+		Call: func(ctx context.Context) (interface{}, error) {
+			return 3.0, nil
+		},
+	})
+
+	var user testUser
+	if err := r.GetAs(context.Background(), "user:1", &user); err != nil || user.Name != "tom" || user.Id != 1 {
+		t.Error(user, err)
+	}
+	var count int
+	if err := r.GetAs(context.Background(), "count", &count); err != nil || count != 3 {
+		t.Error(count, err)
+	}
+	if err := r.GetAs(context.Background(), "count", count); err != ErrInvalidDest {
+		t.Error(err)
+	}
+}
+
+func TestRedisCodec(t *testing.T) {
+	if os.Getenv("mode") != "local" {
+		t.Skip("no local env")
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:6379"})
+	defer client.Close()
+
+	r := NewRepo(make(chan dialet.ILogData))
+	r.InitRedisCache(client)
+	r.Register(&Policy{
+		Key:   "codec:user",
+		Table: "users",
+		Field: "*",
+		TTL:   time.Minute,
+		Codec: GobCodec,
+		Call: func(ctx context.Context) (interface{}, error) {
+			return testUser{Id: 1, Name: "tom"}, nil
+		},
+	})
+	defer client.Del(context.Background(), "codec:user")
+
+	if v, ok := r.GetValueV2("codec:user").(testUser); !ok || v.Name != "tom" {
+		t.Error(v)
+	}
+	if ttl := client.TTL(context.Background(), "codec:user").Val(); ttl <= 5*time.Second {
+		t.Error(ttl)
+	}
+
+	// 新的实例从redis中解码为相同的类型
+	other := NewRepo(make(chan dialet.ILogData))
+	other.InitRedisCache(client)
+	other.Register(&Policy{Key: "codec:user", Table: "users", Field: "*", Codec: GobCodec})
+	var user testUser
+	if err := other.GetAs(context.Background(), "codec:user", &user); err != nil || user.Name != "tom" {
+		t.Error(user, err)
+	}
+}
@@ -0,0 +1,276 @@
+package datamanager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/wwqdrh/logger"
+)
+
+// 多个节点之间的缓存一致性
+// 处理变更事件的节点刷新或者删除key之后在redis频道中发布消息，其他节点删除本地的缓存或者从redis中读取新的值
+// 加载key时使用redis锁，同一时间只有一个节点执行加载函数，其他节点等待写入redis的结果
+
+var ErrNoRedis = errors.New("redis cache not initialized")
+
+var (
+	DefaultCoherenceChannel = "datamanager:cache:coherence"
+	DefaultLockTTL          = 10 * time.Second
+
+	// 等待其他节点加载时读取redis的间隔
+	lockPollInterval = 50 * time.Millisecond
+
+	// 只删除自己持有的锁
+	unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+)
+
+// 消息的类型
+const (
+	opRefresh       = "refresh"        // 值已经写入redis
+	opInvalidate    = "invalidate"     // 删除本地缓存
+	opInvalidateAll = "invalidate_all" // keys为key模板，删除匹配的全部本地缓存
+)
+
+type CoherenceOptions struct {
+	Channel string        // 默认DefaultCoherenceChannel
+	NodeId  string        // 默认hostname-pid-时间
+	LockTTL time.Duration // 加载的锁的过期时间，默认DefaultLockTTL
+}
+
+type coherence struct {
+	channel string
+	node    string
+	lockTTL time.Duration
+}
+
+type coherenceMessage struct {
+	Node    string   `json:"node"`
+	Op      string   `json:"op"`
+	Keys    []string `json:"keys"`
+	Version uint64   `json:"version,omitempty"` // 处理变更的节点中事件的序号
+}
+
+// EnableCoherence 订阅一致性频道，需要先调用InitRedisCache，订阅成功之后返回
+// ctx结束时取消订阅
+func (r *Repo) EnableCoherence(ctx context.Context, options CoherenceOptions) error {
+	if r.client == nil {
+		return ErrNoRedis
+	}
+	if options.Channel == "" {
+		options.Channel = DefaultCoherenceChannel
+	}
+	if options.NodeId == "" {
+		hostname, _ := os.Hostname()
+		options.NodeId = fmt.Sprintf("%s-%d-%d", hostname, os.Getpid(), time.Now().UnixNano())
+	}
+	if options.LockTTL <= 0 {
+		options.LockTTL = DefaultLockTTL
+	}
+
+	pubsub := r.client.Subscribe(ctx, options.Channel)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return err
+	}
+
+	r.lock.Lock()
+	r.coherence = &coherence{channel: options.Channel, node: options.NodeId, lockTTL: options.LockTTL}
+	r.lock.Unlock()
+
+	go func() {
+		defer pubsub.Close()
+		ch := pubsub.Channel()
+		// 副本通常不运行Notify，在这里清理过期的本地缓存
+		sweep := time.NewTicker(LocalSweepInterval)
+		defer sweep.Stop()
+		for {
+			select {
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				var m coherenceMessage
+				if err := json.Unmarshal([]byte(msg.Payload), &m); err != nil {
+					logger.DefaultLogger.Error(fmt.Sprintf("coherence message: %s", err.Error()))
+					continue
+				}
+				r.applyRemote(m)
+			case <-sweep.C:
+				r.sweep()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (r *Repo) coherenceOf() *coherence {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.coherence
+}
+
+// notifyPeers 通知其他节点，version为事件的序号
+func (r *Repo) notifyPeers(op string, version uint64, keys ...string) {
+	c := r.coherenceOf()
+	if c == nil || len(keys) == 0 {
+		return
+	}
+
+	data, _ := json.Marshal(coherenceMessage{Node: c.node, Op: op, Keys: keys, Version: version})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := r.client.Publish(ctx, c.channel, data).Err(); err != nil {
+		logger.DefaultLogger.Error(err.Error())
+	}
+}
+
+// applyRemote 处理其他节点的消息，只修改本地缓存，不会再次发布
+func (r *Repo) applyRemote(m coherenceMessage) {
+	if c := r.coherenceOf(); c != nil && m.Node == c.node {
+		return
+	}
+
+	seq := r.nextSeq(m.Version)
+	if m.Op == opInvalidateAll {
+		for _, raw := range m.Keys {
+			tmpl := parseKeyTemplate(raw)
+			r.ValueMap.Range(func(k, _ interface{}) bool {
+				if _, ok := tmpl.match(k.(string)); ok {
+					r.dropLocal(k.(string), seq)
+				}
+				return true
+			})
+		}
+		return
+	}
+
+	for _, key := range m.Keys {
+		if _, ok := r.ValueMap.Load(key); !ok {
+			// 本地没有缓存，只更新版本
+			r.advance(key, seq)
+			continue
+		}
+
+		if m.Op == opRefresh && r.client != nil {
+			policy, _, _ := r.lookup(key)
+			if v, ok := r.readRemote(context.Background(), key, policy, r.typeOf(policy)); ok {
+				r.store(key, policy, r.bump(key), v)
+				r.advance(key, seq)
+				continue
+			}
+		}
+		r.dropLocal(key, seq)
+	}
+}
+
+// dropLocal 删除本地缓存
+func (r *Repo) dropLocal(key string, seq uint64) {
+	r.bump(key)
+	r.deleteLocal(key)
+	r.advance(key, seq)
+}
+
+// nextSeq 其他节点消息的序号，不小于消息中的版本
+func (r *Repo) nextSeq(version uint64) uint64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.observe(version)
+}
+
+// readRemote 从redis中读取并使用策略的编码解码，typ为空时解码为interface{}
+func (r *Repo) readRemote(ctx context.Context, key string, policy *Policy, typ reflect.Type) (interface{}, bool) {
+	data, err := r.client.Get(ctx, key).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			logger.DefaultLogger.Error(err.Error())
+		}
+		return nil, false
+	}
+
+	v, err := decodeValue(r.codecOf(policy), data, typ)
+	if err != nil {
+		logger.DefaultLogger.Error(fmt.Sprintf("decode %s: %s", key, err.Error()))
+		return nil, false
+	}
+	return v, true
+}
+
+// tryLock 获取加载key的锁，返回释放锁的函数
+func (r *Repo) tryLock(ctx context.Context, c *coherence, key string) (func(), bool, error) {
+	lockKey := "lock:" + key
+	token := fmt.Sprintf("%s-%d", c.node, time.Now().UnixNano())
+	ok, err := r.client.SetNX(ctx, lockKey, token, c.lockTTL).Result()
+	if err != nil || !ok {
+		return nil, false, err
+	}
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := unlockScript.Run(ctx, r.client, []string{lockKey}, token).Err(); err != nil {
+			logger.DefaultLogger.Error(err.Error())
+		}
+	}, true, nil
+}
+
+// loadShared 开启一致性时先读取redis，没有时获取锁之后加载并写入redis，
+// 其他节点正在加载时等待结果，锁释放或者超时后自己加载
+func (r *Repo) loadShared(ctx context.Context, key string, policy *Policy, params map[string]string) (interface{}, error) {
+	c := r.coherenceOf()
+	if c == nil {
+		return policy.load(ctx, params)
+	}
+	if v, ok := r.readRemote(ctx, key, policy, r.typeOf(policy)); ok {
+		return v, nil
+	}
+
+	unlock, ok, err := r.tryLock(ctx, c, key)
+	if err != nil {
+		logger.DefaultLogger.Error(err.Error())
+		return policy.load(ctx, params)
+	}
+	if ok {
+		defer unlock()
+		v, err := policy.load(ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		if err := r.SetValueV2(key, v); err != nil {
+			logger.DefaultLogger.Error(err.Error())
+		}
+		return v, nil
+	}
+
+	deadline := time.NewTimer(c.lockTTL)
+	defer deadline.Stop()
+	ticker := time.NewTicker(lockPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if v, ok := r.readRemote(ctx, key, policy, r.typeOf(policy)); ok {
+				return v, nil
+			}
+			if n, err := r.client.Exists(ctx, "lock:"+key).Result(); err == nil && n == 0 {
+				return policy.load(ctx, params)
+			}
+		case <-deadline.C:
+			return policy.load(ctx, params)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package datamanager
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/wwqdrh/datamanager/dialet"
+)
+
+func TestCoherenceApplyRemote(t *testing.T) {
+	r := NewRepo(make(chan dialet.ILogData))
+	r.coherence = &coherence{node: "a"}
+	r.Register(&Policy{Key: "count", Table: "users", Field: "*"})
+	r.Register(&Policy{Key: "user:{id}", Table: "users", Field: "*"})
+	for _, key := range []string{"count", "user:1", "user:2"} {
+		r.ValueMap.Store(key, 1)
+	}
+
+	// 忽略自己发布的消息
+	r.applyRemote(coherenceMessage{Node: "a", Op: opInvalidate, Keys: []string{"count"}})
+	if _, ok := r.ValueMap.Load("count"); !ok {
+		t.Error("own message should be ignored")
+	}
+
+	// 其他节点失效之后删除本地缓存并更新版本
+	r.applyRemote(coherenceMessage{Node: "b", Op: opInvalidate, Keys: []string{"count"}})
+	if _, ok := r.ValueMap.Load("count"); ok || r.Version("count") == 0 {
+		t.Error("count should be invalidated", r.Version("count"))
+	}
+
+	// 没有redis时无法读取新值，删除本地缓存
+	r.applyRemote(coherenceMessage{Node: "b", Op: opRefresh, Keys: []string{"user:1"}})
+	if _, ok := r.ValueMap.Load("user:1"); ok {
+		t.Error("user:1 should be dropped")
+	}
+
+	r.applyRemote(coherenceMessage{Node: "b", Op: opInvalidateAll, Keys: []string{"user:{id}"}})
+	if _, ok := r.ValueMap.Load("user:2"); ok {
+		t.Error("user:2 should be invalidated")
+	}
+}
+
+func TestCoherenceRedis(t *testing.T) {
+	if os.Getenv("mode") != "local" {
+		t.Skip("no local env")
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:6379"})
+	defer client.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer client.Del(context.Background(), "coherence:count")
+
+	var loads int32
+	nodes := make([]*Repo, 2)
+	for i := range nodes {
+		r := NewRepo(make(chan dialet.ILogData))
+		r.InitRedisCache(client)
+		r.Register(&Policy{
+			Key:   "coherence:count",
+			Table: "users",
+			Field: "*",
+			TTL:   time.Minute,
+			Call: func(ctx context.Context) (interface{}, error) {
+				time.Sleep(100 * time.Millisecond)
+				return float64(atomic.AddInt32(&loads, 1)), nil
+			},
+		})
+		if err := r.EnableCoherence(ctx, CoherenceOptions{NodeId: fmt.Sprint(i)}); err != nil {
+			t.Fatal(err)
+		}
+		nodes[i] = r
+	}
+
+	// 多个节点同时加载时只有一个节点执行加载函数
+	var wg sync.WaitGroup
+	for _, r := range nodes {
+		wg.Add(1)
+		go func(r *Repo) {
+			defer wg.Done()
+			if v, err := r.Get(ctx, "coherence:count"); err != nil || v != float64(1) {
+				t.Error(v, err)
+			}
+		}(r)
+	}
+	wg.Wait()
+	if loads != 1 {
+		t.Error("loads", loads)
+	}
+
+	// 一个节点处理变更，其他节点从redis中读取新值
+	version := nodes[1].Version("coherence:count")
+	nodes[0].Trigger(&testLog{table: "users"})
+	waitCtx, waitCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer waitCancel()
+	if _, err := nodes[1].WaitFor(waitCtx, "coherence:count", version+1); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := nodes[1].ValueMap.Load("coherence:count"); v != float64(2) {
+		t.Error(v)
+	}
+}
+
+func TestCoherenceRedisRows(t *testing.T) {
+	if os.Getenv("mode") != "local" {
+		t.Skip("no local env")
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:6379"})
+	defer client.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer client.Del(context.Background(), "coherence:row:5", "coherence:row:7", "coherence:row:8", "coherence:other")
+
+	var value int32 = 1
+	nodes := make([]*Repo, 2)
+	for i := range nodes {
+		r := NewRepo(make(chan dialet.ILogData))
+		r.InitRedisCache(client)
+		r.Register(&Policy{
+			Key:   "coherence:row:{id}",
+			Table: "users",
+			Field: "*",
+			TTL:   time.Minute,
+			Load: func(ctx context.Context, params map[string]string) (interface{}, error) {
+				return fmt.Sprintf("%s-%d", params["id"], atomic.LoadInt32(&value)), nil
+			},
+		})
+		if err := r.EnableCoherence(ctx, CoherenceOptions{NodeId: fmt.Sprint(i), LockTTL: 2 * time.Second}); err != nil {
+			t.Fatal(err)
+		}
+		nodes[i] = r
+	}
+	waitCtx, waitCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer waitCancel()
+
+	// 处理变更的节点没有缓存时也通知其他节点
+	nodes[1].GetValue("coherence:row:5")
+	version := nodes[1].Version("coherence:row:5")
+	nodes[0].Trigger(&testLog{table: "users", label: "update", payload: map[string]interface{}{"id": 5}})
+	if _, err := nodes[1].WaitFor(waitCtx, "coherence:row:5", version+1); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := nodes[1].ValueMap.Load("coherence:row:5"); ok {
+		t.Error("coherence:row:5 should be dropped")
+	}
+
+	// 其他节点持有锁时保留旧值，锁释放之后重新加载
+	nodes[0].GetValue("coherence:row:7")
+	client.Set(ctx, "lock:coherence:row:7", "other", time.Minute)
+	atomic.StoreInt32(&value, 2)
+	version = nodes[0].Version("coherence:row:7")
+	nodes[0].Trigger(&testLog{table: "users", label: "update", payload: map[string]interface{}{"id": 7}})
+	time.Sleep(200 * time.Millisecond)
+	if v, _ := nodes[0].ValueMap.Load("coherence:row:7"); v != "7-1" || nodes[0].Version("coherence:row:7") != version {
+		t.Error(v, nodes[0].Version("coherence:row:7"))
+	}
+	client.Del(ctx, "lock:coherence:row:7")
+	if _, err := nodes[0].WaitFor(waitCtx, "coherence:row:7", version+1); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := nodes[0].ValueMap.Load("coherence:row:7"); v != "7-2" {
+		t.Error(v)
+	}
+	if data, _ := client.Get(ctx, "coherence:row:7").Result(); data != `"7-2"` {
+		t.Error(data)
+	}
+
+	// 全部失效时删除redis中匹配的key
+	client.Set(ctx, "coherence:row:8", `"8-1"`, time.Minute)
+	client.Set(ctx, "coherence:other", `"other"`, time.Minute)
+	nodes[0].Trigger(&testLog{table: "users", label: "truncate"})
+	if n, _ := client.Exists(ctx, "coherence:row:7", "coherence:row:8").Result(); n != 0 {
+		t.Error("row keys should be deleted", n)
+	}
+	if n, _ := client.Exists(ctx, "coherence:other").Result(); n != 1 {
+		t.Error("other key should be kept")
+	}
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/viper"
+	"github.com/wwqdrh/datamanager/transport"
 )
 
 var Conf *appConfig
@@ -29,6 +30,7 @@ type appConfig struct {
 		Host     string `mapstructure:"host" yaml:"host"`
 		DB       string `mapstructure:"db" yaml:"db"`
 	} `mapstructure:"mongo" yaml:"mongo"`
+	Transports []transport.Config `mapstructure:"transports" yaml:"transports"`
 }
 
 // conf: yaml
@@ -13,6 +13,7 @@ var (
 	_ IDialet = &redis.RedisDialet{}
 
 	_ ILogData = &postgres.PostgresLog{}
+	_ ILogData = &redis.RedisLog{}
 )
 
 type IDialet interface {
@@ -1,17 +1,18 @@
 package mysql
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/go-mysql-org/go-mysql/replication"
-	"github.com/wwqdrh/logger"
 )
 
 type RowsEventData struct {
 	BinlogEventHeader replication.EventHeader
 	BinlogEvent       replication.RowsEvent
 	TableMetadata     TableMetadata
+	RawData           []byte // 原始事件，转换失败时写入死信
 }
 
 func NewRowsEventData(binlogEventHeader replication.EventHeader, binlogEvent replication.RowsEvent, tableMetadata TableMetadata) RowsEventData {
@@ -39,58 +40,101 @@ func ConvertQueryEventToMessage(binlogEventHeader replication.EventHeader, binlo
 	return Message(message)
 }
 
-func ConvertRowsEventsToMessages(xId uint64, rowsEventsData []RowsEventData) []Message {
+// ConversionError 行事件无法转换为消息
+type ConversionError struct {
+	Schema    string
+	Table     string
+	EventType replication.EventType
+	Position  uint32 // 事件的起始位置，与DeadLetter相同
+	Reason    string
+}
+
+func newConversionError(binlogEventHeader replication.EventHeader, tableMetadata TableMetadata, reason string) ConversionError {
+	return ConversionError{
+		Schema:    tableMetadata.Schema,
+		Table:     tableMetadata.Table,
+		EventType: binlogEventHeader.EventType,
+		Position:  eventStartPosition(&binlogEventHeader),
+		Reason:    reason,
+	}
+}
+
+func (e *ConversionError) Error() string {
+	return fmt.Sprintf("Conversion error %s for %s.%s at position %d: %s", e.EventType, e.Schema, e.Table, e.Position, e.Reason)
+}
+
+func ConvertRowsEventsToMessages(xId uint64, rowsEventsData []RowsEventData) ([]Message, error) {
 	var ret []Message
 
 	for _, d := range rowsEventsData {
-		rowData := mapRowDataDataToColumnNames(d.BinlogEvent.Rows, d.TableMetadata.Fields)
-
-		header := NewMessageHeader(
-			d.TableMetadata.Schema,
-			d.TableMetadata.Table,
-			time.Unix(int64(d.BinlogEventHeader.Timestamp), 0),
-			d.BinlogEventHeader.LogPos,
-			xId,
-		)
-
-		switch d.BinlogEventHeader.EventType {
-		case replication.WRITE_ROWS_EVENTv1,
-			replication.WRITE_ROWS_EVENTv2:
-			for _, message := range createInsertMessagesFromRowData(header, rowData) {
-				ret = append(ret, Message(message))
-			}
+		messages, err := ConvertRowsEventToMessages(xId, d)
 
-			break
+		if err != nil {
+			return nil, err
+		}
 
-		case replication.UPDATE_ROWS_EVENTv1,
-			replication.UPDATE_ROWS_EVENTv2:
-			for _, message := range createUpdateMessagesFromRowData(header, rowData) {
-				ret = append(ret, Message(message))
-			}
+		ret = append(ret, messages...)
+	}
 
-			break
+	return ret, nil
+}
 
-		case replication.DELETE_ROWS_EVENTv1,
-			replication.DELETE_ROWS_EVENTv2:
-			for _, message := range createDeleteMessagesFromRowData(header, rowData) {
-				ret = append(ret, Message(message))
-			}
+func ConvertRowsEventToMessages(xId uint64, d RowsEventData) ([]Message, error) {
+	var ret []Message
 
-			break
+	rowData, err := mapRowDataDataToColumnNames(d.BinlogEvent.Rows, d.TableMetadata.Fields, d.TableMetadata.Types)
 
-		default:
-			logger.DefaultLogger.Error(fmt.Sprintf("Can't convert unknown event %s", d.BinlogEventHeader.EventType))
+	if err != nil {
+		c := newConversionError(d.BinlogEventHeader, d.TableMetadata, err.Error())
+		return nil, &c
+	}
 
-			break
+	header := NewMessageHeader(
+		d.TableMetadata.Schema,
+		d.TableMetadata.Table,
+		time.Unix(int64(d.BinlogEventHeader.Timestamp), 0),
+		d.BinlogEventHeader.LogPos,
+		xId,
+	)
+	header.PrimaryKey = d.TableMetadata.PrimaryKey
+
+	switch d.BinlogEventHeader.EventType {
+	case replication.WRITE_ROWS_EVENTv1,
+		replication.WRITE_ROWS_EVENTv2:
+		for _, message := range createInsertMessagesFromRowData(header, rowData) {
+			ret = append(ret, Message(message))
+		}
+
+	case replication.UPDATE_ROWS_EVENTv1,
+		replication.UPDATE_ROWS_EVENTv2:
+		messages, err := createUpdateMessagesFromRowData(header, rowData)
+
+		if err != nil {
+			c := newConversionError(d.BinlogEventHeader, d.TableMetadata, err.Error())
+			return nil, &c
+		}
+
+		for _, message := range messages {
+			ret = append(ret, Message(message))
+		}
+
+	case replication.DELETE_ROWS_EVENTv1,
+		replication.DELETE_ROWS_EVENTv2:
+		for _, message := range createDeleteMessagesFromRowData(header, rowData) {
+			ret = append(ret, Message(message))
 		}
+
+	default:
+		c := newConversionError(d.BinlogEventHeader, d.TableMetadata, fmt.Sprintf("Can't convert unknown event %s", d.BinlogEventHeader.EventType))
+		return nil, &c
 	}
 
-	return ret
+	return ret, nil
 }
 
-func createUpdateMessagesFromRowData(header MessageHeader, rowData []MessageRowData) []UpdateMessage {
+func createUpdateMessagesFromRowData(header MessageHeader, rowData []MessageRowData) ([]UpdateMessage, error) {
 	if len(rowData)%2 != 0 {
-		panic("update rows should be old/new pairs") // should never happen as per mysql format
+		return nil, errors.New("update rows should be old/new pairs") // should never happen as per mysql format
 	}
 
 	var ret []UpdateMessage
@@ -104,7 +148,7 @@ func createUpdateMessagesFromRowData(header MessageHeader, rowData []MessageRowD
 		}
 	}
 
-	return ret
+	return ret, nil
 }
 
 func createInsertMessagesFromRowData(header MessageHeader, rowData []MessageRowData) []InsertMessage {
@@ -127,7 +171,7 @@ func createDeleteMessagesFromRowData(header MessageHeader, rowData []MessageRowD
 	return ret
 }
 
-func mapRowDataDataToColumnNames(rows [][]interface{}, columnNames map[int]string) []MessageRowData {
+func mapRowDataDataToColumnNames(rows [][]interface{}, columnNames map[int]string, columnTypes map[int]ColumnType) ([]MessageRowData, error) {
 	var mappedRows []MessageRowData
 
 	for _, row := range rows {
@@ -145,11 +189,15 @@ func mapRowDataDataToColumnNames(rows [][]interface{}, columnNames map[int]strin
 
 				if !exists {
 					// This should actually never happen
-					// Fail hard before doing anything weird
-					panic(fmt.Sprintf("No mismatch between row and column names array detected, but column %s not found", columnName))
+					// Fail before doing anything weird
+					return nil, fmt.Errorf("No mismatch between row and column names array detected, but column %d not found", columnIndex)
 				}
 
-				data[columnName] = columnValue
+				if columnType, ok := columnTypes[columnIndex]; ok {
+					data[columnName] = decodeColumnValue(columnValue, columnType)
+				} else {
+					data[columnName] = columnValue
+				}
 			}
 		}
 
@@ -160,7 +208,7 @@ func mapRowDataDataToColumnNames(rows [][]interface{}, columnNames map[int]strin
 		}
 	}
 
-	return mappedRows
+	return mappedRows, nil
 }
 
 func detectMismatch(row []interface{}, columnNames map[int]string) (bool, string) {
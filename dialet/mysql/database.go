@@ -52,14 +52,22 @@ func GetDatabaseInstance(connectionString string) (*sql.DB, error) {
 }
 
 type TableMetadata struct {
-	Schema string
-	Table  string
-	Fields map[int]string
+	Schema     string
+	Table      string
+	Fields     map[int]string
+	Types      map[int]ColumnType
+	PrimaryKey []string
+}
+
+type tableColumns struct {
+	fields     map[int]string
+	types      map[int]ColumnType
+	primaryKey []string
 }
 
 type TableMap struct {
 	tableMetadataMap map[uint64]TableMetadata
-	fieldsCache      map[string]map[int]string
+	fieldsCache      map[string]tableColumns
 	db               *sql.DB
 }
 
@@ -67,18 +75,18 @@ func NewTableMap(db *sql.DB) TableMap {
 	return TableMap{
 		db:               db,
 		tableMetadataMap: make(map[uint64]TableMetadata),
-		fieldsCache:      make(map[string]map[int]string),
+		fieldsCache:      make(map[string]tableColumns),
 	}
 }
 
 func (m *TableMap) Add(id uint64, schema, table string) error {
-	fields, err := m.getFields(schema, table)
+	columns, err := m.getFields(schema, table)
 
 	if err != nil {
 		return err
 	}
 
-	m.tableMetadataMap[id] = TableMetadata{schema, table, fields}
+	m.tableMetadataMap[id] = TableMetadata{schema, table, columns.fields, columns.types, columns.primaryKey}
 
 	return nil
 }
@@ -88,52 +96,62 @@ func (m *TableMap) LookupTableMetadata(id uint64) (TableMetadata, bool) {
 	return val, ok
 }
 
-func (m *TableMap) getFields(schema, table string) (map[int]string, error) {
+func (m *TableMap) getFields(schema, table string) (tableColumns, error) {
 	cacheKey := fmt.Sprintf("%s_%s", schema, table)
 
 	if cachedFields, ok := m.fieldsCache[cacheKey]; ok {
 		return cachedFields, nil
 	}
 
-	fields, err := getFieldsFromDb(m.db, schema, table)
-	m.fieldsCache[cacheKey] = fields
+	if m.db == nil {
+		q := newQueryError(fmt.Errorf("no schema information for table %s.%s", schema, table))
+		return tableColumns{}, &q
+	}
+
+	columns, err := getFieldsFromDb(m.db, schema, table)
 
 	if err != nil {
-		return nil, err
+		return tableColumns{}, err
 	}
 
-	return fields, nil
+	m.fieldsCache[cacheKey] = columns
+
+	return columns, nil
 }
 
-func getFieldsFromDb(db *sql.DB, schema string, table string) (map[int]string, error) {
+func getFieldsFromDb(db *sql.DB, schema string, table string) (tableColumns, error) {
 	rows, err := db.Query(
-		"SELECT COLUMN_NAME FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ? ORDER BY ORDINAL_POSITION",
+		"SELECT COLUMN_NAME, COLUMN_KEY, DATA_TYPE, COLUMN_TYPE FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ? ORDER BY ORDINAL_POSITION",
 		schema,
 		table,
 	)
 
 	if err != nil {
 		q := newQueryError(err)
-		return nil, &q
+		return tableColumns{}, &q
 	}
 
 	defer rows.Close()
 
-	fields := make(map[int]string)
+	columns := tableColumns{fields: make(map[int]string), types: make(map[int]ColumnType)}
 	i := 0
 
-	var columnName string
+	var columnName, columnKey, dataType, columnType string
 	for rows.Next() {
-		err := rows.Scan(&columnName)
+		err := rows.Scan(&columnName, &columnKey, &dataType, &columnType)
 
 		if err != nil {
 			q := newQueryError(err)
-			return nil, &q
+			return tableColumns{}, &q
 		}
 
-		fields[i] = columnName
+		columns.fields[i] = columnName
+		columns.types[i] = NewColumnType(dataType, columnType)
+		if columnKey == "PRI" {
+			columns.primaryKey = append(columns.primaryKey, columnName)
+		}
 		i++
 	}
 
-	return fields, nil
+	return columns, nil
 }
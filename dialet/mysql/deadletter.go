@@ -0,0 +1,60 @@
+package mysql
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+// DeadLetter 无法转换的事件，保留原始数据便于排查以及重放
+type DeadLetter struct {
+	File      string
+	Position  uint32 // 事件的起始位置
+	EventType string
+	Time      string
+	Reason    string
+	RawData   []byte // json序列化为base64
+}
+
+func NewDeadLetter(file string, header replication.EventHeader, rawData []byte, reason error) DeadLetter {
+	return DeadLetter{
+		File:      file,
+		Position:  eventStartPosition(&header),
+		EventType: header.EventType.String(),
+		Time:      time.Unix(int64(header.Timestamp), 0).UTC().Format(time.RFC3339),
+		Reason:    reason.Error(),
+		RawData:   rawData,
+	}
+}
+
+type DeadLetterSink interface {
+	Write(DeadLetter) error
+}
+
+// JsonDeadLetterSink 每个死信写为一行json
+type JsonDeadLetterSink struct {
+	mu     sync.Mutex
+	stream io.Writer
+}
+
+func NewJsonDeadLetterSink(stream io.Writer) *JsonDeadLetterSink {
+	return &JsonDeadLetterSink{stream: stream}
+}
+
+func (s *JsonDeadLetterSink) Write(letter DeadLetter) error {
+	data, err := json.Marshal(letter)
+
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.stream.Write(append(data, '\n'))
+
+	return err
+}
@@ -0,0 +1,126 @@
+package mysql
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+func newTestTableMap() TableMap {
+	tableMap := NewTableMap(nil)
+	tableMap.fieldsCache["app_users"] = tableColumns{fields: map[int]string{0: "id", 1: "name"}, primaryKey: []string{"id"}}
+	return tableMap
+}
+
+func newTestBinlogEvents() []*replication.BinlogEvent {
+	event := func(eventType replication.EventType, logPos uint32, e replication.Event) *replication.BinlogEvent {
+		return &replication.BinlogEvent{
+			RawData: []byte{byte(eventType), 0xfe},
+			Header:  &replication.EventHeader{EventType: eventType, LogPos: logPos, EventSize: 10},
+			Event:   e,
+		}
+	}
+
+	return []*replication.BinlogEvent{
+		event(replication.TABLE_MAP_EVENT, 110, &replication.TableMapEvent{TableID: 1, Schema: []byte("app"), Table: []byte("users")}),
+		event(replication.UPDATE_ROWS_EVENTv2, 120, &replication.RowsEvent{TableID: 1, Rows: [][]interface{}{{int64(1), "a"}}}),
+		event(replication.WRITE_ROWS_EVENTv2, 130, &replication.RowsEvent{TableID: 1, Rows: [][]interface{}{{int64(2), "b"}}}),
+		event(replication.XID_EVENT, 140, &replication.XIDEvent{XID: 7}),
+		event(replication.WRITE_ROWS_EVENTv2, 150, &replication.RowsEvent{TableID: 9, Rows: [][]interface{}{{int64(3), "c"}}}),
+	}
+}
+
+func TestResilientParsing(t *testing.T) {
+	var deadLetters bytes.Buffer
+	var messages []Message
+
+	h := newBinlogEventHandler(newTestTableMap(), func(message Message) error {
+		messages = append(messages, message)
+		return nil
+	}, ParseOptions{Resilient: true, DeadLetter: NewJsonDeadLetterSink(&deadLetters)})
+	h.file = "mysql-bin.000001"
+
+	for _, e := range newTestBinlogEvents() {
+		if err := h.handle(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(messages) != 1 || messages[0].GetType() != MESSAGE_TYPE_INSERT {
+		t.Fatalf("Expected one insert message, got %v", messages)
+	}
+
+	if h.stats.Messages != 1 || h.stats.Skipped != 2 || h.stats.DeadLettered != 2 {
+		t.Fatalf("Wrong parse stats %+v", h.stats)
+	}
+
+	lines := strings.Split(strings.TrimSpace(deadLetters.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 dead letters, got %s", deadLetters.String())
+	}
+
+	var letter DeadLetter
+	if err := json.Unmarshal([]byte(lines[0]), &letter); err != nil {
+		t.Fatal(err)
+	}
+	if letter.File != "mysql-bin.000001" || letter.Position != 110 || !bytes.Equal(letter.RawData, []byte{byte(replication.UPDATE_ROWS_EVENTv2), 0xfe}) {
+		t.Fatalf("Wrong dead letter %+v", letter)
+	}
+}
+
+func TestStrictParsingFails(t *testing.T) {
+	h := newBinlogEventHandler(newTestTableMap(), func(message Message) error {
+		return nil
+	}, ParseOptions{})
+
+	var err error
+	for _, e := range newTestBinlogEvents() {
+		if err = h.handle(e); err != nil {
+			break
+		}
+	}
+
+	var conversionError *ConversionError
+	if !errors.As(err, &conversionError) {
+		t.Fatalf("Expected conversion error, got %v", err)
+	}
+
+	if conversionError.Table != "users" || conversionError.Position != 110 {
+		t.Fatalf("Wrong conversion error %+v", conversionError)
+	}
+}
+
+func TestResilientDecodeError(t *testing.T) {
+	b := newTestBinlog().brokenTableMap().query("BEGIN").tableMap().insert(1, "a").xid(7)
+	filename := b.write(t)
+
+	if _, err := ParseBinlogFilesToMessages([]string{filename}, newTestTableMap(), ParseOptions{}, nil); err == nil {
+		t.Fatal("Expected decode error in strict mode")
+	}
+
+	var deadLetters bytes.Buffer
+	var messages []Message
+	stats, err := ParseBinlogFilesToMessages([]string{filename}, newTestTableMap(), ParseOptions{Resilient: true, DeadLetter: NewJsonDeadLetterSink(&deadLetters)}, func(message Message) error {
+		messages = append(messages, message)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(messages) != 1 || stats.Skipped != 1 || stats.DeadLettered != 1 {
+		t.Fatalf("Wrong parse result %v %+v", messages, stats)
+	}
+
+	var letter DeadLetter
+	if err := json.Unmarshal(deadLetters.Bytes(), &letter); err != nil {
+		t.Fatal(err)
+	}
+	if letter.Position != b.positions[1] || letter.EventType != replication.TABLE_MAP_EVENT.String() {
+		t.Fatalf("Wrong dead letter %+v", letter)
+	}
+}
@@ -0,0 +1,88 @@
+package mysql
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultBinlogPattern 目录下默认匹配的binlog文件
+var DefaultBinlogPattern = "mysql-bin.*"
+
+type binlogFile struct {
+	path     string
+	base     string
+	sequence int
+}
+
+// ListBinlogFiles 获取需要解析的binlog文件，按照序号排序
+// path可以是单个文件、目录(匹配DefaultBinlogPattern)或者glob表达式
+func ListBinlogFiles(path string) ([]string, error) {
+	pattern := path
+
+	if info, err := os.Stat(path); err == nil {
+		if !info.IsDir() {
+			return []string{path}, nil
+		}
+
+		pattern = filepath.Join(path, DefaultBinlogPattern)
+	}
+
+	matches, err := filepath.Glob(pattern)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var files []binlogFile
+
+	for _, match := range matches {
+		base, sequence, ok := splitBinlogFilename(match)
+
+		if !ok {
+			continue // mysql-bin.index等非数据文件
+		}
+
+		files = append(files, binlogFile{path: match, base: base, sequence: sequence})
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no binlog file found in %s", path)
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].base != files[j].base {
+			return files[i].base < files[j].base
+		}
+
+		return files[i].sequence < files[j].sequence
+	})
+
+	ret := make([]string, 0, len(files))
+	for _, file := range files {
+		ret = append(ret, file.path)
+	}
+
+	return ret, nil
+}
+
+// mysql-bin.000012 => mysql-bin, 12
+func splitBinlogFilename(path string) (string, int, bool) {
+	name := filepath.Base(path)
+	ext := filepath.Ext(name)
+
+	if len(ext) < 2 {
+		return "", 0, false
+	}
+
+	sequence, err := strconv.Atoi(ext[1:])
+
+	if err != nil {
+		return "", 0, false
+	}
+
+	return strings.TrimSuffix(name, ext), sequence, true
+}
@@ -0,0 +1,49 @@
+package mysql
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListBinlogFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "binlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"mysql-bin.000010", "mysql-bin.000002", "mysql-bin.index", "mysql-bin.000009"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := ListBinlogFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"mysql-bin.000002", "mysql-bin.000009", "mysql-bin.000010"}
+	if len(files) != len(expected) {
+		t.Fatalf("Expected %d binlog files, got %v", len(expected), files)
+	}
+	for i, name := range expected {
+		if filepath.Base(files[i]) != name {
+			t.Fatalf("Wrong binlog file order %v", files)
+		}
+	}
+
+	files, err = ListBinlogFiles(filepath.Join(dir, "mysql-bin.00000*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("Glob should match 2 binlog files, got %v", files)
+	}
+
+	if _, err := ListBinlogFiles(filepath.Join(dir, "other-bin.*")); err == nil {
+		t.Fatal("Expected error when no binlog file matched")
+	}
+}
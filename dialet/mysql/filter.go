@@ -0,0 +1,419 @@
+package mysql
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var filterTimeLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"}
+
+type filterTokenKind int
+
+const (
+	filterTokenEOF filterTokenKind = iota
+	filterTokenWord
+	filterTokenString
+	filterTokenOperator
+	filterTokenLParen
+	filterTokenRParen
+	filterTokenComma
+)
+
+type filterToken struct {
+	kind filterTokenKind
+	text string
+}
+
+func (t filterToken) isKeyword(keyword string) bool {
+	return t.kind == filterTokenWord && strings.EqualFold(t.text, keyword)
+}
+
+type FilterSyntaxError struct {
+	Expr   string
+	Reason string
+}
+
+func (e *FilterSyntaxError) Error() string {
+	return fmt.Sprintf("invalid filter expression %q: %s", e.Expr, e.Reason)
+}
+
+// ParseFilterExpression 将过滤表达式转换为Predicate，例如:
+//
+//	table in (users, orders) and type = delete
+//	not schema = mysql and time >= '2022-06-01T14:02:00Z' and time < '2022-06-01T14:10:00Z'
+//	(user_id = 42 or column.type = 'vip') and not table = audit_log
+//
+// 字段: table、schema、type、time，其他标识符视为列名(新旧数据任意一个匹配即可)，
+// 与保留字同名的列使用column.前缀
+// 运算符: = != in，time额外支持 > >= < <=；逻辑: and or not (也可以写作 && || !)；null表示NULL值
+func ParseFilterExpression(expr string) (Predicate, error) {
+	tokens, err := tokenizeFilter(expr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	p := &filterParser{expr: expr, tokens: tokens}
+
+	predicate, err := p.parseOr()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek().kind != filterTokenEOF {
+		return nil, p.errorf("unexpected %q", p.peek().text)
+	}
+
+	return predicate, nil
+}
+
+func tokenizeFilter(expr string) ([]filterToken, error) {
+	var tokens []filterToken
+	runes := []rune(expr)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, filterToken{filterTokenLParen, "("})
+			i++
+		case r == ')':
+			tokens = append(tokens, filterToken{filterTokenRParen, ")"})
+			i++
+		case r == ',':
+			tokens = append(tokens, filterToken{filterTokenComma, ","})
+			i++
+		case r == '\'' || r == '"':
+			j := i + 1
+			for j < len(runes) && runes[j] != r {
+				j++
+			}
+			if j >= len(runes) {
+				return nil, &FilterSyntaxError{Expr: expr, Reason: "unterminated string"}
+			}
+			tokens = append(tokens, filterToken{filterTokenString, string(runes[i+1 : j])})
+			i = j + 1
+		case strings.ContainsRune("=!<>&|", r):
+			j := i + 1
+			for j < len(runes) && strings.ContainsRune("=<>&|", runes[j]) {
+				j++
+			}
+			op := string(runes[i:j])
+			switch op {
+			case "&&":
+				tokens = append(tokens, filterToken{filterTokenWord, "and"})
+			case "||":
+				tokens = append(tokens, filterToken{filterTokenWord, "or"})
+			case "!":
+				tokens = append(tokens, filterToken{filterTokenWord, "not"})
+			case "=", "==", "!=", "<>", ">", ">=", "<", "<=":
+				tokens = append(tokens, filterToken{filterTokenOperator, op})
+			default:
+				return nil, &FilterSyntaxError{Expr: expr, Reason: fmt.Sprintf("unknown operator %q", op)}
+			}
+			i = j
+		default:
+			j := i
+			for j < len(runes) && !unicode.IsSpace(runes[j]) && !strings.ContainsRune("(),'\"=!<>&|", runes[j]) {
+				j++
+			}
+			tokens = append(tokens, filterToken{filterTokenWord, string(runes[i:j])})
+			i = j
+		}
+	}
+
+	return append(tokens, filterToken{kind: filterTokenEOF}), nil
+}
+
+type filterParser struct {
+	expr   string
+	tokens []filterToken
+	pos    int
+}
+
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() filterToken {
+	t := p.tokens[p.pos]
+	if t.kind != filterTokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *filterParser) errorf(format string, args ...interface{}) error {
+	return &FilterSyntaxError{Expr: p.expr, Reason: fmt.Sprintf(format, args...)}
+}
+
+func (p *filterParser) parseOr() (Predicate, error) {
+	left, err := p.parseAnd()
+
+	if err != nil {
+		return nil, err
+	}
+
+	predicates := []Predicate{left}
+	for p.peek().isKeyword("or") {
+		p.next()
+
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		predicates = append(predicates, right)
+	}
+
+	if len(predicates) == 1 {
+		return left, nil
+	}
+
+	return Or(predicates...), nil
+}
+
+func (p *filterParser) parseAnd() (Predicate, error) {
+	left, err := p.parseUnary()
+
+	if err != nil {
+		return nil, err
+	}
+
+	predicates := []Predicate{left}
+	for p.peek().isKeyword("and") {
+		p.next()
+
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		predicates = append(predicates, right)
+	}
+
+	if len(predicates) == 1 {
+		return left, nil
+	}
+
+	return And(predicates...), nil
+}
+
+func (p *filterParser) parseUnary() (Predicate, error) {
+	switch t := p.peek(); {
+	case t.isKeyword("not"):
+		p.next()
+
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return Not(inner), nil
+
+	case t.kind == filterTokenLParen:
+		p.next()
+
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if p.next().kind != filterTokenRParen {
+			return nil, p.errorf("missing )")
+		}
+
+		return inner, nil
+
+	default:
+		return p.parseCondition()
+	}
+}
+
+func (p *filterParser) parseCondition() (Predicate, error) {
+	field := p.next()
+
+	if field.kind != filterTokenWord || field.text == "" {
+		return nil, p.errorf("expected field name, got %q", field.text)
+	}
+
+	var op string
+	var values []interface{}
+
+	switch t := p.next(); {
+	case t.kind == filterTokenOperator:
+		op = t.text
+
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = []interface{}{value}
+
+	case t.isKeyword("in"):
+		op = "in"
+
+		list, err := p.parseValueList()
+		if err != nil {
+			return nil, err
+		}
+		values = list
+
+	default:
+		return nil, p.errorf("expected operator after %s, got %q", field.text, t.text)
+	}
+
+	switch op {
+	case "==":
+		op = "="
+	case "<>":
+		op = "!="
+	}
+
+	return p.buildCondition(field.text, op, values)
+}
+
+func (p *filterParser) parseValue() (interface{}, error) {
+	t := p.next()
+
+	switch {
+	case t.kind == filterTokenString:
+		return t.text, nil
+	case t.isKeyword("null"):
+		return nil, nil
+	case t.kind == filterTokenWord:
+		return t.text, nil
+	default:
+		return nil, p.errorf("expected value, got %q", t.text)
+	}
+}
+
+func (p *filterParser) parseValueList() ([]interface{}, error) {
+	if p.next().kind != filterTokenLParen {
+		return nil, p.errorf("expected ( after in")
+	}
+
+	var values []interface{}
+	for {
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+
+		switch t := p.next(); t.kind {
+		case filterTokenComma:
+			continue
+		case filterTokenRParen:
+			return values, nil
+		default:
+			return nil, p.errorf("expected , or ) in value list, got %q", t.text)
+		}
+	}
+}
+
+func (p *filterParser) buildCondition(field, op string, values []interface{}) (Predicate, error) {
+	switch strings.ToLower(field) {
+	case "table":
+		return p.buildStringCondition(field, op, values, MatchTables)
+	case "schema":
+		return p.buildStringCondition(field, op, values, MatchSchemas)
+	case "type":
+		return p.buildStringCondition(field, op, values, func(types ...string) Predicate {
+			messageTypes := make([]MessageType, 0, len(types))
+			for _, t := range types {
+				messageTypes = append(messageTypes, MessageType(t))
+			}
+			return MatchTypes(messageTypes...)
+		})
+	case "time":
+		return p.buildTimeCondition(op, values)
+	}
+
+	column := strings.TrimPrefix(field, "column.")
+
+	var match Predicate
+	switch op {
+	case "=", "!=", "in":
+		predicates := make([]Predicate, 0, len(values))
+		for _, value := range values {
+			predicates = append(predicates, MatchColumn(column, value))
+		}
+		match = Or(predicates...)
+	default:
+		return nil, p.errorf("operator %s is not supported for column %s", op, column)
+	}
+
+	if op == "!=" {
+		return Not(match), nil
+	}
+
+	return match, nil
+}
+
+func (p *filterParser) buildStringCondition(field, op string, values []interface{}, match func(...string) Predicate) (Predicate, error) {
+	strs := make([]string, 0, len(values))
+	for _, value := range values {
+		if value == nil {
+			return nil, p.errorf("%s can not be null", field)
+		}
+		strs = append(strs, value.(string))
+	}
+
+	switch op {
+	case "=", "in":
+		return match(strs...), nil
+	case "!=":
+		return Not(match(strs...)), nil
+	default:
+		return nil, p.errorf("operator %s is not supported for %s", op, field)
+	}
+}
+
+func (p *filterParser) buildTimeCondition(op string, values []interface{}) (Predicate, error) {
+	if op == "in" {
+		return nil, p.errorf("operator in is not supported for time")
+	}
+
+	value, ok := values[0].(string)
+	if !ok {
+		return nil, p.errorf("time can not be null")
+	}
+
+	var expected time.Time
+	var err error
+	for _, layout := range filterTimeLayouts {
+		if expected, err = time.ParseInLocation(layout, value, time.Local); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, p.errorf("invalid time %q", value)
+	}
+
+	return func(message Message) bool {
+		actual, err := getMessageTime(message)
+		if err != nil {
+			return false
+		}
+
+		switch op {
+		case "=":
+			return actual.Equal(expected)
+		case "!=":
+			return !actual.Equal(expected)
+		case ">":
+			return actual.After(expected)
+		case ">=":
+			return !actual.Before(expected)
+		case "<":
+			return actual.Before(expected)
+		default: // "<="
+			return !actual.After(expected)
+		}
+	}, nil
+}
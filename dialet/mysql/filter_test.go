@@ -0,0 +1,124 @@
+package mysql
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestMessages() []Message {
+	at := time.Date(2022, 6, 1, 14, 5, 0, 0, time.UTC)
+
+	return []Message{
+		NewInsertMessage(
+			NewMessageHeader("app", "users", at, 100, 1),
+			MessageRowData{Row: MessageRow{"id": int64(42), "name": "tom"}},
+		),
+		NewUpdateMessage(
+			NewMessageHeader("app", "orders", at.Add(time.Minute), 200, 2),
+			MessageRowData{Row: MessageRow{"id": int64(7), "user_id": int64(42), "type": []byte("vip")}},
+			MessageRowData{Row: MessageRow{"id": int64(7), "user_id": int64(43), "type": []byte("vip")}},
+		),
+		NewDeleteMessage(
+			NewMessageHeader("app", "audit_log", at.Add(10*time.Minute), 300, 3),
+			MessageRowData{Row: MessageRow{"id": int64(1), "user_id": nil}},
+		),
+		NewQueryMessage(
+			NewMessageHeader("mysql", "(unknown)", at, 400, 0),
+			SqlQuery("flush privileges"),
+		),
+	}
+}
+
+func matchedPositions(p Predicate, messages []Message) []uint32 {
+	var ret []uint32
+	for _, message := range messages {
+		if p(message) {
+			ret = append(ret, message.GetHeader().BinlogPosition)
+		}
+	}
+	return ret
+}
+
+func TestParseFilterExpression(t *testing.T) {
+	messages := newTestMessages()
+
+	cases := []struct {
+		expr     string
+		expected []uint32
+	}{
+		{"table = users", []uint32{100}},
+		{"table in (users, orders)", []uint32{100, 200}},
+		{"not table in (audit_log) and schema = app", []uint32{100, 200}},
+		{"type = delete", []uint32{300}},
+		{"TYPE = 'Insert' or type = update", []uint32{100, 200}},
+		{"user_id = 42", []uint32{200}},
+		{"user_id = 43 && id = 7", []uint32{200}},
+		{"user_id = null", []uint32{300}},
+		{"column.type = vip", []uint32{200}},
+		{"id in (1, 42)", []uint32{100, 300}},
+		{"time >= '2022-06-01T14:02:00Z' and time < '2022-06-01T14:10:00Z'", []uint32{100, 200, 400}},
+		{"!(schema = mysql) and (time > 2022-06-01T14:05:00Z || name != tom)", []uint32{200, 300}},
+	}
+
+	for _, c := range cases {
+		p, err := ParseFilterExpression(c.expr)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %s", c.expr, err)
+		}
+
+		actual := matchedPositions(p, messages)
+		if len(actual) != len(c.expected) {
+			t.Fatalf("Expression %q matched %v, expected %v", c.expr, actual, c.expected)
+		}
+		for i := range actual {
+			if actual[i] != c.expected[i] {
+				t.Fatalf("Expression %q matched %v, expected %v", c.expr, actual, c.expected)
+			}
+		}
+	}
+}
+
+func TestParseFilterExpressionErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"table",
+		"table = ",
+		"table in users",
+		"(table = users",
+		"table = users and",
+		"name > tom",
+		"time in (a)",
+		"time > yesterday",
+		"table = 'users",
+		"table ~ users",
+	} {
+		if _, err := ParseFilterExpression(expr); err == nil {
+			t.Fatalf("Expected error for expression %q", expr)
+		}
+	}
+}
+
+func TestConsumerChainPredicates(t *testing.T) {
+	messages := newTestMessages()
+
+	chain := NewConsumerChain()
+	chain.ExcludeTables("audit_log")
+	chain.IncludeTypes(MESSAGE_TYPE_INSERT, MESSAGE_TYPE_UPDATE)
+	chain.IncludeColumnValue("user_id", 42)
+
+	var collected []uint32
+	chain.collectors = append(chain.collectors, func(message Message) error {
+		collected = append(collected, message.GetHeader().BinlogPosition)
+		return nil
+	})
+
+	for _, message := range messages {
+		if err := chain.consumeMessage(message); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(collected) != 1 || collected[0] != 200 {
+		t.Fatalf("Wrong messages collected %v", collected)
+	}
+}
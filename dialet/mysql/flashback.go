@@ -0,0 +1,134 @@
+package mysql
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/wwqdrh/logger"
+)
+
+// FlashbackStatement 一条消息对应的正向sql以及回滚sql
+type FlashbackStatement struct {
+	Header  MessageHeader
+	Forward string
+	Undo    string
+	Notice  string // 无法生成回滚sql的原因
+}
+
+// FlashbackTransaction 同一个事务中的语句，query消息单独作为一个事务
+type FlashbackTransaction struct {
+	XId        uint64
+	Statements []FlashbackStatement
+}
+
+// Flashback 收集解析出来的消息，用于生成正向以及回滚脚本
+// 回滚脚本中事务之间、事务内部的语句都按照与原始顺序相反的顺序输出
+type Flashback struct {
+	Transactions []*FlashbackTransaction
+}
+
+func NewFlashback() *Flashback {
+	return &Flashback{}
+}
+
+func (f *Flashback) Add(message Message) {
+	statement := FlashbackStatement{Header: message.GetHeader()}
+
+	forward, err := RenderSql(message)
+	if err != nil {
+		statement.Notice = err.Error()
+	}
+	statement.Forward = forward
+
+	undo, err := RenderUndoSql(message)
+	if err != nil {
+		statement.Notice = err.Error()
+	}
+	statement.Undo = undo
+
+	if statement.Notice != "" {
+		logger.DefaultLogger.Errorx("Flashback notice: %s", []interface{}{statement.Notice})
+	}
+
+	xId := statement.Header.XId
+	if n := len(f.Transactions); xId != 0 && n > 0 && f.Transactions[n-1].XId == xId {
+		f.Transactions[n-1].Statements = append(f.Transactions[n-1].Statements, statement)
+		return
+	}
+
+	f.Transactions = append(f.Transactions, &FlashbackTransaction{XId: xId, Statements: []FlashbackStatement{statement}})
+}
+
+// WriteSql 按照原始顺序输出正向sql
+func (f *Flashback) WriteSql(stream io.Writer) error {
+	for _, transaction := range f.Transactions {
+		var statements []string
+		for _, statement := range transaction.Statements {
+			statements = append(statements, statementOrNotice(statement.Forward, statement.Notice))
+		}
+
+		if err := writeTransactionSql(stream, transaction, statements); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteUndoSql 按照相反的顺序输出回滚sql
+func (f *Flashback) WriteUndoSql(stream io.Writer) error {
+	for i := len(f.Transactions) - 1; i >= 0; i-- {
+		transaction := f.Transactions[i]
+
+		var statements []string
+		for j := len(transaction.Statements) - 1; j >= 0; j-- {
+			statement := transaction.Statements[j]
+			statements = append(statements, statementOrNotice(statement.Undo, statement.Notice))
+		}
+
+		if err := writeTransactionSql(stream, transaction, statements); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func statementOrNotice(sql, notice string) string {
+	if sql == "" {
+		return fmt.Sprintf("-- skipped: %s", notice)
+	}
+
+	return sql
+}
+
+func writeTransactionSql(stream io.Writer, transaction *FlashbackTransaction, statements []string) error {
+	first := transaction.Statements[0].Header
+	last := transaction.Statements[len(transaction.Statements)-1].Header
+
+	header := fmt.Sprintf("-- xid %d, time %s, position %d-%d\n", transaction.XId, first.BinlogMessageTime, first.BinlogPosition, last.BinlogPosition)
+	if _, err := io.WriteString(stream, header); err != nil {
+		return err
+	}
+
+	wrap := transaction.XId != 0
+	if wrap {
+		if _, err := io.WriteString(stream, "BEGIN;\n"); err != nil {
+			return err
+		}
+	}
+
+	for _, statement := range statements {
+		if _, err := fmt.Fprintf(stream, "%s\n", statement); err != nil {
+			return err
+		}
+	}
+
+	if wrap {
+		if _, err := io.WriteString(stream, "COMMIT;\n"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
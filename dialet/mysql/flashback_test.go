@@ -0,0 +1,125 @@
+package mysql
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newFlashbackHeader(position uint32, xId uint64) MessageHeader {
+	header := NewMessageHeader("app", "users", time.Date(2022, 6, 1, 14, 5, 0, 0, time.UTC), position, xId)
+	header.PrimaryKey = []string{"id"}
+	return header
+}
+
+func TestRenderSql(t *testing.T) {
+	header := newFlashbackHeader(100, 1)
+	old := MessageRowData{Row: MessageRow{"id": int64(1), "name": "o'neil", "avatar": []byte{0x01, 0xff}}}
+	newData := MessageRowData{Row: MessageRow{"id": int64(1), "name": "tom", "avatar": nil}}
+
+	cases := []struct {
+		message Message
+		forward string
+		undo    string
+	}{
+		{
+			NewInsertMessage(header, newData),
+			"INSERT INTO `app`.`users` (`avatar`, `id`, `name`) VALUES (NULL, 1, 'tom');",
+			"DELETE FROM `app`.`users` WHERE `id`=1 LIMIT 1;",
+		},
+		{
+			NewUpdateMessage(header, old, newData),
+			"UPDATE `app`.`users` SET `avatar`=NULL, `id`=1, `name`='tom' WHERE `id`=1 LIMIT 1;",
+			"UPDATE `app`.`users` SET `avatar`=X'01ff', `id`=1, `name`='o\\'neil' WHERE `id`=1 LIMIT 1;",
+		},
+		{
+			NewDeleteMessage(header, old),
+			"DELETE FROM `app`.`users` WHERE `id`=1 LIMIT 1;",
+			"INSERT INTO `app`.`users` (`avatar`, `id`, `name`) VALUES (X'01ff', 1, 'o\\'neil');",
+		},
+	}
+
+	for _, c := range cases {
+		forward, err := RenderSql(c.message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if forward != c.forward {
+			t.Fatalf("Wrong forward sql %s", forward)
+		}
+
+		undo, err := RenderUndoSql(c.message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if undo != c.undo {
+			t.Fatalf("Wrong undo sql %s", undo)
+		}
+	}
+}
+
+func TestRenderSqlWithoutPrimaryKey(t *testing.T) {
+	header := NewMessageHeader("app", "logs", time.Now(), 100, 1)
+	message := NewDeleteMessage(header, MessageRowData{Row: MessageRow{"msg": "a", "level": nil}})
+
+	forward, err := RenderSql(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if forward != "DELETE FROM `app`.`logs` WHERE `level` IS NULL AND `msg`='a' LIMIT 1;" {
+		t.Fatalf("Wrong forward sql %s", forward)
+	}
+
+	if _, err := RenderUndoSql(NewDeleteMessage(header, MessageRowData{Row: MessageRow{"(unknown_0)": 1}, MappingNotice: "mismatch"})); err == nil {
+		t.Fatal("Expected error for row with mapping notice")
+	}
+}
+
+func TestFlashbackUndoOrder(t *testing.T) {
+	chain := NewConsumerChain()
+	flashback := NewFlashback()
+	chain.CollectAsFlashback(flashback)
+
+	messages := []Message{
+		NewInsertMessage(newFlashbackHeader(100, 1), MessageRowData{Row: MessageRow{"id": int64(1), "name": "a"}}),
+		NewUpdateMessage(newFlashbackHeader(200, 1),
+			MessageRowData{Row: MessageRow{"id": int64(1), "name": "a"}},
+			MessageRowData{Row: MessageRow{"id": int64(1), "name": "b"}}),
+		NewDeleteMessage(newFlashbackHeader(300, 2), MessageRowData{Row: MessageRow{"id": int64(2), "name": "c"}}),
+	}
+	for _, message := range messages {
+		if err := chain.consumeMessage(message); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(flashback.Transactions) != 2 {
+		t.Fatalf("Expected 2 transactions, got %d", len(flashback.Transactions))
+	}
+
+	var buf bytes.Buffer
+	if err := flashback.WriteUndoSql(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var statements []string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if line != "" && !strings.HasPrefix(line, "--") {
+			statements = append(statements, line)
+		}
+	}
+
+	expected := []string{
+		"BEGIN;",
+		"INSERT INTO `app`.`users` (`id`, `name`) VALUES (2, 'c');",
+		"COMMIT;",
+		"BEGIN;",
+		"UPDATE `app`.`users` SET `id`=1, `name`='a' WHERE `id`=1 LIMIT 1;",
+		"DELETE FROM `app`.`users` WHERE `id`=1 LIMIT 1;",
+		"COMMIT;",
+	}
+	if strings.Join(statements, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Wrong undo script:\n%s", buf.String())
+	}
+}
@@ -19,6 +19,7 @@ type MessageHeader struct {
 	BinlogMessageTime string
 	BinlogPosition    uint32
 	XId               uint64
+	PrimaryKey        []string `json:",omitempty"`
 }
 
 func NewMessageHeader(schema string, table string, binlogMessageTime time.Time, binlogPosition uint32, xId uint64) MessageHeader {
@@ -1,8 +1,11 @@
 package mysql
 
 import (
+	"encoding/binary"
 	"fmt"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/go-mysql-org/go-mysql/replication"
 	"github.com/wwqdrh/logger"
@@ -10,95 +13,492 @@ import (
 
 type ConsumerFunc func(Message) error
 
+// ParseOptions 限定一次解析的范围
+// 起始位置只作用于第一个文件，结束位置只作用于最后一个文件，与mysqlbinlog的语义保持一致
+type ParseOptions struct {
+	StartPosition uint32    // 从第一个文件中该位置开始解析(包含)
+	StopPosition  uint32    // 到最后一个文件中该位置停止解析(不包含)，0表示不限制
+	StartDatetime time.Time // 忽略早于该时间的事件
+	StopDatetime  time.Time // 遇到不早于该时间的事件时停止解析
+
+	// Resilient 开启后无法转换的事件不会终止解析，而是跳过并写入DeadLetter(不为nil时)
+	Resilient  bool
+	DeadLetter DeadLetterSink
+}
+
+// ParseStats 一次解析的统计信息
+type ParseStats struct {
+	Files        int // 解析的文件数
+	Events       int // 处理的事件数
+	Messages     int // 交给consumer的消息数
+	Transactions int // 交给TransactionConsumerFunc的事务数
+	Skipped      int // 被跳过的事件数
+	DeadLettered int // 成功写入死信的事件数
+}
+
+func (o ParseOptions) beforeStart(header *replication.EventHeader, first bool) bool {
+	if first && o.StartPosition > 0 && eventStartPosition(header) < o.StartPosition {
+		return true
+	}
+
+	if !o.StartDatetime.IsZero() && time.Unix(int64(header.Timestamp), 0).Before(o.StartDatetime) {
+		return true
+	}
+
+	return false
+}
+
+func (o ParseOptions) afterStop(header *replication.EventHeader, last bool) bool {
+	if last && o.StopPosition > 0 && eventStartPosition(header) >= o.StopPosition {
+		return true
+	}
+
+	if !o.StopDatetime.IsZero() && !time.Unix(int64(header.Timestamp), 0).Before(o.StopDatetime) {
+		return true
+	}
+
+	return false
+}
+
+// header中的LogPos记录的是下一个事件的位置
+func eventStartPosition(header *replication.EventHeader) uint32 {
+	if header.LogPos < header.EventSize {
+		return 0
+	}
+
+	return header.LogPos - header.EventSize
+}
+
 func ParseBinlogToMessages(binlogFilename string, tableMap TableMap, consumer ConsumerFunc) error {
-	rowRowsEventBuffer := NewRowsEventBuffer()
+	_, err := ParseBinlogFilesToMessages([]string{binlogFilename}, tableMap, ParseOptions{}, consumer)
+	return err
+}
 
+// ParseBinlogFilesToMessages 按顺序解析多个binlog文件，文件之间共享tableMap以及未提交的事务
+func ParseBinlogFilesToMessages(binlogFilenames []string, tableMap TableMap, options ParseOptions, consumer ConsumerFunc) (ParseStats, error) {
+	return parseBinlogFiles(binlogFilenames, options, newBinlogEventHandler(tableMap, consumer, options))
+}
+
+// ParseBinlogFilesToTransactions 与ParseBinlogFilesToMessages相同，但是每个事务只产生一条记录
+// 事务外的query(例如ddl)单独作为一个xid为0的事务
+func ParseBinlogFilesToTransactions(binlogFilenames []string, tableMap TableMap, options ParseOptions, consumer TransactionConsumerFunc) (ParseStats, error) {
+	h := newBinlogEventHandler(tableMap, nil, options)
+	h.transactionConsumer = consumer
+
+	return parseBinlogFiles(binlogFilenames, options, h)
+}
+
+func parseBinlogFiles(binlogFilenames []string, options ParseOptions, h *binlogEventHandler) (ParseStats, error) {
 	p := replication.NewBinlogParser()
+	p.SetUseDecimal(true) // 避免decimal转换为float64丢失精度
+
+	if len(binlogFilenames) > 0 && options.StartPosition > 0 {
+		// 起始位置在事务中间时从事务开始的位置解析，否则事务中的行事件缺少TABLE_MAP_EVENT无法映射
+		start, err := transactionStart(binlogFilenames[0], options.StartPosition)
+		if err != nil {
+			logger.DefaultLogger.Error(fmt.Sprintf("Failed to find transaction start before position %d: %s", options.StartPosition, err))
+		} else if start != options.StartPosition {
+			logger.DefaultLogger.Infox("Start position %d is inside a transaction, rewinding to %d", []interface{}{options.StartPosition, start})
+			options.StartPosition = start
+		}
+	}
 
-	f := func(e *replication.BinlogEvent) error {
-		switch e.Header.EventType {
-		case replication.QUERY_EVENT:
-			queryEvent := e.Event.(*replication.QueryEvent)
-			query := string(queryEvent.Query)
+	for index, binlogFilename := range binlogFilenames {
+		first, last := index == 0, index == len(binlogFilenames)-1
 
-			if strings.ToUpper(strings.Trim(query, " ")) == "BEGIN" {
-				logger.DefaultLogger.Info("Starting transaction")
-			} else if strings.HasPrefix(strings.ToUpper(strings.Trim(query, " ")), "SAVEPOINT") {
-				logger.DefaultLogger.Info("Skipping transaction savepoint")
-			} else {
-				logger.DefaultLogger.Info("Query event")
+		offset := int64(0)
+		if first {
+			offset = int64(options.StartPosition)
+		}
 
-				err := consumer(ConvertQueryEventToMessage(*e.Header, *queryEvent))
+		logger.DefaultLogger.Infox("Parsing binlog file %s", []interface{}{binlogFilename})
 
-				if err != nil {
-					return err
-				}
-			}
+		h.file = binlogFilename
+		h.stats.Files++
 
+		stopped, err := parseFile(p, binlogFilename, offset, options, first, last, h)
+		if err != nil {
+			return h.stats, err
+		}
+
+		if stopped {
+			logger.DefaultLogger.Infox("Reached stop condition in binlog file %s", []interface{}{binlogFilename})
 			break
+		}
+	}
 
-		case replication.XID_EVENT:
-			xidEvent := e.Event.(*replication.XIDEvent)
-			xId := uint64(xidEvent.XID)
+	logger.DefaultLogger.Infox("Parsed %d events into %d messages, skipped %d, dead-lettered %d", []interface{}{
+		h.stats.Events, h.stats.Messages, h.stats.Skipped, h.stats.DeadLettered,
+	})
 
-			logger.DefaultLogger.Info(fmt.Sprintf("Ending transaction xID %d", xId))
+	return h.stats, nil
+}
 
-			for _, message := range ConvertRowsEventsToMessages(xId, rowRowsEventBuffer.Drain()) {
-				err := consumer(message)
+// parseFile 解析单个文件，resilient模式下无法解析的事件写入死信，从下一个事件继续
+func parseFile(p *replication.BinlogParser, binlogFilename string, offset int64, options ParseOptions, first, last bool, h *binlogEventHandler) (bool, error) {
+	for {
+		var (
+			stopped   bool
+			handleErr error
+		)
+
+		// 下一个事件的起始位置，解析失败时用于定位失败的事件
+		next := offset
+		if next < 4 {
+			next = 4
+		}
 
-				if err != nil {
-					return err
+		err := p.ParseFile(binlogFilename, offset, func(e *replication.BinlogEvent) error {
+			// offset大于4时会先读取文件开头的FORMAT_DESCRIPTION_EVENT
+			if e.Header.EventType == replication.FORMAT_DESCRIPTION_EVENT && offset > 4 {
+				return nil
+			}
+			next += int64(e.Header.EventSize)
+
+			// 表结构信息需要始终维护，否则窗口内的行事件无法映射
+			if e.Header.EventType == replication.TABLE_MAP_EVENT {
+				handleErr = h.handle(e)
+				return handleErr
+			}
+
+			if options.afterStop(e.Header, last) {
+				stopped = true
+				p.Stop()
+				return nil
+			}
+
+			if options.beforeStart(e.Header, first) {
+				return nil
+			}
+
+			handleErr = h.handle(e)
+			return handleErr
+		})
+
+		if err == nil || handleErr != nil || !options.Resilient {
+			return stopped, err
+		}
+
+		// 事件本身无法解析
+		header, rawData, readErr := readRawEvent(binlogFilename, next)
+		if readErr != nil {
+			logger.DefaultLogger.Error(fmt.Sprintf("Failed to read broken event at %s:%d - %s", binlogFilename, next, readErr))
+			return stopped, err
+		}
+
+		if options.afterStop(header, last) {
+			return true, nil
+		}
+
+		if !options.beforeStart(header, first) {
+			h.stats.Events++
+			if err := h.reject(*header, rawData, err); err != nil {
+				return stopped, err
+			}
+		}
+
+		offset = next + int64(header.EventSize)
+	}
+}
+
+// readRawEvent 读取position处的原始事件
+func readRawEvent(binlogFilename string, position int64) (*replication.EventHeader, []byte, error) {
+	f, err := os.Open(binlogFilename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	rawData := make([]byte, replication.EventHeaderSize)
+	if _, err := f.ReadAt(rawData, position); err != nil {
+		return nil, nil, err
+	}
+
+	header := &replication.EventHeader{}
+	if err := header.Decode(rawData); err != nil {
+		return nil, nil, err
+	}
+
+	rawData = make([]byte, header.EventSize)
+	if _, err := f.ReadAt(rawData, position); err != nil {
+		return nil, nil, err
+	}
+
+	return header, rawData, nil
+}
+
+// transactionStart 返回包含position的事务的起始位置(GTID或者BEGIN事件)，position不在事务中时原样返回
+func transactionStart(binlogFilename string, position uint32) (uint32, error) {
+	var (
+		start uint32
+		open  bool // 在GTID或者BEGIN之后，事务还没有结束
+		begun bool // 在BEGIN之后
+	)
+
+	// raw模式只解析FORMAT_DESCRIPTION_EVENT，行事件没有对应的表结构时不会报错
+	p := replication.NewBinlogParser()
+	p.SetRawMode(true)
+	err := p.ParseFile(binlogFilename, 0, func(e *replication.BinlogEvent) error {
+		at := eventStartPosition(e.Header)
+		if at >= position {
+			p.Stop()
+			return nil
+		}
+
+		switch e.Header.EventType {
+		case replication.GTID_EVENT, replication.ANONYMOUS_GTID_EVENT, replication.MARIADB_GTID_EVENT:
+			start, open, begun = at, true, false
+		case replication.QUERY_EVENT:
+			switch rawQuery(e) {
+			case "BEGIN":
+				if !open {
+					start = at
+				}
+				open, begun = true, true
+			case "COMMIT", "ROLLBACK":
+				open, begun = false, false
+			default:
+				// 事务外的query(例如ddl)单独作为一个事务
+				if !begun {
+					open = false
 				}
 			}
+		case replication.XID_EVENT:
+			open, begun = false, false
+		}
+		return nil
+	})
+	if err != nil {
+		return position, err
+	}
 
-			break
+	if open {
+		return start, nil
+	}
+	return position, nil
+}
+
+// rawQuery raw模式下的QUERY_EVENT，Data为去掉校验和之后的事件体
+func rawQuery(e *replication.BinlogEvent) string {
+	generic, ok := e.Event.(*replication.GenericEvent)
+	if !ok || len(generic.Data) < 13 {
+		return ""
+	}
+	// 固定部分13字节，之后是状态变量、库名以及0，长度不足时Decode会越界
+	data := generic.Data
+	if len(data) < 13+int(binary.LittleEndian.Uint16(data[11:]))+int(data[8])+1 {
+		return ""
+	}
+
+	var query replication.QueryEvent
+	if err := query.Decode(data); err != nil {
+		return ""
+	}
+
+	return strings.ToUpper(strings.Trim(string(query.Query), " "))
+}
+
+type binlogEventHandler struct {
+	tableMap           TableMap
+	rowRowsEventBuffer RowsEventBuffer
+	consumer           ConsumerFunc
+	options            ParseOptions
+	file               string
+	stats              ParseStats
+
+	transactionConsumer TransactionConsumerFunc
+	inTransaction       bool
+	beginTime           time.Time
+	pendingQueries      []Message // 事务内的query，提交时与行数据一起输出
+}
 
-		case replication.TABLE_MAP_EVENT:
-			tableMapEvent := e.Event.(*replication.TableMapEvent)
+func newBinlogEventHandler(tableMap TableMap, consumer ConsumerFunc, options ParseOptions) *binlogEventHandler {
+	return &binlogEventHandler{
+		tableMap:           tableMap,
+		rowRowsEventBuffer: NewRowsEventBuffer(),
+		consumer:           consumer,
+		options:            options,
+	}
+}
 
-			schema := string(tableMapEvent.Schema)
-			table := string(tableMapEvent.Table)
-			tableId := uint64(tableMapEvent.TableID)
+func (h *binlogEventHandler) consume(message Message) error {
+	h.stats.Messages++
 
-			err := tableMap.Add(tableId, schema, table)
+	if h.consumer == nil {
+		return nil
+	}
+
+	return h.consumer(message)
+}
+
+func (h *binlogEventHandler) consumeTransaction(xId uint64, commit replication.EventHeader, messages []Message) error {
+	beginTime := h.beginTime
+	if !h.inTransaction {
+		beginTime = time.Unix(int64(commit.Timestamp), 0)
+	}
+
+	messages = append(h.pendingQueries, messages...)
+	h.inTransaction = false
+	h.pendingQueries = nil
+
+	if h.transactionConsumer == nil || len(messages) == 0 {
+		return nil
+	}
+
+	h.stats.Transactions++
+
+	return h.transactionConsumer(NewTransaction(xId, beginTime, commit, messages))
+}
+
+// reject 记录无法处理的事件，非resilient模式下返回reason终止解析
+func (h *binlogEventHandler) reject(header replication.EventHeader, rawData []byte, reason error) error {
+	h.stats.Skipped++
+
+	logger.DefaultLogger.Error(fmt.Sprintf("Skipping event at %s:%d - %s", h.file, eventStartPosition(&header), reason))
+
+	if !h.options.Resilient {
+		return reason
+	}
+
+	if h.options.DeadLetter == nil {
+		return nil
+	}
+
+	err := h.options.DeadLetter.Write(NewDeadLetter(h.file, header, rawData, reason))
+
+	if err != nil {
+		logger.DefaultLogger.Error(fmt.Sprintf("Failed to write dead letter %s", err))
+		return nil
+	}
+
+	h.stats.DeadLettered++
+
+	return nil
+}
+
+func (h *binlogEventHandler) handle(e *replication.BinlogEvent) error {
+	h.stats.Events++
+
+	switch e.Header.EventType {
+	case replication.QUERY_EVENT:
+		queryEvent := e.Event.(*replication.QueryEvent)
+		query := string(queryEvent.Query)
+
+		if strings.ToUpper(strings.Trim(query, " ")) == "BEGIN" {
+			logger.DefaultLogger.Info("Starting transaction")
+
+			h.inTransaction = true
+			h.beginTime = time.Unix(int64(e.Header.Timestamp), 0)
+			h.pendingQueries = nil
+		} else if strings.HasPrefix(strings.ToUpper(strings.Trim(query, " ")), "SAVEPOINT") {
+			logger.DefaultLogger.Info("Skipping transaction savepoint")
+		} else {
+			logger.DefaultLogger.Info("Query event")
+
+			message := ConvertQueryEventToMessage(*e.Header, *queryEvent)
+			err := h.consume(message)
 
 			if err != nil {
-				logger.DefaultLogger.Error(fmt.Errorf("Failed to add table information for table %s.%s (id %d)", schema, table, tableId).Error())
 				return err
 			}
 
-			break
+			if h.inTransaction {
+				h.pendingQueries = append(h.pendingQueries, message)
+			} else if err := h.consumeTransaction(0, *e.Header, []Message{message}); err != nil {
+				return err
+			}
+		}
+
+		break
 
-		case replication.WRITE_ROWS_EVENTv1,
-			replication.UPDATE_ROWS_EVENTv1,
-			replication.DELETE_ROWS_EVENTv1,
-			replication.WRITE_ROWS_EVENTv2,
-			replication.UPDATE_ROWS_EVENTv2,
-			replication.DELETE_ROWS_EVENTv2:
-			rowsEvent := e.Event.(*replication.RowsEvent)
+	case replication.XID_EVENT:
+		xidEvent := e.Event.(*replication.XIDEvent)
+		xId := uint64(xidEvent.XID)
 
-			tableId := uint64(rowsEvent.TableID)
-			tableMetadata, ok := tableMap.LookupTableMetadata(tableId)
+		logger.DefaultLogger.Info(fmt.Sprintf("Ending transaction xID %d", xId))
 
-			if !ok {
-				logger.DefaultLogger.Error(fmt.Sprintf("Skipping event - no table found for table id %d", tableId))
-				break
+		var committed []Message
+
+		for _, d := range h.rowRowsEventBuffer.Drain() {
+			messages, err := ConvertRowsEventToMessages(xId, d)
+
+			if err != nil {
+				if err := h.reject(d.BinlogEventHeader, d.RawData, err); err != nil {
+					return err
+				}
+
+				continue
 			}
 
-			rowRowsEventBuffer.BufferRowsEventData(
-				NewRowsEventData(*e.Header, *rowsEvent, tableMetadata),
-			)
+			for _, message := range messages {
+				err := h.consume(message)
 
-			break
+				if err != nil {
+					return err
+				}
+			}
+
+			committed = append(committed, messages...)
+		}
+
+		if err := h.consumeTransaction(xId, *e.Header, committed); err != nil {
+			return err
+		}
+
+		break
+
+	case replication.TABLE_MAP_EVENT:
+		tableMapEvent := e.Event.(*replication.TableMapEvent)
+
+		schema := string(tableMapEvent.Schema)
+		table := string(tableMapEvent.Table)
+		tableId := uint64(tableMapEvent.TableID)
+
+		err := h.tableMap.Add(tableId, schema, table)
+
+		if err != nil {
+			logger.DefaultLogger.Error(fmt.Errorf("Failed to add table information for table %s.%s (id %d)", schema, table, tableId).Error())
+			return h.reject(*e.Header, e.RawData, err)
+		}
 
-		default:
+		break
+
+	case replication.WRITE_ROWS_EVENTv1,
+		replication.UPDATE_ROWS_EVENTv1,
+		replication.DELETE_ROWS_EVENTv1,
+		replication.WRITE_ROWS_EVENTv2,
+		replication.UPDATE_ROWS_EVENTv2,
+		replication.DELETE_ROWS_EVENTv2:
+		rowsEvent := e.Event.(*replication.RowsEvent)
+
+		tableId := uint64(rowsEvent.TableID)
+		tableMetadata, ok := h.tableMap.LookupTableMetadata(tableId)
+
+		if !ok {
+			err := fmt.Errorf("no table found for table id %d", tableId)
+
+			if h.options.Resilient {
+				return h.reject(*e.Header, e.RawData, err)
+			}
+
+			// 与之前的行为保持一致，仅跳过该事件
+			logger.DefaultLogger.Error(fmt.Sprintf("Skipping event - %s", err))
+			h.stats.Skipped++
 			break
 		}
 
-		return nil
+		d := NewRowsEventData(*e.Header, *rowsEvent, tableMetadata)
+		d.RawData = e.RawData
+
+		h.rowRowsEventBuffer.BufferRowsEventData(d)
+
+		break
+
+	default:
+		break
 	}
 
-	return p.ParseFile(binlogFilename, 0, f)
+	return nil
 }
 
 type RowsEventBuffer struct {
@@ -120,21 +520,22 @@ func (mb *RowsEventBuffer) Drain() []RowsEventData {
 	return ret
 }
 
-type binlogParseFunc func(string) error
+type binlogParseFunc func(string) (ParseStats, error)
 
-func createBinlogParseFunc(dbDsn string, consumerChain ConsumerChain) binlogParseFunc {
-	return func(binlogFilename string) error {
-		return parseBinlogFile(binlogFilename, dbDsn, consumerChain)
+func createBinlogParseFunc(dbDsn string, consumerChain ConsumerChain, options ParseOptions) binlogParseFunc {
+	return func(binlogPath string) (ParseStats, error) {
+		return parseBinlogFile(binlogPath, dbDsn, consumerChain, options)
 	}
 }
 
-func parseBinlogFile(binlogFilename, dbDsn string, consumerChain ConsumerChain) error {
-	logger.DefaultLogger.Infox("Parsing binlog file %s", []interface{}{binlogFilename})
+// binlogPath可以是单个文件、目录或者glob表达式
+func parseBinlogFile(binlogPath, dbDsn string, consumerChain ConsumerChain, options ParseOptions) (ParseStats, error) {
+	logger.DefaultLogger.Infox("Parsing binlog path %s", []interface{}{binlogPath})
 
 	db, err := GetDatabaseInstance(dbDsn)
 
 	if err != nil {
-		return err
+		return ParseStats{}, err
 	}
 
 	defer db.Close()
@@ -143,5 +544,5 @@ func parseBinlogFile(binlogFilename, dbDsn string, consumerChain ConsumerChain)
 
 	logger.DefaultLogger.Info("About to parse file ...")
 
-	return ParseBinlog(binlogFilename, tableMap, consumerChain)
+	return ParseBinlogPath(binlogPath, tableMap, consumerChain, options)
 }
@@ -0,0 +1,174 @@
+package mysql
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+func TestParseOptionsWindow(t *testing.T) {
+	start := time.Date(2022, 6, 1, 14, 2, 0, 0, time.UTC)
+	stop := time.Date(2022, 6, 1, 14, 10, 0, 0, time.UTC)
+	options := ParseOptions{StartPosition: 120, StopPosition: 500, StartDatetime: start, StopDatetime: stop}
+
+	header := func(at time.Time, logPos, size uint32) *replication.EventHeader {
+		return &replication.EventHeader{Timestamp: uint32(at.Unix()), LogPos: logPos, EventSize: size}
+	}
+
+	if !options.beforeStart(header(start.Add(-time.Second), 200, 50), false) {
+		t.Fatal("Event before start datetime should be skipped")
+	}
+
+	if !options.beforeStart(header(start, 150, 50), true) {
+		t.Fatal("Event before start position should be skipped in first file")
+	}
+
+	if options.beforeStart(header(start, 150, 50), false) {
+		t.Fatal("Start position should only apply to first file")
+	}
+
+	if options.afterStop(header(start.Add(time.Minute), 520, 50), true) {
+		t.Fatal("Event starting before stop position should be parsed")
+	}
+
+	if !options.afterStop(header(start.Add(time.Minute), 600, 50), true) {
+		t.Fatal("Event after stop position should stop parsing in last file")
+	}
+
+	if options.afterStop(header(start.Add(time.Minute), 600, 50), false) {
+		t.Fatal("Stop position should only apply to last file")
+	}
+
+	if !options.afterStop(header(stop, 200, 50), false) {
+		t.Fatal("Event at stop datetime should stop parsing")
+	}
+}
+
+// testBinlog 生成不带校验和的binlog文件，记录每个事件的起始位置
+type testBinlog struct {
+	data      []byte
+	positions []uint32
+}
+
+func newTestBinlog() *testBinlog {
+	b := &testBinlog{data: append([]byte{}, replication.BinLogFileHeader...)}
+
+	// FORMAT_DESCRIPTION_EVENT，5.0版本没有校验和
+	body := make([]byte, 2+50+4+1+40)
+	binary.LittleEndian.PutUint16(body, 4)
+	copy(body[2:], "5.0.0")
+	body[56] = byte(replication.EventHeaderSize)
+
+	return b.add(replication.FORMAT_DESCRIPTION_EVENT, body)
+}
+
+func (b *testBinlog) add(eventType replication.EventType, body []byte) *testBinlog {
+	size := uint32(replication.EventHeaderSize + len(body))
+	start := uint32(len(b.data))
+
+	header := make([]byte, replication.EventHeaderSize)
+	binary.LittleEndian.PutUint32(header, uint32(time.Date(2022, 6, 1, 14, 0, 0, 0, time.UTC).Unix()))
+	header[4] = byte(eventType)
+	binary.LittleEndian.PutUint32(header[9:], size)
+	binary.LittleEndian.PutUint32(header[13:], start+size)
+
+	b.positions = append(b.positions, start)
+	b.data = append(append(b.data, header...), body...)
+
+	return b
+}
+
+func (b *testBinlog) query(query string) *testBinlog {
+	body := make([]byte, 13)
+	return b.add(replication.QUERY_EVENT, append(append(body, 0), query...))
+}
+
+// tableMap app.users(id int, name varchar(255))，列类型3为LONG，15为VARCHAR
+func (b *testBinlog) tableMap() *testBinlog {
+	body := []byte{1, 0, 0, 0, 0, 0, 0, 0, 3}
+	body = append(body, "app"...)
+	body = append(body, 0, 5)
+	body = append(body, "users"...)
+	body = append(body, 0, 2, 3, 15, 2, 0xff, 0, 0)
+
+	return b.add(replication.TABLE_MAP_EVENT, body)
+}
+
+// brokenTableMap 缺少null bitmap，解析时返回错误
+func (b *testBinlog) brokenTableMap() *testBinlog {
+	body := []byte{2, 0, 0, 0, 0, 0, 0, 0, 3}
+	body = append(body, "app"...)
+	body = append(body, 0, 5)
+	body = append(body, "users"...)
+	body = append(body, 0, 1, 3, 0)
+
+	return b.add(replication.TABLE_MAP_EVENT, body)
+}
+
+func (b *testBinlog) insert(id uint32, name string) *testBinlog {
+	body := []byte{1, 0, 0, 0, 0, 0, 0, 0, 2, 0, 2, 3, 0}
+	value := make([]byte, 4)
+	binary.LittleEndian.PutUint32(value, id)
+	body = append(body, value...)
+	body = append(append(body, byte(len(name))), name...)
+
+	return b.add(replication.WRITE_ROWS_EVENTv2, body)
+}
+
+func (b *testBinlog) xid(xid uint64) *testBinlog {
+	body := make([]byte, 8)
+	binary.LittleEndian.PutUint64(body, xid)
+
+	return b.add(replication.XID_EVENT, body)
+}
+
+func (b *testBinlog) write(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "binlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	filename := filepath.Join(dir, "mysql-bin.000001")
+	if err := ioutil.WriteFile(filename, b.data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return filename
+}
+
+func TestParseStartInsideTransaction(t *testing.T) {
+	b := newTestBinlog().query("BEGIN").tableMap().insert(1, "a").insert(2, "b").xid(7).
+		query("BEGIN").tableMap().insert(3, "c").xid(8)
+	filename := b.write(t)
+
+	// 第二个事务开始之后的行事件，事务1的BEGIN以及TABLE_MAP_EVENT在起始位置之前
+	start := b.positions[4]
+	if got, err := transactionStart(filename, start); err != nil || got != b.positions[1] {
+		t.Fatalf("Expected transaction start %d, got %d %v", b.positions[1], got, err)
+	}
+
+	if got, err := transactionStart(filename, b.positions[6]); err != nil || got != b.positions[6] {
+		t.Fatalf("Position between transactions should not rewind, got %d %v", got, err)
+	}
+
+	var ids []interface{}
+	_, err := ParseBinlogFilesToMessages([]string{filename}, newTestTableMap(), ParseOptions{StartPosition: start}, func(message Message) error {
+		if insert, ok := message.(InsertMessage); ok {
+			ids = append(ids, insert.Data.Row["id"])
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ids) != 3 {
+		t.Fatalf("Expected whole transaction to be parsed, got %v", ids)
+	}
+}
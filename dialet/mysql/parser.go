@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"time"
 
 	"github.com/wwqdrh/logger"
 )
@@ -17,16 +18,36 @@ func ParseBinlog(binlogFilename string, tableMap TableMap, consumerChain Consume
 	return ParseBinlogToMessages(binlogFilename, tableMap, consumerChain.consumeMessage)
 }
 
+// ParseBinlogPath 解析目录或glob匹配到的所有binlog文件，并按照options限定范围
+func ParseBinlogPath(binlogPath string, tableMap TableMap, consumerChain ConsumerChain, options ParseOptions) (ParseStats, error) {
+	binlogFilenames, err := ListBinlogFiles(binlogPath)
+
+	if err != nil {
+		return ParseStats{}, err
+	}
+
+	return ParseBinlogFilesToMessages(binlogFilenames, tableMap, options, consumerChain.consumeMessage)
+}
+
+// ParseBinlogWithDsn 连接dsn对应的数据库获取表结构，然后解析binlogPath
+func ParseBinlogWithDsn(binlogPath, dbDsn string, consumerChain ConsumerChain, options ParseOptions) (ParseStats, error) {
+	return createBinlogParseFunc(dbDsn, consumerChain, options)(binlogPath)
+}
+
 type ConsumerChain struct {
-	predicates  []predicate
-	collectors  []collector
-	prettyPrint bool
+	predicates            []Predicate
+	collectors            []collector
+	transactionCollectors []transactionCollector
+	prettyPrint           bool
 }
 
-type predicate func(message Message) bool
+// Predicate 判断消息是否需要交给collector处理
+type Predicate func(message Message) bool
 
 type collector func(message Message) error
 
+type transactionCollector func(transaction Transaction) error
+
 func NewConsumerChain() ConsumerChain {
 	return ConsumerChain{}
 }
@@ -39,6 +60,46 @@ func (c *ConsumerChain) IncludeSchemas(schemas ...string) {
 	c.predicates = append(c.predicates, schemaPredicate(schemas...))
 }
 
+func (c *ConsumerChain) ExcludeTables(tables ...string) {
+	c.predicates = append(c.predicates, Not(MatchTables(tables...)))
+}
+
+func (c *ConsumerChain) ExcludeSchemas(schemas ...string) {
+	c.predicates = append(c.predicates, Not(MatchSchemas(schemas...)))
+}
+
+func (c *ConsumerChain) IncludeTypes(types ...MessageType) {
+	c.predicates = append(c.predicates, MatchTypes(types...))
+}
+
+// IncludeTimeRange 只保留[start, stop)内的消息，零值表示不限制
+func (c *ConsumerChain) IncludeTimeRange(start, stop time.Time) {
+	c.predicates = append(c.predicates, MatchTimeRange(start, stop))
+}
+
+// IncludeColumnValue 新旧数据中任意一个满足column=value即保留
+func (c *ConsumerChain) IncludeColumnValue(column string, value interface{}) {
+	c.predicates = append(c.predicates, MatchColumn(column, value))
+}
+
+// Filter 添加自定义的条件，可以使用And、Or、Not组合
+func (c *ConsumerChain) Filter(predicates ...Predicate) {
+	c.predicates = append(c.predicates, predicates...)
+}
+
+// FilterExpression 解析过滤表达式并添加到chain中，语法参考ParseFilterExpression
+func (c *ConsumerChain) FilterExpression(expr string) error {
+	p, err := ParseFilterExpression(expr)
+
+	if err != nil {
+		return err
+	}
+
+	c.predicates = append(c.predicates, p)
+
+	return nil
+}
+
 func (c *ConsumerChain) PrettyPrint(prettyPrint bool) {
 	c.prettyPrint = prettyPrint
 }
@@ -47,15 +108,71 @@ func (c *ConsumerChain) CollectAsJson(stream io.Writer, prettyPrint bool) {
 	c.collectors = append(c.collectors, streamCollector(stream, prettyPrint))
 }
 
-func (c *ConsumerChain) consumeMessage(message Message) error {
+// CollectAsSql 按照原始顺序输出每条消息对应的sql
+func (c *ConsumerChain) CollectAsSql(stream io.Writer) {
+	c.collectors = append(c.collectors, sqlCollector(stream))
+}
+
+// CollectAsFlashback 将消息收集到flashback中，解析完成后通过WriteUndoSql输出回滚脚本
+func (c *ConsumerChain) CollectAsFlashback(flashback *Flashback) {
+	c.collectors = append(c.collectors, flashbackCollector(flashback))
+}
+
+// CollectTransactionsAsJson 每个事务输出一条json记录，配合ConsumeTransaction使用
+func (c *ConsumerChain) CollectTransactionsAsJson(stream io.Writer, prettyPrint bool) {
+	c.transactionCollectors = append(c.transactionCollectors, transactionStreamCollector(stream, prettyPrint))
+}
+
+// ConsumeTransaction 可以作为TransactionConsumerFunc传给ParseBinlogFilesToTransactions
+// 事务中只保留满足条件的消息，没有剩余消息的事务会被忽略
+func (c *ConsumerChain) ConsumeTransaction(transaction Transaction) error {
+	var messages []Message
+
+	for _, message := range transaction.Messages {
+		if c.match(message) {
+			messages = append(messages, message)
+		}
+	}
+
+	if len(messages) == 0 {
+		return nil
+	}
+
+	transaction.setMessages(messages)
+
+	for _, collector := range c.transactionCollectors {
+		collector_err := collector(transaction)
+
+		if collector_err != nil {
+			return collector_err
+		}
+	}
+
+	return nil
+}
+
+// Consume 可以作为ConsumerFunc传给ParseBinlogFilesToMessages
+func (c *ConsumerChain) Consume(message Message) error {
+	return c.consumeMessage(message)
+}
+
+func (c *ConsumerChain) match(message Message) bool {
 	for _, predicate := range c.predicates {
 		pass := predicate(message)
 
 		if !pass {
-			return nil
+			return false
 		}
 	}
 
+	return true
+}
+
+func (c *ConsumerChain) consumeMessage(message Message) error {
+	if !c.match(message) {
+		return nil
+	}
+
 	for _, collector := range c.collectors {
 		collector_err := collector(message)
 
@@ -89,7 +206,55 @@ func streamCollector(stream io.Writer, prettyPrint bool) collector {
 	}
 }
 
-func schemaPredicate(databases ...string) predicate {
+func transactionStreamCollector(stream io.Writer, prettyPrint bool) transactionCollector {
+	return func(transaction Transaction) error {
+		json, err := marshalJson(transaction, prettyPrint)
+
+		if err != nil {
+			logger.DefaultLogger.Errorx("Failed to convert transaction to JSON: %s", []interface{}{err})
+			return err
+		}
+
+		_, err = stream.Write([]byte(fmt.Sprintf("%s\n", json)))
+
+		if err != nil {
+			logger.DefaultLogger.Errorx("Failed to write transaction JSON to file %s", []interface{}{err})
+			return err
+		}
+
+		return nil
+	}
+}
+
+func sqlCollector(stream io.Writer) collector {
+	return func(message Message) error {
+		sql, err := RenderSql(message)
+
+		if err != nil {
+			logger.DefaultLogger.Errorx("Failed to convert message to SQL: %s", []interface{}{err})
+			sql = statementOrNotice("", err.Error())
+		}
+
+		_, err = stream.Write([]byte(fmt.Sprintf("%s\n", sql)))
+
+		if err != nil {
+			logger.DefaultLogger.Errorx("Failed to write message SQL to file %s", []interface{}{err})
+			return err
+		}
+
+		return nil
+	}
+}
+
+func flashbackCollector(flashback *Flashback) collector {
+	return func(message Message) error {
+		flashback.Add(message)
+
+		return nil
+	}
+}
+
+func schemaPredicate(databases ...string) Predicate {
 	return func(message Message) bool {
 		if message.GetHeader().Schema == "" {
 			return true
@@ -99,7 +264,7 @@ func schemaPredicate(databases ...string) predicate {
 	}
 }
 
-func tablesPredicate(tables ...string) predicate {
+func tablesPredicate(tables ...string) Predicate {
 	return func(message Message) bool {
 		if message.GetHeader().Table == "" {
 			return true
@@ -110,11 +275,15 @@ func tablesPredicate(tables ...string) predicate {
 }
 
 func marshalMessage(message Message, prettyPrint bool) ([]byte, error) {
+	return marshalJson(message, prettyPrint)
+}
+
+func marshalJson(v interface{}, prettyPrint bool) ([]byte, error) {
 	if prettyPrint {
-		return json.MarshalIndent(message, "", "    ")
+		return json.MarshalIndent(v, "", "    ")
 	}
 
-	return json.Marshal(message)
+	return json.Marshal(v)
 }
 
 func contains(s []string, e string) bool {
@@ -0,0 +1,135 @@
+package mysql
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+func And(predicates ...Predicate) Predicate {
+	return func(message Message) bool {
+		for _, p := range predicates {
+			if !p(message) {
+				return false
+			}
+		}
+
+		return true
+	}
+}
+
+func Or(predicates ...Predicate) Predicate {
+	return func(message Message) bool {
+		for _, p := range predicates {
+			if p(message) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+func Not(p Predicate) Predicate {
+	return func(message Message) bool {
+		return !p(message)
+	}
+}
+
+func MatchTables(tables ...string) Predicate {
+	return func(message Message) bool {
+		return contains(tables, message.GetHeader().Table)
+	}
+}
+
+func MatchSchemas(schemas ...string) Predicate {
+	return func(message Message) bool {
+		return contains(schemas, message.GetHeader().Schema)
+	}
+}
+
+// MatchTypes 类型名称不区分大小写，例如"delete"与MESSAGE_TYPE_DELETE等价
+func MatchTypes(types ...MessageType) Predicate {
+	return func(message Message) bool {
+		for _, t := range types {
+			if strings.EqualFold(string(t), string(message.GetType())) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// MatchTimeRange 消息时间在[start, stop)内，零值表示不限制
+func MatchTimeRange(start, stop time.Time) Predicate {
+	return func(message Message) bool {
+		messageTime, err := getMessageTime(message)
+
+		if err != nil {
+			return false
+		}
+
+		if !start.IsZero() && messageTime.Before(start) {
+			return false
+		}
+
+		if !stop.IsZero() && !messageTime.Before(stop) {
+			return false
+		}
+
+		return true
+	}
+}
+
+// MatchColumn 新旧数据中任意一个包含column且值等于value
+// value为nil时匹配NULL
+func MatchColumn(column string, value interface{}) Predicate {
+	return func(message Message) bool {
+		for _, row := range getMessageRows(message) {
+			if actual, ok := row[column]; ok && columnValueEqual(actual, value) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+func getMessageTime(message Message) (time.Time, error) {
+	return time.Parse(time.RFC3339, message.GetHeader().BinlogMessageTime)
+}
+
+// 获取消息中的所有数据镜像，update包含旧数据以及新数据
+func getMessageRows(message Message) []MessageRow {
+	switch m := message.(type) {
+	case InsertMessage:
+		return []MessageRow{m.Data.Row}
+	case DeleteMessage:
+		return []MessageRow{m.Data.Row}
+	case UpdateMessage:
+		return []MessageRow{m.OldData.Row, m.NewData.Row}
+	default:
+		return nil
+	}
+}
+
+func columnValueEqual(actual, expected interface{}) bool {
+	if expected == nil || actual == nil {
+		return expected == nil && actual == nil
+	}
+
+	return formatColumnValue(actual) == formatColumnValue(expected)
+}
+
+func formatColumnValue(value interface{}) string {
+	switch v := value.(type) {
+	case []byte:
+		return string(v)
+	case json.RawMessage:
+		return string(v)
+	}
+
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,129 @@
+package mysql
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+var (
+	schemaUseRe         = regexp.MustCompile("(?i)^USE\\s+`?([^`;\\s]+)`?")
+	schemaCreateTableRe = regexp.MustCompile("(?i)^CREATE\\s+TABLE\\s+(?:IF\\s+NOT\\s+EXISTS\\s+)?(?:`([^`]+)`\\.)?`([^`]+)`")
+	schemaColumnRe      = regexp.MustCompile("^`((?:[^`]|``)+)`\\s+(.+)$")
+	schemaPrimaryKeyRe  = regexp.MustCompile("(?i)^PRIMARY\\s+KEY\\s*\\(([^)]*)\\)")
+)
+
+// LoadSchemaDump 从mysqldump --no-data的输出中读取表结构，没有数据库连接时用于映射列名以及类型
+// 没有USE语句以及库名前缀的表归属于defaultSchema
+func (m *TableMap) LoadSchemaDump(r io.Reader, defaultSchema string) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	schema := defaultSchema
+	var cacheKey string
+	var columns *tableColumns
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if columns == nil {
+			if match := schemaUseRe.FindStringSubmatch(line); match != nil {
+				schema = match[1]
+			} else if match := schemaCreateTableRe.FindStringSubmatch(line); match != nil {
+				tableSchema := schema
+				if match[1] != "" {
+					tableSchema = match[1]
+				}
+
+				cacheKey = fmt.Sprintf("%s_%s", tableSchema, match[2])
+				columns = &tableColumns{fields: make(map[int]string), types: make(map[int]ColumnType)}
+			}
+
+			continue
+		}
+
+		if strings.HasPrefix(line, ")") {
+			m.fieldsCache[cacheKey] = *columns
+			columns = nil
+			continue
+		}
+
+		line = strings.TrimSuffix(line, ",")
+
+		if match := schemaColumnRe.FindStringSubmatch(line); match != nil {
+			index := len(columns.fields)
+			columns.fields[index] = strings.ReplaceAll(match[1], "``", "`")
+			columns.types[index] = parseColumnDefinition(match[2])
+		} else if match := schemaPrimaryKeyRe.FindStringSubmatch(line); match != nil {
+			columns.primaryKey = parseKeyColumns(match[1])
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if columns != nil {
+		return fmt.Errorf("unterminated create table statement for %s", cacheKey)
+	}
+
+	return nil
+}
+
+// int(10) unsigned NOT NULL => ColumnType{int, unsigned}
+// enum('a','b') DEFAULT 'a' => ColumnType{enum, [a b]}
+func parseColumnDefinition(definition string) ColumnType {
+	depth := 0
+	quoted := false
+	end := len(definition)
+
+	for i := 0; i < len(definition); i++ {
+		c := definition[i]
+
+		if c == '\'' {
+			quoted = !quoted
+		}
+
+		if quoted {
+			continue
+		}
+
+		if c == '(' {
+			depth++
+		} else if c == ')' {
+			depth--
+		} else if c == ' ' && depth == 0 {
+			end = i
+			break
+		}
+	}
+
+	columnType := definition[:end]
+	if rest := strings.ToLower(definition[end:]); strings.HasPrefix(strings.TrimSpace(rest), "unsigned") {
+		columnType += " unsigned"
+	}
+
+	dataType := columnType
+	if i := strings.IndexAny(dataType, "( "); i >= 0 {
+		dataType = dataType[:i]
+	}
+
+	return NewColumnType(dataType, columnType)
+}
+
+// `a`,`b`(10) => [a b]
+func parseKeyColumns(definition string) []string {
+	var ret []string
+
+	for _, part := range strings.Split(definition, ",") {
+		part = strings.TrimSpace(part)
+		if i := strings.Index(part, "("); i >= 0 {
+			part = part[:i]
+		}
+		ret = append(ret, strings.Trim(part, "`"))
+	}
+
+	return ret
+}
@@ -0,0 +1,57 @@
+package mysql
+
+import (
+	"strings"
+	"testing"
+)
+
+var testSchemaDump = "-- MySQL dump 10.13\n" +
+	"USE `app`;\n" +
+	"DROP TABLE IF EXISTS `users`;\n" +
+	"CREATE TABLE `users` (\n" +
+	"  `id` int(10) unsigned NOT NULL AUTO_INCREMENT,\n" +
+	"  `level` enum('low','it''s high') DEFAULT 'low',\n" +
+	"  `price` decimal(10,2) NOT NULL,\n" +
+	"  `avatar` blob,\n" +
+	"  PRIMARY KEY (`id`),\n" +
+	"  KEY `idx_level` (`level`)\n" +
+	") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;\n" +
+	"CREATE TABLE `other`.`logs` (\n" +
+	"  `msg` text\n" +
+	");\n"
+
+func TestLoadSchemaDump(t *testing.T) {
+	tableMap := NewTableMap(nil)
+	if err := tableMap.LoadSchemaDump(strings.NewReader(testSchemaDump), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tableMap.Add(1, "app", "users"); err != nil {
+		t.Fatal(err)
+	}
+	users, _ := tableMap.LookupTableMetadata(1)
+
+	if len(users.Fields) != 4 || users.Fields[0] != "id" || users.Fields[3] != "avatar" {
+		t.Fatalf("Wrong fields %v", users.Fields)
+	}
+	if len(users.PrimaryKey) != 1 || users.PrimaryKey[0] != "id" {
+		t.Fatalf("Wrong primary key %v", users.PrimaryKey)
+	}
+	if !users.Types[0].Unsigned || users.Types[0].DataType != "int" {
+		t.Fatalf("Wrong id type %+v", users.Types[0])
+	}
+	if users.Types[1].DataType != "enum" || len(users.Types[1].Values) != 2 || users.Types[1].Values[1] != "it's high" {
+		t.Fatalf("Wrong level type %+v", users.Types[1])
+	}
+	if users.Types[2].DataType != "decimal" {
+		t.Fatalf("Wrong price type %+v", users.Types[2])
+	}
+
+	if err := tableMap.Add(2, "other", "logs"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tableMap.Add(3, "app", "missing"); err == nil {
+		t.Fatal("Expected error for table missing in schema dump")
+	}
+}
@@ -0,0 +1,204 @@
+package mysql
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var sqlStringEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`'`, `\'`,
+	"\x00", `\0`,
+	"\n", `\n`,
+	"\r", `\r`,
+	"\x1a", `\Z`,
+)
+
+type UnsupportedRowError struct {
+	Header MessageHeader
+	Reason string
+}
+
+func (e *UnsupportedRowError) Error() string {
+	return fmt.Sprintf("can't render sql for %s.%s at position %d: %s", e.Header.Schema, e.Header.Table, e.Header.BinlogPosition, e.Reason)
+}
+
+// RenderSql 生成消息对应的正向sql
+func RenderSql(message Message) (string, error) {
+	switch m := message.(type) {
+	case InsertMessage:
+		return renderInsert(m.Header, m.Data)
+	case UpdateMessage:
+		return renderUpdate(m.Header, m.NewData, m.OldData)
+	case DeleteMessage:
+		return renderDelete(m.Header, m.Data)
+	case QueryMessage:
+		return strings.TrimRight(strings.TrimSpace(string(m.Query)), ";") + ";", nil
+	default:
+		return "", &UnsupportedRowError{Header: message.GetHeader(), Reason: fmt.Sprintf("unknown message type %s", message.GetType())}
+	}
+}
+
+// RenderUndoSql 生成能够撤销该消息的sql
+// insert => delete, delete => insert, update => 将新数据更新回OldData
+func RenderUndoSql(message Message) (string, error) {
+	switch m := message.(type) {
+	case InsertMessage:
+		return renderDelete(m.Header, m.Data)
+	case UpdateMessage:
+		return renderUpdate(m.Header, m.OldData, m.NewData)
+	case DeleteMessage:
+		return renderInsert(m.Header, m.Data)
+	default:
+		return "", &UnsupportedRowError{Header: message.GetHeader(), Reason: fmt.Sprintf("%s can't be undone", message.GetType())}
+	}
+}
+
+func renderInsert(header MessageHeader, data MessageRowData) (string, error) {
+	if err := checkRowData(header, data); err != nil {
+		return "", err
+	}
+
+	columns := sortedColumns(data.Row)
+	names := make([]string, 0, len(columns))
+	values := make([]string, 0, len(columns))
+
+	for _, column := range columns {
+		names = append(names, quoteIdentifier(column))
+		values = append(values, quoteValue(data.Row[column]))
+	}
+
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s);", quoteTable(header), strings.Join(names, ", "), strings.Join(values, ", ")), nil
+}
+
+// 将where对应的行更新为set中的数据
+func renderUpdate(header MessageHeader, set, where MessageRowData) (string, error) {
+	if err := checkRowData(header, set); err != nil {
+		return "", err
+	}
+
+	condition, err := renderWhere(header, where)
+
+	if err != nil {
+		return "", err
+	}
+
+	columns := sortedColumns(set.Row)
+	assignments := make([]string, 0, len(columns))
+
+	for _, column := range columns {
+		assignments = append(assignments, fmt.Sprintf("%s=%s", quoteIdentifier(column), quoteValue(set.Row[column])))
+	}
+
+	return fmt.Sprintf("UPDATE %s SET %s WHERE %s LIMIT 1;", quoteTable(header), strings.Join(assignments, ", "), condition), nil
+}
+
+func renderDelete(header MessageHeader, data MessageRowData) (string, error) {
+	condition, err := renderWhere(header, data)
+
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("DELETE FROM %s WHERE %s LIMIT 1;", quoteTable(header), condition), nil
+}
+
+// 有主键时按照主键定位，否则使用全部列
+func renderWhere(header MessageHeader, data MessageRowData) (string, error) {
+	if err := checkRowData(header, data); err != nil {
+		return "", err
+	}
+
+	columns := header.PrimaryKey
+	if len(columns) == 0 {
+		columns = sortedColumns(data.Row)
+	}
+
+	conditions := make([]string, 0, len(columns))
+
+	for _, column := range columns {
+		value, ok := data.Row[column]
+
+		if !ok {
+			return "", &UnsupportedRowError{Header: header, Reason: fmt.Sprintf("primary key column %s is missing", column)}
+		}
+
+		if value == nil {
+			conditions = append(conditions, fmt.Sprintf("%s IS NULL", quoteIdentifier(column)))
+		} else {
+			conditions = append(conditions, fmt.Sprintf("%s=%s", quoteIdentifier(column), quoteValue(value)))
+		}
+	}
+
+	return strings.Join(conditions, " AND "), nil
+}
+
+func checkRowData(header MessageHeader, data MessageRowData) error {
+	if data.MappingNotice != "" {
+		return &UnsupportedRowError{Header: header, Reason: data.MappingNotice}
+	}
+
+	if len(data.Row) == 0 {
+		return &UnsupportedRowError{Header: header, Reason: "row is empty"}
+	}
+
+	return nil
+}
+
+func sortedColumns(row MessageRow) []string {
+	columns := make([]string, 0, len(row))
+	for column := range row {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+
+	return columns
+}
+
+func quoteTable(header MessageHeader) string {
+	if header.Schema == "" {
+		return quoteIdentifier(header.Table)
+	}
+
+	return quoteIdentifier(header.Schema) + "." + quoteIdentifier(header.Table)
+}
+
+func quoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+func quoteValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprint(v)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case []byte:
+		if len(v) == 0 {
+			return "''"
+		}
+		return "X'" + hex.EncodeToString(v) + "'"
+	case json.RawMessage:
+		return "'" + sqlStringEscaper.Replace(string(v)) + "'"
+	case time.Time:
+		return "'" + v.Format("2006-01-02 15:04:05.999999") + "'"
+	case string:
+		return "'" + sqlStringEscaper.Replace(v) + "'"
+	default:
+		return "'" + sqlStringEscaper.Replace(fmt.Sprint(v)) + "'"
+	}
+}
@@ -0,0 +1,56 @@
+package mysql
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+type TransactionConsumerFunc func(Transaction) error
+
+// Transaction 一个事务中的所有消息，按照binlog中的顺序排列
+type Transaction struct {
+	XId            uint64
+	BeginTime      string
+	CommitTime     string
+	CommitPosition uint32
+	Tables         []string            // schema.table，按照首次出现的顺序
+	RowCounts      map[MessageType]int // 每种操作影响的行数
+	Messages       []Message
+}
+
+func NewTransaction(xId uint64, beginTime time.Time, commit replication.EventHeader, messages []Message) Transaction {
+	transaction := Transaction{
+		XId:            xId,
+		BeginTime:      beginTime.UTC().Format(time.RFC3339),
+		CommitTime:     time.Unix(int64(commit.Timestamp), 0).UTC().Format(time.RFC3339),
+		CommitPosition: commit.LogPos,
+	}
+	transaction.setMessages(messages)
+
+	return transaction
+}
+
+// 重新计算涉及的表以及行数
+func (t *Transaction) setMessages(messages []Message) {
+	t.Messages = messages
+	t.Tables = nil
+	t.RowCounts = make(map[MessageType]int)
+
+	seen := make(map[string]bool)
+	for _, message := range messages {
+		if message.GetType() == MESSAGE_TYPE_QUERY {
+			continue
+		}
+
+		header := message.GetHeader()
+		table := fmt.Sprintf("%s.%s", header.Schema, header.Table)
+		if !seen[table] {
+			seen[table] = true
+			t.Tables = append(t.Tables, table)
+		}
+
+		t.RowCounts[message.GetType()]++
+	}
+}
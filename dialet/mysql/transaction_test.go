@@ -0,0 +1,97 @@
+package mysql
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+func TestParseTransactions(t *testing.T) {
+	begin := time.Date(2022, 6, 1, 14, 2, 0, 0, time.UTC)
+	event := func(eventType replication.EventType, at time.Time, logPos uint32, e replication.Event) *replication.BinlogEvent {
+		return &replication.BinlogEvent{
+			Header: &replication.EventHeader{EventType: eventType, Timestamp: uint32(at.Unix()), LogPos: logPos, EventSize: 10},
+			Event:  e,
+		}
+	}
+
+	events := []*replication.BinlogEvent{
+		event(replication.QUERY_EVENT, begin, 100, &replication.QueryEvent{Schema: []byte("app"), Query: []byte("create table t (id int)")}),
+		event(replication.QUERY_EVENT, begin, 110, &replication.QueryEvent{Schema: []byte("app"), Query: []byte("BEGIN")}),
+		event(replication.TABLE_MAP_EVENT, begin, 120, &replication.TableMapEvent{TableID: 1, Schema: []byte("app"), Table: []byte("users")}),
+		event(replication.WRITE_ROWS_EVENTv2, begin, 130, &replication.RowsEvent{TableID: 1, Rows: [][]interface{}{{int64(1), "a"}, {int64(2), "b"}}}),
+		event(replication.DELETE_ROWS_EVENTv2, begin, 140, &replication.RowsEvent{TableID: 1, Rows: [][]interface{}{{int64(3), "c"}}}),
+		event(replication.XID_EVENT, begin.Add(time.Second), 150, &replication.XIDEvent{XID: 7}),
+	}
+
+	var out bytes.Buffer
+	chain := NewConsumerChain()
+	chain.CollectTransactionsAsJson(&out, false)
+
+	h := newBinlogEventHandler(newTestTableMap(), nil, ParseOptions{})
+	h.transactionConsumer = chain.ConsumeTransaction
+
+	for _, e := range events {
+		if err := h.handle(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if h.stats.Transactions != 2 || h.stats.Messages != 4 {
+		t.Fatalf("Wrong parse stats %+v", h.stats)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 transactions, got %s", out.String())
+	}
+
+	var transaction struct {
+		XId        uint64
+		BeginTime  string
+		CommitTime string
+		Tables     []string
+		RowCounts  map[string]int
+		Messages   []json.RawMessage
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &transaction); err != nil {
+		t.Fatal(err)
+	}
+
+	if transaction.XId != 7 || transaction.BeginTime != "2022-06-01T14:02:00Z" || transaction.CommitTime != "2022-06-01T14:02:01Z" {
+		t.Fatalf("Wrong transaction %s", lines[1])
+	}
+	if len(transaction.Tables) != 1 || transaction.Tables[0] != "app.users" {
+		t.Fatalf("Wrong transaction tables %v", transaction.Tables)
+	}
+	if transaction.RowCounts["Insert"] != 2 || transaction.RowCounts["Delete"] != 1 || len(transaction.Messages) != 3 {
+		t.Fatalf("Wrong transaction row counts %s", lines[1])
+	}
+}
+
+func TestConsumeTransactionFiltersMessages(t *testing.T) {
+	var collected []Transaction
+
+	chain := NewConsumerChain()
+	chain.IncludeTypes(MESSAGE_TYPE_DELETE)
+	chain.transactionCollectors = append(chain.transactionCollectors, func(transaction Transaction) error {
+		collected = append(collected, transaction)
+		return nil
+	})
+
+	messages := newTestMessages()
+	if err := chain.ConsumeTransaction(NewTransaction(1, time.Now(), replication.EventHeader{}, messages)); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.ConsumeTransaction(NewTransaction(2, time.Now(), replication.EventHeader{}, messages[:1])); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(collected) != 1 || len(collected[0].Messages) != 1 || collected[0].RowCounts[MESSAGE_TYPE_DELETE] != 1 {
+		t.Fatalf("Wrong transactions collected %+v", collected)
+	}
+}
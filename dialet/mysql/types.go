@@ -0,0 +1,180 @@
+package mysql
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ColumnType 列的类型信息，来自INFORMATION_SCHEMA.COLUMNS
+type ColumnType struct {
+	DataType string   // DATA_TYPE，例如int、decimal、enum
+	Unsigned bool     // COLUMN_TYPE中包含unsigned
+	Values   []string // enum、set的可选值
+}
+
+// NewColumnType 根据DATA_TYPE以及COLUMN_TYPE构建，例如("enum", "enum('a','b')")、("int", "int(10) unsigned")
+func NewColumnType(dataType, columnType string) ColumnType {
+	t := ColumnType{
+		DataType: strings.ToLower(dataType),
+		Unsigned: strings.Contains(strings.ToLower(columnType), "unsigned"),
+	}
+
+	if t.DataType == "enum" || t.DataType == "set" {
+		t.Values = parseEnumValues(columnType)
+	}
+
+	return t
+}
+
+// 解析enum('a','b')中的可选值，值中的单引号写作两个单引号
+func parseEnumValues(columnType string) []string {
+	start := strings.Index(columnType, "(")
+	end := strings.LastIndex(columnType, ")")
+
+	if start < 0 || end <= start {
+		return nil
+	}
+
+	var values []string
+	var current strings.Builder
+	quoted := false
+	body := columnType[start+1 : end]
+
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+
+		switch {
+		case c == '\'' && quoted && i+1 < len(body) && body[i+1] == '\'':
+			current.WriteByte('\'')
+			i++
+		case c == '\'':
+			quoted = !quoted
+			if !quoted {
+				values = append(values, current.String())
+				current.Reset()
+			}
+		case quoted:
+			current.WriteByte(c)
+		}
+	}
+
+	return values
+}
+
+func (t ColumnType) isText() bool {
+	switch t.DataType {
+	case "char", "varchar", "tinytext", "text", "mediumtext", "longtext":
+		return true
+	}
+
+	return false
+}
+
+func (t ColumnType) isBinary() bool {
+	switch t.DataType {
+	case "binary", "varbinary", "tinyblob", "blob", "mediumblob", "longblob",
+		"geometry", "point", "linestring", "polygon", "multipoint", "multilinestring", "multipolygon", "geometrycollection":
+		return true
+	}
+
+	return false
+}
+
+// decodeColumnValue 将go-mysql解析出的原始值转换为应用程序看到的值
+// decimal => string, json => json.RawMessage, enum/set => 名称, 无符号整数 => uint, 二进制 => []byte(序列化为base64), 文本 => string
+func decodeColumnValue(value interface{}, t ColumnType) interface{} {
+	if value == nil {
+		return nil
+	}
+
+	switch {
+	case t.DataType == "decimal":
+		if s, ok := value.(fmt.Stringer); ok {
+			return s.String()
+		}
+		return fmt.Sprint(value)
+
+	case t.DataType == "json":
+		raw := toBytes(value)
+		if len(raw) == 0 {
+			return nil
+		}
+		if json.Valid(raw) {
+			return json.RawMessage(raw)
+		}
+		return string(raw)
+
+	case t.DataType == "enum":
+		if index, ok := value.(int64); ok {
+			if index == 0 {
+				return "" // 插入非法值时mysql保存为空字符串
+			}
+			if int(index) <= len(t.Values) {
+				return t.Values[index-1]
+			}
+		}
+		return value
+
+	case t.DataType == "set":
+		if bits, ok := value.(int64); ok && len(t.Values) > 0 {
+			names := []string{}
+			for i, name := range t.Values {
+				if bits&(1<<uint(i)) != 0 {
+					names = append(names, name)
+				}
+			}
+			return strings.Join(names, ",")
+		}
+		return value
+
+	case t.DataType == "bit":
+		if bits, ok := value.(int64); ok {
+			return uint64(bits)
+		}
+		return value
+
+	case t.Unsigned:
+		return toUnsigned(value, t.DataType)
+
+	case t.isBinary():
+		return toBytes(value)
+
+	case t.isText():
+		if b, ok := value.([]byte); ok {
+			return string(b)
+		}
+		return value
+	}
+
+	return value
+}
+
+func toUnsigned(value interface{}, dataType string) interface{} {
+	switch v := value.(type) {
+	case int8:
+		return uint8(v)
+	case int16:
+		return uint16(v)
+	case int32:
+		if dataType == "mediumint" {
+			return uint32(v) & 0xFFFFFF
+		}
+		return uint32(v)
+	case int64:
+		return uint64(v)
+	default:
+		return value // float、double、decimal的unsigned不影响取值
+	}
+}
+
+func toBytes(value interface{}) []byte {
+	switch v := value.(type) {
+	case []byte:
+		return append([]byte{}, v...)
+	case string:
+		return []byte(v)
+	default:
+		return []byte(fmt.Sprint(v))
+	}
+}
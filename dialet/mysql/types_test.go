@@ -0,0 +1,72 @@
+package mysql
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+type testDecimal string
+
+func (d testDecimal) String() string {
+	return string(d)
+}
+
+func TestNewColumnType(t *testing.T) {
+	enum := NewColumnType("enum", "enum('small','it''s big')")
+	if len(enum.Values) != 2 || enum.Values[0] != "small" || enum.Values[1] != "it's big" {
+		t.Fatalf("Wrong enum values %v", enum.Values)
+	}
+
+	if !NewColumnType("int", "int(10) unsigned").Unsigned {
+		t.Fatal("Column should be unsigned")
+	}
+
+	if NewColumnType("INT", "int(11)").DataType != "int" {
+		t.Fatal("Data type should be lower case")
+	}
+}
+
+func TestDecodeColumnValue(t *testing.T) {
+	setType := NewColumnType("set", "set('a','b','c')")
+
+	rows, err := mapRowDataDataToColumnNames(
+		[][]interface{}{{
+			testDecimal("12345678901234567890.12"),
+			`{"a": [1, 2]}`,
+			int64(2),
+			int64(5),
+			int64(-1),
+			int8(-1),
+			int32(-1),
+			"\x00\x01",
+			[]byte("hello"),
+			nil,
+		}},
+		map[int]string{0: "price", 1: "doc", 2: "size", 3: "tags", 4: "flags", 5: "tiny", 6: "medium", 7: "bin", 8: "body", 9: "nothing"},
+		map[int]ColumnType{
+			0: NewColumnType("decimal", "decimal(22,2)"),
+			1: NewColumnType("json", "json"),
+			2: NewColumnType("enum", "enum('small','big')"),
+			3: setType,
+			4: NewColumnType("bit", "bit(64)"),
+			5: NewColumnType("tinyint", "tinyint(3) unsigned"),
+			6: NewColumnType("mediumint", "mediumint(8) unsigned"),
+			7: NewColumnType("binary", "binary(2)"),
+			8: NewColumnType("text", "text"),
+			9: NewColumnType("int", "int(11)"),
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(rows[0].Row)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"bin":"AAE=","body":"hello","doc":{"a":[1,2]},"flags":18446744073709551615,"medium":16777215,"nothing":null,"price":"12345678901234567890.12","size":"big","tags":"a,c","tiny":255}`
+	if string(data) != expected {
+		t.Fatalf("Wrong decoded row %s", data)
+	}
+}
@@ -2,6 +2,8 @@ package postgres
 
 import (
 	"encoding/json"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -12,6 +14,9 @@ type PostgresLog struct {
 	Id      string                 `json:"id"`
 	Payload map[string]interface{} `json:"payload"`
 	Changes map[string]interface{} `json:"changes"`
+	Time    time.Time              `json:"time"`
+	TxId    uint64                 `json:"txid"`
+	Lsn     uint64                 `json:"lsn"`
 }
 
 // log unmarshal to struct
@@ -21,6 +26,10 @@ func NewPostgresLog(log string) (*PostgresLog, error) {
 	if err := json.Unmarshal([]byte(log), &l); err != nil {
 		return nil, err
 	}
+	// 通知中没有时间，使用收到的时间
+	if l.Time.IsZero() {
+		l.Time = time.Now()
+	}
 	return l, nil
 }
 
@@ -37,14 +46,14 @@ func (l *PostgresLog) GetType() string {
 	return ""
 }
 
-// 具体标签 insert update delete | alter column, table
+// 具体标签 insert update delete truncate
 func (l *PostgresLog) GetLabel() string {
-	return "insert"
+	return strings.ToLower(Operation(l.Op).String())
 }
 
 // 获取日志记录时间
 func (l *PostgresLog) GetTime() time.Time {
-	return time.Now()
+	return l.Time
 }
 
 // 获取具体的负载对象
@@ -55,3 +64,21 @@ func (l *PostgresLog) GetPaylod() map[string]interface{} {
 func (l *PostgresLog) GetChange() map[string]interface{} {
 	return l.Changes
 }
+
+// 记录的主键
+func (l *PostgresLog) GetId() string {
+	return l.Id
+}
+
+// 触发器中的txid_current()，旧版本的触发器没有时为空
+func (l *PostgresLog) GetTxId() string {
+	if l.TxId == 0 {
+		return ""
+	}
+	return strconv.FormatUint(l.TxId, 10)
+}
+
+// 触发器执行时的WAL位置(pg_current_wal_insert_lsn)，旧版本的触发器没有时为0
+func (l *PostgresLog) GetPosition() uint64 {
+	return l.Lsn
+}
@@ -12,4 +12,17 @@ func TestUnmarshal(t *testing.T) {
 	} else {
 		fmt.Println(log.Payload)
 	}
+	if log.GetLabel() != "insert" || log.GetTime().IsZero() {
+		t.Error("label or time not match")
+	}
+
+	log, _ = NewPostgresLog(`{"schema":"public","table":"notes","op":3,"id":"14","payload":{"id":14}}`)
+	if log.GetLabel() != "delete" || log.GetTxId() != "" {
+		t.Error("label not match")
+	}
+
+	log, _ = NewPostgresLog(`{"schema":"public","table":"notes","op":2,"id":"14","payload":{"id":14},"txid":733}`)
+	if log.GetTxId() != "733" || log.GetPosition() != 0 {
+		t.Error("txid not match")
+	}
 }
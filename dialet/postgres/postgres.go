@@ -43,7 +43,9 @@ CREATE OR REPLACE FUNCTION pqstream_notify() RETURNS TRIGGER AS $$
                           'op', TG_OP,
 						  'id', json_extract_path(payload, 'id')::text,
                           'payload', payload,
-						  'previous', previous);
+						  'previous', previous,
+						  'txid', txid_current(),
+						  'lsn', pg_current_wal_insert_lsn() - '0/0');
         PERFORM pg_notify('pqstream_notify', notification::text);
         RETURN NULL; 
     END;
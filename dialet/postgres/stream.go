@@ -264,15 +264,28 @@ func (s *Stream) fallbackLookup(e *Event) error {
 	return nil
 }
 
+// notification 发送给消费者的事件，附带触发器中的事务id以及WAL位置
+type notification struct {
+	*Event
+	TxId uint64 `json:"txid,omitempty"`
+	Lsn  uint64 `json:"lsn,omitempty"`
+}
+
 func (s *Stream) handleEvent(ev *pq.Notification, q chan string) error {
 	if ev == nil {
 		return errors.New("got nil event")
 	}
 
 	re := &RawEvent{}
-	if err := jsonpb.UnmarshalString(ev.Extra, re); err != nil {
+	if err := (&jsonpb.Unmarshaler{AllowUnknownFields: true}).Unmarshal(strings.NewReader(ev.Extra), re); err != nil {
 		return errors.Wrap(err, "jsonpb unmarshal")
 	}
+	// txid、lsn不在proto的定义中，单独解析
+	var tx struct {
+		TxId uint64 `json:"txid"`
+		Lsn  uint64 `json:"lsn"`
+	}
+	json.Unmarshal([]byte(ev.Extra), &tx)
 
 	// perform field redactions
 	s.redactFields(re)
@@ -303,7 +316,7 @@ func (s *Stream) handleEvent(ev *pq.Notification, q chan string) error {
 		return nil
 	}
 
-	data, err := json.Marshal(e)
+	data, err := json.Marshal(notification{Event: e, TxId: tx.TxId, Lsn: tx.Lsn})
 	if err == nil {
 		q <- string(data)
 	}
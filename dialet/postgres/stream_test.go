@@ -13,6 +13,7 @@ import (
 	"github.com/golang/protobuf/jsonpb"
 	ptypes_struct "github.com/golang/protobuf/ptypes/struct"
 	"github.com/google/go-cmp/cmp"
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
 )
 
@@ -197,6 +198,25 @@ func TestServer_redactFields(t *testing.T) {
 	}
 }
 
+func TestHandleEventTxId(t *testing.T) {
+	q := make(chan string, 1)
+	s := &Stream{}
+	err := s.handleEvent(&pq.Notification{
+		Extra: `{"schema":"public","table":"notes","op":"INSERT","id":"14","payload":{"id":14},"previous":null,"txid":1024,"lsn":23456789}`,
+	}, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log, err := NewPostgresLog(<-q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if log.GetTxId() != "1024" || log.GetPosition() != 23456789 || log.GetLabel() != "insert" || log.GetId() != "14" {
+		t.Errorf("unexpected log %+v", log)
+	}
+}
+
 func TestDecodeRedactions(t *testing.T) {
 	type args struct {
 		r string
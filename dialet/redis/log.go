@@ -0,0 +1,87 @@
+package redis
+
+import (
+	"fmt"
+	"time"
+)
+
+// RedisLog 一次键空间通知，key作为表名，命令作为标签
+// 开启值捕获时Value为事件之后key的值，Changes为回到上一次值的变更
+type RedisLog struct {
+	Db        int                    `json:"db"`
+	Key       string                 `json:"key"`
+	Event     string                 `json:"event"`
+	Time      time.Time              `json:"time"`
+	Captured  bool                   `json:"captured"`
+	ValueType string                 `json:"value_type,omitempty"`
+	Value     interface{}            `json:"value,omitempty"`
+	Changes   map[string]interface{} `json:"changes,omitempty"`
+
+	HistoryTTL time.Duration `json:"history_ttl,omitempty"` // 来自策略，存储端据此清理历史
+}
+
+func NewRedisLog(db int, key, event string) *RedisLog {
+	return &RedisLog{
+		Db:    db,
+		Key:   key,
+		Event: event,
+		Time:  time.Now(),
+	}
+}
+
+func (l *RedisLog) GetSchema() string {
+	return fmt.Sprintf("db%d", l.Db)
+}
+
+func (l *RedisLog) GetTable() string {
+	return l.Key
+}
+
+// 获取日志记录类型 ddl dml
+func (l *RedisLog) GetType() string {
+	return "dml"
+}
+
+// 具体标签 set del expired hset lpush ...
+func (l *RedisLog) GetLabel() string {
+	return l.Event
+}
+
+// 获取日志记录时间
+func (l *RedisLog) GetTime() time.Time {
+	return l.Time
+}
+
+// 获取具体的负载对象
+func (l *RedisLog) GetPaylod() map[string]interface{} {
+	payload := map[string]interface{}{
+		"db":    l.Db,
+		"key":   l.Key,
+		"event": l.Event,
+	}
+	if l.Captured {
+		payload["type"] = l.ValueType
+		payload["value"] = l.Value
+	}
+	return payload
+}
+
+func (l *RedisLog) GetChange() map[string]interface{} {
+	if l.Changes == nil {
+		return map[string]interface{}{}
+	}
+	return l.Changes
+}
+
+// key作为记录的主键
+func (l *RedisLog) GetId() string {
+	return l.Key
+}
+
+// 历史记录在策略的HistoryTTL之后过期
+func (l *RedisLog) GetExpireAt() time.Time {
+	if l.HistoryTTL <= 0 {
+		return time.Time{}
+	}
+	return l.Time.Add(l.HistoryTTL)
+}
@@ -0,0 +1,217 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+)
+
+var (
+	ErrPolicyNotFound = errors.New("policy not found")
+	ErrPolicyPattern  = errors.New("policy pattern can't be empty")
+
+	// PolicyHashKey 以hash存储策略 id => json
+	PolicyHashKey = "watch:policy:hash"
+	// PolicyChannel 策略变更时发布策略id，正在运行的Watch会重新加载策略
+	PolicyChannel = "watch:policy:channel"
+)
+
+// Policy 监听的key模式以及事件类型
+// 字符串格式为"<key pattern> [event,event...] [capture]"，例如"user:* set,del"，不指定事件时监听全部
+// capture表示在事件之后读取key的值并计算变更
+type Policy struct {
+	Id         string        `json:"id"`
+	Pattern    string        `json:"pattern"`
+	Events     []string      `json:"events,omitempty"`
+	Capture    bool          `json:"capture"`
+	HistoryTTL time.Duration `json:"history_ttl,omitempty"` // 历史记录保留时间，0表示不限制
+}
+
+func ParsePolicy(policy string) Policy {
+	fields := strings.Fields(policy)
+	if len(fields) == 0 {
+		return Policy{Id: "*", Pattern: "*"}
+	}
+
+	p := Policy{Id: fields[0], Pattern: fields[0]}
+	for _, field := range fields[1:] {
+		if field == "capture" {
+			p.Capture = true
+			continue
+		}
+		for _, event := range strings.Split(field, ",") {
+			if event = strings.TrimSpace(event); event != "" {
+				p.Events = append(p.Events, event)
+			}
+		}
+	}
+	return p
+}
+
+// Validate 未指定id时使用key模式作为id，相同模式的策略不会重复存储
+func (p *Policy) Validate() error {
+	p.Pattern = strings.TrimSpace(p.Pattern)
+	if p.Pattern == "" {
+		return ErrPolicyPattern
+	}
+	if p.Id == "" {
+		p.Id = p.Pattern
+	}
+	if p.HistoryTTL < 0 {
+		p.HistoryTTL = 0
+	}
+	return nil
+}
+
+func (p Policy) Match(event string) bool {
+	if len(p.Events) == 0 {
+		return true
+	}
+
+	for _, e := range p.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// SavePolicy 新增或者覆盖策略，并通知正在运行的Watch
+func (d *RedisDialet) SavePolicy(policy Policy) (Policy, error) {
+	if err := policy.Validate(); err != nil {
+		return policy, err
+	}
+
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return policy, err
+	}
+
+	ctx := context.TODO()
+	if err := d.client.HSet(ctx, PolicyHashKey, policy.Id, data).Err(); err != nil {
+		return policy, err
+	}
+	d.setPolicy(policy)
+	return policy, d.notifyPolicy(ctx, policy.Id)
+}
+
+// GetPolicy 根据id获取策略
+func (d *RedisDialet) GetPolicy(id string) (Policy, error) {
+	var policy Policy
+
+	data, err := d.client.HGet(context.TODO(), PolicyHashKey, id).Result()
+	if err == goredis.Nil {
+		return policy, ErrPolicyNotFound
+	} else if err != nil {
+		return policy, err
+	}
+
+	err = json.Unmarshal([]byte(data), &policy)
+	return policy, err
+}
+
+// RemovePolicy 根据id删除策略
+func (d *RedisDialet) RemovePolicy(id string) error {
+	ctx := context.TODO()
+	n, err := d.client.HDel(ctx, PolicyHashKey, id).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrPolicyNotFound
+	}
+	d.removePolicy(id)
+	return d.notifyPolicy(ctx, id)
+}
+
+// setPolicy 同步修改本地加载的策略，保持按照id排序
+func (d *RedisDialet) setPolicy(policy Policy) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	index := sort.Search(len(d.policy), func(i int) bool { return d.policy[i].Id >= policy.Id })
+	if index < len(d.policy) && d.policy[index].Id == policy.Id {
+		d.policy[index] = policy
+		return
+	}
+	d.policy = append(d.policy, Policy{})
+	copy(d.policy[index+1:], d.policy[index:])
+	d.policy[index] = policy
+}
+
+func (d *RedisDialet) removePolicy(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	res := d.policy[:0:0]
+	for _, p := range d.policy {
+		if p.Id != id {
+			res = append(res, p)
+		}
+	}
+	d.policy = res
+}
+
+// Policies 返回当前加载的策略
+func (d *RedisDialet) Policies() []Policy {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return append([]Policy(nil), d.policy...)
+}
+
+// loadPolicies 从hash中读取全部策略，按照id排序
+func (d *RedisDialet) loadPolicies(ctx context.Context) ([]Policy, error) {
+	result, err := d.client.HGetAll(ctx, PolicyHashKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	policies := make([]Policy, 0, len(result))
+	for _, data := range result {
+		var policy Policy
+		if err := json.Unmarshal([]byte(data), &policy); err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+	sort.Slice(policies, func(i, j int) bool { return policies[i].Id < policies[j].Id })
+
+	d.mu.Lock()
+	d.policy = policies
+	d.mu.Unlock()
+	return policies, nil
+}
+
+// migrateLegacyPolicies 将list中的字符串策略迁移到hash中，迁移完成后删除list
+func (d *RedisDialet) migrateLegacyPolicies(ctx context.Context) error {
+	result, err := d.client.LRange(ctx, PolicyKey, 0, -1).Result()
+	if err != nil || len(result) == 0 {
+		return err
+	}
+
+	for _, item := range result {
+		policy := ParsePolicy(item)
+		if err := policy.Validate(); err != nil {
+			continue
+		}
+		data, err := json.Marshal(policy)
+		if err != nil {
+			return err
+		}
+		// 已经存在的策略以hash中的为准
+		if err := d.client.HSetNX(ctx, PolicyHashKey, policy.Id, data).Err(); err != nil {
+			return err
+		}
+	}
+	return d.client.Del(ctx, PolicyKey).Err()
+}
+
+func (d *RedisDialet) notifyPolicy(ctx context.Context, id string) error {
+	return d.client.Publish(ctx, PolicyChannel, id).Err()
+}
@@ -3,6 +3,7 @@ package redis
 import (
 	"context"
 	"errors"
+	"sync"
 	"time"
 
 	goredis "github.com/go-redis/redis/v8"
@@ -10,12 +11,14 @@ import (
 
 var (
 	ErrIdle   = errors.New("connection error")
-	PolicyKey = "watch:policy:key"
+	PolicyKey = "watch:policy:key" // 旧版本使用list存储字符串策略
 )
 
 type RedisDialet struct {
 	client *goredis.Client
-	policy []string
+	mu     sync.RWMutex
+	policy []Policy
+	values *valueCache
 }
 
 func NewRedisDialet(endpoint, password string) (*RedisDialet, error) {
@@ -26,6 +29,7 @@ func NewRedisDialet(endpoint, password string) (*RedisDialet, error) {
 			DB:       0,
 			// TLSConfig: &tls.Config{},
 		}),
+		values: newValueCache(DefaultValueCacheSize),
 	}
 
 	if err := dialet.Ping(); err != nil {
@@ -35,6 +39,12 @@ func NewRedisDialet(endpoint, password string) (*RedisDialet, error) {
 	}
 }
 
+// SetValueCacheSize 修改capture最多记录的key数量，小于等于0时不限制
+func (d *RedisDialet) SetValueCacheSize(size int) *RedisDialet {
+	d.values.setLimit(size)
+	return d
+}
+
 func (d *RedisDialet) Ping() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -47,39 +57,34 @@ func (d *RedisDialet) Ping() error {
 }
 
 // 获取配置列表
-// 存储在redis中，使用list存储
+// 存储在redis中，使用hash存储 id => json，旧版本list中的策略会被迁移
 func (d *RedisDialet) Initial() error {
-	result, err := d.client.LRange(context.TODO(), PolicyKey, 0, -1).Result()
-	if err != nil {
+	ctx := context.TODO()
+	if err := d.migrateLegacyPolicies(ctx); err != nil {
 		return err
 	}
-	d.policy = result
-	return nil
+	_, err := d.loadPolicies(ctx)
+	return err
 }
 
+// 通知正在运行的Watch重新加载全部策略，修改单个策略使用SavePolicy
 func (d *RedisDialet) ModifyPolicy() error {
-	return nil
+	return d.notifyPolicy(context.TODO(), "*")
 }
 
+// 兼容字符串格式的策略
 func (d *RedisDialet) AddPolicy(policy string) error {
-	_, err := d.client.LPush(context.TODO(), PolicyKey, policy).Result()
-	if err != nil {
-		return err
-	}
-	d.policy = append(d.policy, policy)
-	return nil
+	_, err := d.SavePolicy(ParsePolicy(policy))
+	return err
 }
 
 // 触发更新
 func (d *RedisDialet) ListPolicy() error {
-	result, err := d.client.LRange(context.TODO(), PolicyKey, 0, -1).Result()
-	if err != nil {
-		return err
-	}
-	d.policy = result
-	return nil
+	_, err := d.loadPolicies(context.TODO())
+	return err
 }
 
-func (d *RedisDialet) DeletePolicy() error { return nil }
-
-func (d *RedisDialet) Watch(ctx context.Context) chan interface{} { return nil }
+// 删除策略需要指定id，使用RemovePolicy
+func (d *RedisDialet) DeletePolicy() error {
+	return nil
+}
@@ -1,12 +1,15 @@
 package redis
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
+	"github.com/wwqdrh/datamanager/transport"
 )
 
 type RedisDialetSuite struct {
@@ -45,12 +48,37 @@ func (s *RedisDialetSuite) TestDialetAddPolicy() {
 
 	err := s.dialet.AddPolicy("policy1")
 	require.Nil(s.T(), err)
+
+	// 相同的key模式不会重复存储
+	err = s.dialet.AddPolicy("policy1 set")
+	require.Nil(s.T(), err)
+	policy, err := s.dialet.GetPolicy("policy1")
+	require.Nil(s.T(), err)
+	require.Equal(s.T(), []string{"set"}, policy.Events)
+	require.Nil(s.T(), s.dialet.RemovePolicy("policy1"))
 }
 
 func (s *RedisDialetSuite) TestDialetModifyPolicy() {
 	if s.mode != "local" {
 		s.T().Skip("no local env")
 	}
+
+	policy, err := s.dialet.SavePolicy(Policy{Id: "modify", Pattern: "modify:*"})
+	require.Nil(s.T(), err)
+
+	policy.Events = []string{"del"}
+	policy.Capture = true
+	policy.HistoryTTL = time.Hour
+	_, err = s.dialet.SavePolicy(policy)
+	require.Nil(s.T(), err)
+
+	saved, err := s.dialet.GetPolicy("modify")
+	require.Nil(s.T(), err)
+	require.Equal(s.T(), policy, saved)
+	require.Contains(s.T(), s.dialet.Policies(), policy)
+
+	require.Nil(s.T(), s.dialet.RemovePolicy("modify"))
+	require.Equal(s.T(), ErrPolicyNotFound, s.dialet.RemovePolicy("modify"))
 }
 
 func (s *RedisDialetSuite) TestDialetListPolicy() {
@@ -60,7 +88,7 @@ func (s *RedisDialetSuite) TestDialetListPolicy() {
 
 	err := s.dialet.ListPolicy()
 	require.Nil(s.T(), err)
-	fmt.Println(s.dialet.policy)
+	fmt.Println(s.dialet.Policies())
 }
 
 func (s *RedisDialetSuite) TestDialetWatch() {
@@ -70,5 +98,161 @@ func (s *RedisDialetSuite) TestDialetWatch() {
 	}
 
 	// 设置一个元素 然后监听 再次更新看监听的部分能否查看到
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s.dialet.policy = []Policy{ParsePolicy("watch:test:* set")}
+	ch := s.dialet.Watch(ctx)
+
+	require.Nil(s.T(), s.dialet.client.Del(ctx, "watch:test:key").Err())
+	require.Nil(s.T(), s.dialet.client.Set(ctx, "watch:test:key", "1", 0).Err())
+
+	item, ok := <-ch
+	require.True(s.T(), ok)
+	log := item.(*RedisLog)
+	require.Equal(s.T(), "watch:test:key", log.GetTable())
+	require.Equal(s.T(), "set", log.GetLabel())
+
+	// 新增策略后正在运行的Watch能够监听到新的key模式
+	_, err := s.dialet.SavePolicy(Policy{Id: "watch-live", Pattern: "watch:live:*"})
+	require.Nil(s.T(), err)
+	defer s.dialet.RemovePolicy("watch-live")
+	time.Sleep(100 * time.Millisecond)
+
+	require.Nil(s.T(), s.dialet.client.Set(ctx, "watch:live:key", "1", 0).Err())
+	for item := range ch {
+		if item.(*RedisLog).GetTable() == "watch:live:key" {
+			return
+		}
+	}
+	s.T().Fatal("policy change not picked up")
+}
+
+func TestParsePolicy(t *testing.T) {
+	p := ParsePolicy("user:* set,del")
+	require.Equal(t, "user:*", p.Pattern)
+	require.Equal(t, []string{"set", "del"}, p.Events)
+	require.True(t, p.Match("del"))
+	require.False(t, p.Match("hset"))
+
+	p = ParsePolicy("config:*")
+	require.Empty(t, p.Events)
+	require.True(t, p.Match("hset"))
+	require.False(t, p.Capture)
+
+	p = ParsePolicy("config:* hset capture")
+	require.Equal(t, []string{"hset"}, p.Events)
+	require.True(t, p.Capture)
+}
+
+func TestPolicySync(t *testing.T) {
+	d := &RedisDialet{}
+	d.setPolicy(Policy{Id: "b", Pattern: "b:*"})
+	d.setPolicy(Policy{Id: "a", Pattern: "a:*"})
+	d.setPolicy(Policy{Id: "b", Pattern: "b:*", Capture: true})
+	require.Equal(t, []Policy{{Id: "a", Pattern: "a:*"}, {Id: "b", Pattern: "b:*", Capture: true}}, d.Policies())
+
+	// DeletePolicy不会删除任何策略
+	require.Nil(t, d.DeletePolicy())
+	d.removePolicy("a")
+	require.Equal(t, []Policy{{Id: "b", Pattern: "b:*", Capture: true}}, d.Policies())
+}
+
+func TestPolicyValidate(t *testing.T) {
+	p := Policy{Pattern: " user:* "}
+	require.Nil(t, p.Validate())
+	require.Equal(t, "user:*", p.Id)
+
+	p = Policy{}
+	require.Equal(t, ErrPolicyPattern, p.Validate())
+}
+
+func TestDiffPatterns(t *testing.T) {
+	current := groupPolicies(0, []Policy{{Id: "a", Pattern: "a:*"}, {Id: "b", Pattern: "b:*"}})
+	next := groupPolicies(0, []Policy{{Id: "b", Pattern: "b:*"}, {Id: "c", Pattern: "c:*"}})
+
+	removed, added := diffPatterns(current, next)
+	require.Equal(t, []string{"__keyspace@0__:a:*"}, removed)
+	require.Equal(t, []string{"__keyspace@0__:c:*"}, added)
+
+	// 没有策略时监听全部key
+	require.Equal(t, []string{"__keyspace@0__:*"}, policyPatterns(groupPolicies(0, nil)))
+}
+
+func TestDiffValue(t *testing.T) {
+	changes := diffValue(
+		map[string]interface{}{"a": "1", "b": "2"},
+		map[string]interface{}{"a": "1", "b": "3", "c": "4"},
+	)
+	require.Equal(t, map[string]interface{}{"b": "2", "c": nil}, changes)
+
+	changes = diffValue("old", "new")
+	require.Equal(t, map[string]interface{}{"value": "old"}, changes)
+
+	changes = diffValue([]interface{}{"x"}, nil)
+	require.Equal(t, map[string]interface{}{"value": []interface{}{"x"}}, changes)
+
+	require.Empty(t, diffValue("same", "same"))
+}
+
+func TestValueCacheSwap(t *testing.T) {
+	c := newValueCache(0)
+	_, ok := c.swap("k", "v1")
+	require.False(t, ok)
+
+	old, ok := c.swap("k", "v2")
+	require.True(t, ok)
+	require.Equal(t, "v1", old)
+
+	old, ok = c.swap("k", nil)
+	require.True(t, ok)
+	require.Equal(t, "v2", old)
+	_, ok = c.swap("k", "v3")
+	require.False(t, ok)
+}
+
+func TestValueCacheLimit(t *testing.T) {
+	c := newValueCache(2)
+	c.swap("a", "1")
+	c.swap("b", "1")
+	c.swap("a", "2") // a最近变更
+	c.swap("c", "1") // 淘汰b
+	require.Equal(t, 2, c.len())
+
+	_, ok := c.swap("b", "2")
+	require.False(t, ok)
+	old, ok := c.swap("c", "2")
+	require.True(t, ok)
+	require.Equal(t, "1", old)
+
+	c.forget("c")
+	c.setLimit(1)
+	require.Equal(t, 1, c.len())
+	_, ok = c.swap("b", "3")
+	require.True(t, ok)
+}
+
+func TestParseKeyspaceChannel(t *testing.T) {
+	db, key, err := parseKeyspaceChannel("__keyspace@3__:user:1")
+	require.Nil(t, err)
+	require.Equal(t, 3, db)
+	require.Equal(t, "user:1", key)
+
+	_, _, err = parseKeyspaceChannel("__keyevent@0__:set")
+	require.NotNil(t, err)
+}
+
+func TestMergeNotifyFlags(t *testing.T) {
+	require.Equal(t, "KA", mergeNotifyFlags("", "KA"))
+	require.Equal(t, "ExKA", mergeNotifyFlags("Ex", "KA"))
+	require.Equal(t, "AKE", mergeNotifyFlags("AKE", "KA"))
+}
+
+func TestRedisLogExpireAt(t *testing.T) {
+	log := NewRedisLog(0, "user:1", "set")
+	require.True(t, log.GetExpireAt().IsZero())
 
+	log.HistoryTTL = time.Hour
+	require.Equal(t, log.Time.Add(time.Hour), log.GetExpireAt())
+	require.Equal(t, log.GetExpireAt(), *transport.NewRecord(log).ExpireAt)
 }
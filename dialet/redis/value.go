@@ -0,0 +1,186 @@
+package redis
+
+import (
+	"container/list"
+	"context"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+
+	goredis "github.com/go-redis/redis/v8"
+)
+
+// 键不存在时redis TYPE返回none
+const valueTypeNone = "none"
+
+// DefaultValueCacheSize 最多记录的key数量，超过时淘汰最久没有变更的key，被淘汰的key下一次变更没有Changes
+var DefaultValueCacheSize = 10000
+
+// key被删除的事件，收到时不再记录该key
+var removalEvents = map[string]bool{"del": true, "expired": true, "evicted": true, "rename_from": true}
+
+type cachedValue struct {
+	key   string
+	value interface{}
+}
+
+// valueCache 记录每个key最近一次读取到的值，用于计算变更
+type valueCache struct {
+	mu     sync.Mutex
+	limit  int
+	values map[string]*list.Element
+	order  *list.List // 最近变更的在前
+}
+
+func newValueCache(limit int) *valueCache {
+	return &valueCache{limit: limit, values: map[string]*list.Element{}, order: list.New()}
+}
+
+// swap 保存新值并返回之前的值，值为nil时删除记录
+func (c *valueCache) swap(key string, value interface{}) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var (
+		old interface{}
+		ok  bool
+	)
+	if elem, found := c.values[key]; found {
+		old, ok = elem.Value.(*cachedValue).value, true
+		c.order.Remove(elem)
+		delete(c.values, key)
+	}
+	if value == nil {
+		return old, ok
+	}
+
+	c.values[key] = c.order.PushFront(&cachedValue{key: key, value: value})
+	for c.limit > 0 && c.order.Len() > c.limit {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.values, oldest.Value.(*cachedValue).key)
+	}
+	return old, ok
+}
+
+// forget key被删除或者过期时不再记录
+func (c *valueCache) forget(key string) {
+	c.swap(key, nil)
+}
+
+func (c *valueCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// setLimit 修改最多记录的key数量，超过时淘汰
+func (c *valueCache) setLimit(limit int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.limit = limit
+	for c.limit > 0 && c.order.Len() > c.limit {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.values, oldest.Value.(*cachedValue).key)
+	}
+}
+
+// readValue 根据key的类型读取当前值
+// string => string, hash => map[string]interface{}, list => []interface{}
+// set => []interface{}(排序后), zset => map[string]interface{}(member => score)
+func readValue(ctx context.Context, client *goredis.Client, key string) (string, interface{}, error) {
+	typ, err := client.Type(ctx, key).Result()
+	if err != nil {
+		return "", nil, err
+	}
+
+	switch typ {
+	case valueTypeNone:
+		return typ, nil, nil
+	case "string":
+		value, err := client.Get(ctx, key).Result()
+		if err == goredis.Nil {
+			return valueTypeNone, nil, nil
+		}
+		return typ, value, err
+	case "hash":
+		values, err := client.HGetAll(ctx, key).Result()
+		if err != nil {
+			return typ, nil, err
+		}
+		res := make(map[string]interface{}, len(values))
+		for field, value := range values {
+			res[field] = value
+		}
+		return typ, res, nil
+	case "list":
+		values, err := client.LRange(ctx, key, 0, -1).Result()
+		return typ, toInterfaceSlice(values), err
+	case "set":
+		values, err := client.SMembers(ctx, key).Result()
+		sort.Strings(values)
+		return typ, toInterfaceSlice(values), err
+	case "zset":
+		values, err := client.ZRangeWithScores(ctx, key, 0, -1).Result()
+		if err != nil {
+			return typ, nil, err
+		}
+		res := make(map[string]interface{}, len(values))
+		for _, z := range values {
+			res[memberString(z.Member)] = z.Score
+		}
+		return typ, res, nil
+	default:
+		// stream等类型不读取具体值
+		return typ, nil, nil
+	}
+}
+
+func toInterfaceSlice(values []string) []interface{} {
+	res := make([]interface{}, len(values))
+	for i, value := range values {
+		res[i] = value
+	}
+	return res
+}
+
+func memberString(member interface{}) string {
+	switch m := member.(type) {
+	case string:
+		return m
+	case float64:
+		return strconv.FormatFloat(m, 'f', -1, 64)
+	default:
+		return ""
+	}
+}
+
+// diffValue 生成从新值回到旧值的变更，语义与postgres的merge patch一致
+// hash、zset按字段比较，字段被删除或新增时分别记录旧值或nil；其他类型整体记录在value下
+func diffValue(old, new interface{}) map[string]interface{} {
+	changes := map[string]interface{}{}
+
+	oldMap, oldOk := old.(map[string]interface{})
+	newMap, newOk := new.(map[string]interface{})
+	if oldOk && newOk {
+		for field, value := range oldMap {
+			if v, ok := newMap[field]; !ok || !reflect.DeepEqual(v, value) {
+				changes[field] = value
+			}
+		}
+		for field := range newMap {
+			if _, ok := oldMap[field]; !ok {
+				changes[field] = nil
+			}
+		}
+		return changes
+	}
+
+	if !reflect.DeepEqual(old, new) {
+		changes["value"] = old
+	}
+	return changes
+}
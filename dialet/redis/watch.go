@@ -0,0 +1,239 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/wwqdrh/logger"
+)
+
+var (
+	ErrNotifyDisabled = errors.New("notify-keyspace-events is not enabled and can't be changed with CONFIG SET")
+
+	// K: 键空间通知 A: 全部的事件类型
+	requiredNotifyFlags = "KA"
+)
+
+// EnableNotify 检查notify-keyspace-events，缺少键空间通知时尝试开启
+func (d *RedisDialet) EnableNotify(ctx context.Context) error {
+	result, err := d.client.ConfigGet(ctx, "notify-keyspace-events").Result()
+	if err != nil {
+		return err
+	}
+
+	current := ""
+	if len(result) == 2 {
+		current, _ = result[1].(string)
+	}
+
+	flags := mergeNotifyFlags(current, requiredNotifyFlags)
+	if flags == current {
+		return nil
+	}
+
+	if err := d.client.ConfigSet(ctx, "notify-keyspace-events", flags).Err(); err != nil {
+		logger.DefaultLogger.Error(err.Error())
+		return ErrNotifyDisabled
+	}
+	return nil
+}
+
+// 保留原有的配置，追加缺少的标志
+func mergeNotifyFlags(current, required string) string {
+	flags := current
+	for _, flag := range required {
+		if !strings.ContainsRune(flags, flag) {
+			flags += string(flag)
+		}
+	}
+	return flags
+}
+
+// 获取监听channel，key模式在redis端过滤，事件类型在本地过滤
+// 同时订阅PolicyChannel，策略变更时重新加载并调整订阅的key模式
+func (d *RedisDialet) Watch(ctx context.Context) chan interface{} {
+	res := make(chan interface{}, 8)
+
+	if err := d.EnableNotify(ctx); err != nil {
+		logger.DefaultLogger.Error(err.Error())
+		close(res)
+		return res
+	}
+
+	db := d.client.Options().DB
+	policies := groupPolicies(db, d.Policies())
+
+	pubsub := d.client.Subscribe(ctx, PolicyChannel)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		logger.DefaultLogger.Error(err.Error())
+		pubsub.Close()
+		close(res)
+		return res
+	}
+	if err := pubsub.PSubscribe(ctx, policyPatterns(policies)...); err != nil {
+		logger.DefaultLogger.Error(err.Error())
+		pubsub.Close()
+		close(res)
+		return res
+	}
+
+	go func() {
+		defer close(res)
+		defer pubsub.Close()
+
+		ch := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+
+				if msg.Pattern == "" && msg.Channel == PolicyChannel {
+					policies = d.reloadPolicies(ctx, pubsub, db, policies)
+					continue
+				}
+
+				msgDb, key, err := parseKeyspaceChannel(msg.Channel)
+				if err != nil {
+					logger.DefaultLogger.Error(err.Error())
+					continue
+				}
+
+				captured := false
+				for _, p := range policies[msg.Pattern] {
+					if !p.Match(msg.Payload) {
+						continue
+					}
+
+					log := NewRedisLog(msgDb, key, msg.Payload)
+					log.HistoryTTL = p.HistoryTTL
+					if p.Capture {
+						d.capture(ctx, log)
+						captured = true
+					}
+					select {
+					case res <- log:
+					case <-ctx.Done():
+						return
+					}
+					break
+				}
+
+				// 没有capture时key被删除也不再记录之前的值
+				if !captured && removalEvents[msg.Payload] {
+					d.values.forget(key)
+				}
+			}
+		}
+	}()
+
+	return res
+}
+
+// reloadPolicies 重新加载策略，取消已经删除的key模式，订阅新增的key模式
+// 加载失败时保留原有的策略
+func (d *RedisDialet) reloadPolicies(ctx context.Context, pubsub *goredis.PubSub, db int, current map[string][]Policy) map[string][]Policy {
+	loaded, err := d.loadPolicies(ctx)
+	if err != nil {
+		logger.DefaultLogger.Error(err.Error())
+		return current
+	}
+
+	next := groupPolicies(db, loaded)
+	removed, added := diffPatterns(current, next)
+	if len(removed) > 0 {
+		if err := pubsub.PUnsubscribe(ctx, removed...); err != nil {
+			logger.DefaultLogger.Error(err.Error())
+		}
+	}
+	if len(added) > 0 {
+		if err := pubsub.PSubscribe(ctx, added...); err != nil {
+			logger.DefaultLogger.Error(err.Error())
+		}
+	}
+	return next
+}
+
+// groupPolicies 按照订阅的channel模式对策略分组，没有策略时监听全部key
+func groupPolicies(db int, policies []Policy) map[string][]Policy {
+	res := map[string][]Policy{} // channel pattern => policies
+	for _, p := range policies {
+		channel := keyspaceChannel(db, p.Pattern)
+		res[channel] = append(res[channel], p)
+	}
+	if len(res) == 0 {
+		res[keyspaceChannel(db, "*")] = []Policy{{Id: "*", Pattern: "*"}}
+	}
+	return res
+}
+
+func policyPatterns(policies map[string][]Policy) []string {
+	patterns := make([]string, 0, len(policies))
+	for pattern := range policies {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	return patterns
+}
+
+func diffPatterns(current, next map[string][]Policy) (removed, added []string) {
+	for _, pattern := range policyPatterns(current) {
+		if _, ok := next[pattern]; !ok {
+			removed = append(removed, pattern)
+		}
+	}
+	for _, pattern := range policyPatterns(next) {
+		if _, ok := current[pattern]; !ok {
+			added = append(added, pattern)
+		}
+	}
+	return removed, added
+}
+
+// capture 读取key当前的值，并与上一次记录的值比较
+func (d *RedisDialet) capture(ctx context.Context, log *RedisLog) {
+	typ, value, err := readValue(ctx, d.client, log.Key)
+	if err != nil {
+		logger.DefaultLogger.Error(err.Error())
+		return
+	}
+
+	log.Captured = true
+	log.ValueType = typ
+	log.Value = value
+	if old, ok := d.values.swap(log.Key, value); ok {
+		log.Changes = diffValue(old, value)
+	}
+}
+
+func keyspaceChannel(db int, pattern string) string {
+	return fmt.Sprintf("__keyspace@%d__:%s", db, pattern)
+}
+
+// __keyspace@0__:user:1 => 0, user:1
+func parseKeyspaceChannel(channel string) (int, string, error) {
+	rest := strings.TrimPrefix(channel, "__keyspace@")
+	if rest == channel {
+		return 0, "", fmt.Errorf("not a keyspace channel: %s", channel)
+	}
+
+	index := strings.Index(rest, "__:")
+	if index < 0 {
+		return 0, "", fmt.Errorf("not a keyspace channel: %s", channel)
+	}
+
+	db, err := strconv.Atoi(rest[:index])
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid db in keyspace channel: %s", channel)
+	}
+
+	return db, rest[index+3:], nil
+}
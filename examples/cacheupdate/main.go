@@ -123,14 +123,13 @@ func cacheRepo(ch chan mydialet.ILogData) *datamanager.Repo {
 		Key:   "notescount",
 		Table: "notes",
 		Field: "*",
-		Call: func() interface{} {
+		Call: func(ctx context.Context) (interface{}, error) {
 			var count int
-			row := dialet.Stream().DB().QueryRow("select count(id) from notes")
-			err := row.Scan(&count)
-			if err != nil {
-				return -1
+			row := dialet.Stream().DB().QueryRowContext(ctx, "select count(id) from notes")
+			if err := row.Scan(&count); err != nil {
+				return nil, err
 			}
-			return count
+			return count, nil
 		},
 	})
 
@@ -16,6 +16,7 @@ require (
 	github.com/pkg/errors v0.9.1
 	github.com/spf13/viper v1.11.0
 	github.com/stretchr/testify v1.8.0
+	github.com/tinylib/msgp v1.1.6
 	google.golang.org/protobuf v1.28.0
 	gorm.io/gorm v1.23.4
 )
@@ -54,7 +55,6 @@ require (
 	github.com/spf13/jwalterweatherman v1.1.0 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
 	github.com/subosito/gotenv v1.2.0 // indirect
-	github.com/tinylib/msgp v1.1.6 // indirect
 	github.com/ugorji/go/codec v1.1.7 // indirect
 	github.com/wwqdrh/logger v0.0.2 // indirect
 	go.uber.org/atomic v1.9.0 // indirect
@@ -0,0 +1,248 @@
+package datamanager
+
+import (
+	"container/heap"
+	"container/list"
+	"sync"
+	"time"
+)
+
+// 本地缓存的过期以及容量限制
+// ValueMap保存值，localIndex记录每个key的过期时间、大小以及访问情况，超过限制时按照LRU或者LFU淘汰
+// 直接写入ValueMap的key不受限制
+
+// LocalSweepInterval 定期删除过期的本地缓存，Notify以及开启一致性之后的订阅中执行
+var LocalSweepInterval = time.Minute
+
+type Eviction int
+
+const (
+	EvictLRU Eviction = iota // 淘汰最久没有访问的key
+	EvictLFU                 // 淘汰访问次数最少的key，次数相同时淘汰最久没有访问的
+)
+
+// LocalLimit 本地缓存的限制，为0时不限制
+type LocalLimit struct {
+	MaxEntries int
+	MaxBytes   int64 // 按照值编码之后的长度估算
+	Eviction   Eviction
+}
+
+type localEntry struct {
+	key    string
+	expire time.Time // 为零值时不过期
+	size   int64
+	hits   uint64
+	tick   uint64 // 最后访问的顺序
+
+	elem  *list.Element // LRU
+	index int           // LFU堆中的位置
+}
+
+type localIndex struct {
+	mu      sync.Mutex
+	limit   LocalLimit
+	entries map[string]*localEntry
+	order   *list.List // LRU，最近访问的在前
+	freq    lfuHeap
+	bytes   int64
+	tick    uint64
+}
+
+// setLimit 修改淘汰方式时重建访问顺序，返回需要淘汰的key
+func (l *localIndex) setLimit(limit LocalLimit) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries := make([]*localEntry, 0, len(l.entries))
+	for _, e := range l.entries {
+		entries = append(entries, e)
+	}
+	l.entries, l.order, l.freq, l.bytes = nil, nil, nil, 0
+	l.limit = limit
+	for _, e := range entries {
+		l.insert(e)
+	}
+	return l.evict(0, 0)
+}
+
+// add 记录key，返回需要淘汰的key，单个值超过MaxBytes时admitted为false，不应写入本地缓存
+func (l *localIndex) add(key string, ttl time.Duration, size int64) (evicted []string, admitted bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.remove(key)
+	if l.limit.MaxBytes > 0 && size > l.limit.MaxBytes {
+		return nil, false
+	}
+
+	evicted = l.evict(1, size)
+	e := &localEntry{key: key, size: size, hits: 1}
+	if ttl > 0 {
+		e.expire = time.Now().Add(ttl)
+	}
+	l.insert(e)
+	return evicted, true
+}
+
+// hit 记录一次访问，key已经过期时返回false，没有记录的key认为有效
+func (l *localIndex) hit(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.entries[key]
+	if !ok {
+		return true
+	}
+	if e.expired(time.Now()) {
+		return false
+	}
+	l.tick++
+	e.hits++
+	e.tick = l.tick
+	if l.limit.Eviction == EvictLFU {
+		heap.Fix(&l.freq, e.index)
+	} else {
+		l.order.MoveToFront(e.elem)
+	}
+	return true
+}
+
+// expired key已经过期
+func (l *localIndex) expired(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.entries[key]
+	return ok && e.expired(time.Now())
+}
+
+// expiredKeys 已经过期的key
+func (l *localIndex) expiredKeys(now time.Time) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var keys []string
+	for key, e := range l.entries {
+		if e.expired(now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (l *localIndex) delete(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.remove(key)
+}
+
+// measured 是否需要计算值的大小
+func (l *localIndex) measured() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit.MaxBytes > 0
+}
+
+func (l *localIndex) len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.entries)
+}
+
+// insert 调用方需要持有锁
+func (l *localIndex) insert(e *localEntry) {
+	if l.entries == nil {
+		l.entries = map[string]*localEntry{}
+		l.order = list.New()
+	}
+	l.tick++
+	e.tick = l.tick
+	l.entries[e.key] = e
+	l.bytes += e.size
+	if l.limit.Eviction == EvictLFU {
+		heap.Push(&l.freq, e)
+	} else {
+		e.elem = l.order.PushFront(e)
+	}
+}
+
+// remove 调用方需要持有锁
+func (l *localIndex) remove(key string) {
+	e, ok := l.entries[key]
+	if !ok {
+		return
+	}
+	delete(l.entries, key)
+	l.bytes -= e.size
+	if l.limit.Eviction == EvictLFU {
+		heap.Remove(&l.freq, e.index)
+	} else {
+		l.order.Remove(e.elem)
+	}
+}
+
+// evict 淘汰key直到可以再放入count个总大小为size的值，优先淘汰已经过期的key，调用方需要持有锁
+func (l *localIndex) evict(count int, size int64) []string {
+	var evicted []string
+	now := time.Now()
+	over := func() bool {
+		return (l.limit.MaxEntries > 0 && len(l.entries)+count > l.limit.MaxEntries) ||
+			(l.limit.MaxBytes > 0 && l.bytes+size > l.limit.MaxBytes)
+	}
+	if !over() {
+		return nil
+	}
+
+	for key, e := range l.entries {
+		if e.expired(now) {
+			l.remove(key)
+			evicted = append(evicted, key)
+		}
+	}
+	for len(l.entries) > 0 && over() {
+		var victim *localEntry
+		if l.limit.Eviction == EvictLFU {
+			victim = l.freq[0]
+		} else {
+			victim = l.order.Back().Value.(*localEntry)
+		}
+		l.remove(victim.key)
+		evicted = append(evicted, victim.key)
+	}
+	return evicted
+}
+
+func (e *localEntry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && now.After(e.expire)
+}
+
+// lfuHeap 访问次数最少的在堆顶
+type lfuHeap []*localEntry
+
+func (h lfuHeap) Len() int { return len(h) }
+
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].hits != h[j].hits {
+		return h[i].hits < h[j].hits
+	}
+	return h[i].tick < h[j].tick
+}
+
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *lfuHeap) Push(x interface{}) {
+	e := x.(*localEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *lfuHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
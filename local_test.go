@@ -0,0 +1,211 @@
+package datamanager
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/wwqdrh/datamanager/dialet"
+)
+
+func newLimitRepo(loads map[string]int) *Repo {
+	r := NewRepo(make(chan dialet.ILogData))
+	r.Register(&Policy{
+		Key:   "item:{id}",
+		Table: "items",
+		Field: "*",
+		Load: func(ctx context.Context, params map[string]string) (interface{}, error) {
+			loads[params["id"]]++
+			return fmt.Sprintf("%s#%d", params["id"], loads[params["id"]]), nil
+		},
+	})
+	return r
+}
+
+func TestLocalTTL(t *testing.T) {
+	loads := map[string]int{}
+	r := NewRepo(make(chan dialet.ILogData))
+	r.Register(&Policy{
+		Key:      "count",
+		Table:    "items",
+		Field:    "*",
+		LocalTTL: 50 * time.Millisecond,
+		Call: func(ctx context.Context) (interface{}, error) {
+			loads["count"]++
+			return loads["count"], nil
+		},
+	})
+
+	if val := r.GetValue("count"); val != 1 {
+		t.Error(val)
+	}
+	if val := r.GetValue("count"); val != 1 {
+		t.Error(val)
+	}
+	time.Sleep(80 * time.Millisecond)
+	if val := r.GetValue("count"); val != 2 {
+		t.Error("should reload after ttl", val)
+	}
+}
+
+func TestLocalLRU(t *testing.T) {
+	loads := map[string]int{}
+	r := newLimitRepo(loads).SetLocalLimit(LocalLimit{MaxEntries: 2})
+
+	r.GetValue("item:1")
+	r.GetValue("item:2")
+	r.GetValue("item:1")
+	r.GetValue("item:3") // 淘汰item:2
+
+	if _, ok := r.ValueMap.Load("item:2"); ok {
+		t.Error("item:2 should be evicted")
+	}
+	if _, ok := r.ValueMap.Load("item:1"); !ok {
+		t.Error("item:1 should be kept")
+	}
+	if n := r.local.len(); n != 2 {
+		t.Error("entries", n)
+	}
+	if val := r.GetValue("item:2"); val != "2#2" {
+		t.Error(val)
+	}
+}
+
+func TestLocalLFU(t *testing.T) {
+	loads := map[string]int{}
+	r := newLimitRepo(loads).SetLocalLimit(LocalLimit{MaxEntries: 2, Eviction: EvictLFU})
+
+	r.GetValue("item:1")
+	r.GetValue("item:1")
+	r.GetValue("item:2")
+	r.GetValue("item:2")
+	r.GetValue("item:2")
+	r.GetValue("item:3") // 淘汰访问次数最少的item:1
+
+	if _, ok := r.ValueMap.Load("item:1"); ok {
+		t.Error("item:1 should be evicted")
+	}
+	if _, ok := r.ValueMap.Load("item:2"); !ok {
+		t.Error("item:2 should be kept")
+	}
+
+	// 缩小限制时立即淘汰
+	r.SetLocalLimit(LocalLimit{MaxEntries: 1, Eviction: EvictLFU})
+	if _, ok := r.ValueMap.Load("item:3"); ok {
+		t.Error("item:3 should be evicted")
+	}
+}
+
+func TestLocalMaxBytes(t *testing.T) {
+	loads := map[string]int{}
+	// json编码的"1#1"为5个字节
+	r := newLimitRepo(loads).SetLocalLimit(LocalLimit{MaxBytes: 10})
+
+	r.GetValue("item:1")
+	r.GetValue("item:2")
+	r.GetValue("item:3")
+	if _, ok := r.ValueMap.Load("item:1"); ok {
+		t.Error("item:1 should be evicted")
+	}
+
+	// 超过限制的值不写入本地缓存，仍然返回
+	r.SetLocalLimit(LocalLimit{MaxBytes: 4})
+	if val := r.GetValue("item:4"); val != "4#1" {
+		t.Error(val)
+	}
+	if _, ok := r.ValueMap.Load("item:4"); ok {
+		t.Error("item:4 should not be cached")
+	}
+
+	// 无法编码的值不知道大小，不写入本地缓存
+	r.Register(&Policy{
+		Key:   "func",
+		Table: "items",
+		Field: "*",
+		Call: func(ctx context.Context) (interface{}, error) {
+			return func() {}, nil
+		},
+	})
+	if val := r.GetValue("func"); val == nil {
+		t.Error("func should be returned")
+	}
+	if _, ok := r.ValueMap.Load("func"); ok || r.local.len() != 0 {
+		t.Error("func should not be cached")
+	}
+}
+
+func TestLocalSweep(t *testing.T) {
+	interval := LocalSweepInterval
+	LocalSweepInterval = 10 * time.Millisecond
+	defer func() { LocalSweepInterval = interval }()
+
+	r := NewRepo(make(chan dialet.ILogData))
+	r.Register(&Policy{
+		Key:      "item:{id}",
+		Table:    "items",
+		Field:    "*",
+		LocalTTL: 20 * time.Millisecond,
+		Load: func(ctx context.Context, params map[string]string) (interface{}, error) {
+			return params["id"], nil
+		},
+	})
+	for i := 0; i < 10; i++ {
+		r.GetValue(fmt.Sprintf("item:%d", i))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Notify(ctx)
+
+	// 没有访问的过期key也会被删除
+	time.Sleep(100 * time.Millisecond)
+	if n := r.local.len(); n != 0 {
+		t.Error(n)
+	}
+	if _, ok := r.ValueMap.Load("item:1"); ok {
+		t.Error("item:1 should be swept")
+	}
+}
+
+func TestInvalidateOnly(t *testing.T) {
+	loads := map[string]int{}
+	r := NewRepo(make(chan dialet.ILogData))
+	r.Register(&Policy{
+		Key:            "count",
+		Table:          "items",
+		Field:          "*",
+		InvalidateOnly: true,
+		Call: func(ctx context.Context) (interface{}, error) {
+			loads["count"]++
+			return loads["count"], nil
+		},
+	})
+	r.Register(&Policy{
+		Key:            "item:{id}",
+		Table:          "items",
+		Field:          "*",
+		InvalidateOnly: true,
+		Load: func(ctx context.Context, params map[string]string) (interface{}, error) {
+			loads[params["id"]]++
+			return loads[params["id"]], nil
+		},
+	})
+
+	r.GetValue("count")
+	r.GetValue("item:1")
+	version := r.Version("item:1")
+	r.Trigger(&testLog{table: "items", label: "update", payload: map[string]interface{}{"id": 1}})
+
+	if loads["count"] != 1 || loads["1"] != 1 {
+		t.Error("should not load in trigger", loads)
+	}
+	if _, ok := r.ValueMap.Load("item:1"); ok || r.Version("item:1") <= version {
+		t.Error("item:1 should be invalidated")
+	}
+	if val := r.GetValue("count"); val != 2 {
+		t.Error(val)
+	}
+	if val := r.GetValue("item:1"); val != 2 {
+		t.Error(val)
+	}
+}
@@ -0,0 +1,301 @@
+package restore
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wwqdrh/datamanager/transport"
+)
+
+// Snapshot 数据表在某个时间点的状态，Rows为主键 => 行数据
+type Snapshot struct {
+	Schema  string                            `json:"schema"`
+	Table   string                            `json:"table"`
+	At      time.Time                         `json:"at"`
+	Rows    map[string]map[string]interface{} `json:"rows"`
+	Events  int                               `json:"events"`
+	Skipped int                               `json:"skipped"` // 没有主键无法还原的记录
+}
+
+// Reconstruct 按照时间顺序回放历史，得到数据表在at时的状态，包含at时刻的修改
+// at为零值时回放全部的历史
+func (r *Restorer) Reconstruct(ctx context.Context, schema, table string, at time.Time) (*Snapshot, error) {
+	snapshot := &Snapshot{
+		Schema: schema,
+		Table:  table,
+		At:     at,
+		Rows:   map[string]map[string]interface{}{},
+	}
+
+	q := transport.Query{Schema: schema, Table: table}
+	if !at.IsZero() {
+		q.End = at.Add(time.Nanosecond)
+	}
+	stats, err := transport.Replay(ctx, r.source, q, transport.ReplayOptions{}, func(log transport.ILogData) error {
+		snapshot.apply(log, r.primaryKey)
+		return nil
+	})
+	snapshot.Events = stats.Events
+	return snapshot, err
+}
+
+// apply 使用payload中的主键列作为行的主键，默认的主键列不存在时使用日志记录的主键
+func (s *Snapshot) apply(log transport.ILogData, primaryKey string) {
+	switch log.GetLabel() {
+	case "truncate":
+		s.Rows = map[string]map[string]interface{}{}
+		return
+	}
+
+	id := rowKey(log.GetPaylod(), primaryKey)
+	if id == "" && primaryKey == DefaultPrimaryKey {
+		id = transport.GetId(log)
+	}
+	if id == "" {
+		s.Skipped++
+		return
+	}
+
+	switch log.GetLabel() {
+	case "delete":
+		delete(s.Rows, id)
+	default:
+		s.Rows[id] = log.GetPaylod()
+	}
+}
+
+// Ids 排序后的主键，数字主键按照数值排序
+func (s *Snapshot) Ids() []string {
+	res := make([]string, 0, len(s.Rows))
+	for id := range s.Rows {
+		res = append(res, id)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if len(res[i]) != len(res[j]) && isDigits(res[i]) && isDigits(res[j]) {
+			return len(res[i]) < len(res[j])
+		}
+		return res[i] < res[j]
+	})
+	return res
+}
+
+// Columns 全部行的字段并集
+func (s *Snapshot) Columns() []string {
+	set := map[string]bool{}
+	for _, row := range s.Rows {
+		for column := range row {
+			set[column] = true
+		}
+	}
+	res := make([]string, 0, len(set))
+	for column := range set {
+		res = append(res, column)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// WriteJSON 按照主键顺序输出行数据的json数组
+func (s *Snapshot) WriteJSON(w io.Writer) error {
+	rows := make([]map[string]interface{}, 0, len(s.Rows))
+	for _, id := range s.Ids() {
+		rows = append(rows, s.Rows[id])
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(rows)
+}
+
+// WriteCSV 第一行为字段名，null输出为空，嵌套的对象输出为json
+func (s *Snapshot) WriteCSV(w io.Writer) error {
+	columns := s.Columns()
+	writer := csv.NewWriter(w)
+	if err := writer.Write(columns); err != nil {
+		return err
+	}
+
+	for _, id := range s.Ids() {
+		row := s.Rows[id]
+		record := make([]string, len(columns))
+		for i, column := range columns {
+			record[i] = formatCell(row[column])
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// Materialize 在源数据库中创建与原表结构相同的新表，并写入快照中的数据
+// 使用postgres的CREATE TABLE ... (LIKE ...)，新表已经存在时返回错误
+func (r *Restorer) Materialize(ctx context.Context, snapshot *Snapshot, target string) (int, error) {
+	if r.db == nil {
+		return 0, ErrNoDatabase
+	}
+
+	schema, table := snapshot.Schema, target
+	if index := strings.Index(target, "."); index >= 0 {
+		schema, table = target[:index], target[index+1:]
+	}
+	name := quoteIdent(schema) + "." + quoteIdent(table)
+	source := quoteIdent(snapshot.Schema) + "." + quoteIdent(snapshot.Table)
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("CREATE TABLE %s (LIKE %s INCLUDING DEFAULTS)", name, source)); err != nil {
+		return 0, err
+	}
+	for _, id := range snapshot.Ids() {
+		stmt := insertStatement(name, snapshot.Rows[id])
+		if _, err := tx.ExecContext(ctx, stmt.Query, stmt.Args...); err != nil {
+			return 0, fmt.Errorf("insert %s: %w", id, err)
+		}
+	}
+	return len(snapshot.Rows), tx.Commit()
+}
+
+// CheckResult 还原的当前状态与实际数据表的差异
+type CheckResult struct {
+	Rows      int      `json:"rows"`      // 实际数据表的行数
+	Missing   []string `json:"missing"`   // 实际存在但是历史中没有
+	Extra     []string `json:"extra"`     // 历史中存在但是实际已经没有
+	Different []string `json:"different"` // 字段值不一致
+}
+
+func (c CheckResult) Consistent() bool {
+	return len(c.Missing) == 0 && len(c.Extra) == 0 && len(c.Different) == 0
+}
+
+// Check 回放全部历史得到当前状态，并与实际的数据表比较
+// 实际数据通过postgres的row_to_json读取
+func (r *Restorer) Check(ctx context.Context, schema, table string) (CheckResult, error) {
+	if r.db == nil {
+		return CheckResult{}, ErrNoDatabase
+	}
+
+	snapshot, err := r.Reconstruct(ctx, schema, table, time.Time{})
+	if err != nil {
+		return CheckResult{}, err
+	}
+
+	rows, err := r.db.QueryContext(ctx, fmt.Sprintf("SELECT row_to_json(t)::text FROM %s.%s t", quoteIdent(schema), quoteIdent(table)))
+	if err != nil {
+		return CheckResult{}, err
+	}
+	defer rows.Close()
+
+	live := map[string]map[string]interface{}{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return CheckResult{}, err
+		}
+		row := map[string]interface{}{}
+		decoder := json.NewDecoder(strings.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&row); err != nil {
+			return CheckResult{}, err
+		}
+		live[rowKey(row, r.primaryKey)] = row
+	}
+	if err := rows.Err(); err != nil {
+		return CheckResult{}, err
+	}
+	return CompareSnapshot(snapshot, live), nil
+}
+
+// rowKey 主键列的值，数字不使用科学计数法，与历史记录中的主键一致
+func rowKey(row map[string]interface{}, primaryKey string) string {
+	switch v := row[primaryKey].(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case json.Number:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// CompareSnapshot 比较快照与实际的行数据，live为主键 => 行数据
+func CompareSnapshot(snapshot *Snapshot, live map[string]map[string]interface{}) CheckResult {
+	res := CheckResult{Rows: len(live), Missing: []string{}, Extra: []string{}, Different: []string{}}
+
+	ids := (&Snapshot{Rows: live}).Ids()
+	for _, id := range ids {
+		row, ok := snapshot.Rows[id]
+		if !ok {
+			res.Missing = append(res.Missing, id)
+		} else if !reflect.DeepEqual(normalizeRow(row), normalizeRow(live[id])) {
+			res.Different = append(res.Different, id)
+		}
+	}
+	for _, id := range snapshot.Ids() {
+		if _, ok := live[id]; !ok {
+			res.Extra = append(res.Extra, id)
+		}
+	}
+	return res
+}
+
+// normalizeRow 经过json编码后比较，避免数字类型不同
+func normalizeRow(row map[string]interface{}) map[string]interface{} {
+	data, _ := json.Marshal(row)
+	res := map[string]interface{}{}
+	json.Unmarshal(data, &res)
+	return res
+}
+
+func insertStatement(name string, row map[string]interface{}) Statement {
+	columns := sortedKeys(row)
+	idents := make([]string, len(columns))
+	params := make([]string, len(columns))
+	args := make([]interface{}, len(columns))
+	for i, column := range columns {
+		idents[i] = quoteIdent(column)
+		params[i] = fmt.Sprintf("$%d", i+1)
+		args[i] = row[column]
+	}
+	return newStatement(
+		fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", name, strings.Join(idents, ", "), strings.Join(params, ", ")),
+		args...,
+	)
+}
+
+func formatCell(v interface{}) string {
+	switch value := normalizeArg(v).(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
@@ -0,0 +1,104 @@
+package restore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/wwqdrh/datamanager/transport"
+)
+
+func TestReconstruct(t *testing.T) {
+	r, _ := newTestRestorer(t)
+	base := time.Date(2022, 7, 1, 9, 0, 0, 0, time.UTC)
+
+	require.Nil(t, r.source.SaveBatch([]transport.ILogData{
+		transport.Record{Schema: "public", Table: "notes", Label: "insert", Time: base.Add(30 * time.Second),
+			Payload: map[string]interface{}{"id": 2, "name": "user2", "note": "a,b"}},
+		transport.Record{Schema: "public", Table: "notes", Label: "insert", Time: base.Add(30 * time.Second),
+			Payload: map[string]interface{}{"name": "no id"}},
+		transport.Record{Schema: "public", Table: "notes", Label: "truncate", Time: base.Add(3 * time.Minute)},
+		transport.Record{Schema: "public", Table: "notes", Label: "insert", Time: base.Add(4 * time.Minute),
+			Payload: map[string]interface{}{"id": 3, "name": "user3", "note": nil}},
+	}))
+
+	snapshot, err := r.Reconstruct(context.Background(), "public", "notes", base.Add(time.Minute))
+	require.Nil(t, err)
+	require.Equal(t, []string{"2", "14"}, snapshot.Ids())
+	require.Equal(t, "changed", snapshot.Rows["14"]["note"])
+	require.Equal(t, 1, snapshot.Skipped)
+
+	var buf bytes.Buffer
+	require.Nil(t, snapshot.WriteCSV(&buf))
+	require.Equal(t, "id,name,note\n2,user2,\"a,b\"\n14,user1,changed\n", buf.String())
+
+	buf.Reset()
+	require.Nil(t, snapshot.WriteJSON(&buf))
+	require.Contains(t, buf.String(), `"note": "a,b"`)
+
+	// 删除之后
+	snapshot, err = r.Reconstruct(context.Background(), "public", "notes", base.Add(2*time.Minute))
+	require.Nil(t, err)
+	require.Equal(t, []string{"2"}, snapshot.Ids())
+
+	// truncate之后的全部历史
+	snapshot, err = r.Reconstruct(context.Background(), "public", "notes", time.Time{})
+	require.Nil(t, err)
+	require.Equal(t, []string{"3"}, snapshot.Ids())
+	require.Equal(t, 7, snapshot.Events)
+}
+
+func TestCompareSnapshot(t *testing.T) {
+	snapshot := &Snapshot{Rows: map[string]map[string]interface{}{
+		"1": {"id": 1, "name": "a"},
+		"2": {"id": 2, "name": "b"},
+		"3": {"id": 3, "name": "c"},
+	}}
+	live := map[string]map[string]interface{}{
+		"1": {"id": 1.0, "name": "a"},
+		"2": {"id": 2.0, "name": "changed"},
+		"4": {"id": 4.0, "name": "d"},
+	}
+
+	res := CompareSnapshot(snapshot, live)
+	require.False(t, res.Consistent())
+	require.Equal(t, 3, res.Rows)
+	require.Equal(t, []string{"4"}, res.Missing)
+	require.Equal(t, []string{"3"}, res.Extra)
+	require.Equal(t, []string{"2"}, res.Different)
+
+	delete(live, "4")
+	live["2"]["name"] = "b"
+	live["3"] = map[string]interface{}{"id": 3, "name": "c"}
+	require.True(t, CompareSnapshot(snapshot, live).Consistent())
+}
+
+func TestReconstructPrimaryKey(t *testing.T) {
+	r, _ := newTestRestorer(t)
+	base := time.Date(2022, 7, 1, 9, 0, 0, 0, time.UTC)
+	require.Nil(t, r.source.SaveBatch([]transport.ILogData{
+		transport.Record{Schema: "public", Table: "prices", Label: "insert", Time: base,
+			Payload: map[string]interface{}{"id": 1, "code": 1234567, "price": 10}},
+		transport.Record{Schema: "public", Table: "prices", Label: "update", Time: base.Add(time.Minute),
+			Payload: map[string]interface{}{"id": 2, "code": 1234567, "price": 11}},
+	}))
+
+	// 大于1e6的数字主键不使用科学计数法
+	snapshot, err := r.Reconstruct(context.Background(), "public", "prices", time.Time{})
+	require.Nil(t, err)
+	require.Equal(t, []string{"1", "2"}, snapshot.Ids())
+
+	snapshot, err = r.SetPrimaryKey("code").Reconstruct(context.Background(), "public", "prices", time.Time{})
+	require.Nil(t, err)
+	require.Equal(t, []string{"1234567"}, snapshot.Ids())
+	require.Equal(t, float64(11), snapshot.Rows["1234567"]["price"])
+
+	live := map[string]map[string]interface{}{}
+	for _, row := range []map[string]interface{}{{"code": 1234567.0}, {"code": json.Number("7654321")}} {
+		live[rowKey(row, "code")] = row
+	}
+	require.Equal(t, []string{"1234567", "7654321"}, (&Snapshot{Rows: live}).Ids())
+}
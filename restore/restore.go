@@ -0,0 +1,336 @@
+package restore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wwqdrh/datamanager/transport"
+)
+
+var (
+	ErrNoVersion      = errors.New("no recorded version")
+	ErrVersionRange   = errors.New("version out of range")
+	ErrNotConfirmed   = errors.New("restore not confirmed")
+	ErrNoDatabase     = errors.New("no database to execute restore")
+	ErrEmptyRowImage  = errors.New("version has no row image")
+	DefaultPrimaryKey = "id"
+)
+
+// Version 记录在某次修改之后的状态，Deleted表示该次修改删除了记录
+type Version struct {
+	Version int                    `json:"version"` // 从1开始
+	Op      string                 `json:"op"`
+	Time    time.Time              `json:"time"`
+	Actor   string                 `json:"actor,omitempty"`
+	TxId    string                 `json:"tx_id,omitempty"`
+	Deleted bool                   `json:"deleted"`
+	Row     map[string]interface{} `json:"row"`
+	Changes map[string]interface{} `json:"changes,omitempty"`
+}
+
+// FieldDiff 两个版本之间字段的变化，字段不存在时为nil
+type FieldDiff struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+type Diff struct {
+	From        int                  `json:"from"`
+	To          int                  `json:"to"`
+	FromDeleted bool                 `json:"from_deleted"`
+	ToDeleted   bool                 `json:"to_deleted"`
+	Fields      map[string]FieldDiff `json:"fields"`
+}
+
+// Statement 参数化的sql，String()用于展示给操作人员确认
+type Statement struct {
+	Query string        `json:"query"`
+	Args  []interface{} `json:"args"`
+	Text  string        `json:"text"`
+}
+
+func (s Statement) String() string {
+	return s.Text
+}
+
+// Restorer 基于存储端记录的历史，将某一行恢复到指定的版本
+// 生成的sql为postgres语法
+type Restorer struct {
+	source     transport.Transport
+	db         *sql.DB
+	primaryKey string
+}
+
+// NewRestorer db为空时只能生成sql，不能执行
+func NewRestorer(source transport.Transport, db *sql.DB) *Restorer {
+	return &Restorer{
+		source:     source,
+		db:         db,
+		primaryKey: DefaultPrimaryKey,
+	}
+}
+
+// SetPrimaryKey 设置主键列名，默认为id
+func (r *Restorer) SetPrimaryKey(column string) *Restorer {
+	r.primaryKey = column
+	return r
+}
+
+// Versions 记录按照时间顺序的全部版本
+func (r *Restorer) Versions(schema, table, id string) ([]Version, error) {
+	records, err := r.source.Query(transport.Query{Schema: schema, Table: table, RecordId: id})
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]Version, 0, len(records))
+	for i, record := range records {
+		res = append(res, Version{
+			Version: i + 1,
+			Op:      record.Label,
+			Time:    record.Time,
+			Actor:   record.Actor,
+			TxId:    record.TxId,
+			Deleted: record.Label == "delete",
+			Row:     record.Payload,
+			Changes: record.Changes,
+		})
+	}
+	return res, nil
+}
+
+// Diff 比较两个版本，from、to从1开始
+func (r *Restorer) Diff(schema, table, id string, from, to int) (Diff, error) {
+	versions, err := r.Versions(schema, table, id)
+	if err != nil {
+		return Diff{}, err
+	}
+	a, err := pick(versions, from)
+	if err != nil {
+		return Diff{}, err
+	}
+	b, err := pick(versions, to)
+	if err != nil {
+		return Diff{}, err
+	}
+	return DiffVersions(a, b), nil
+}
+
+// DiffVersions 删除状态的版本视为没有任何字段
+func DiffVersions(a, b Version) Diff {
+	res := Diff{
+		From:        a.Version,
+		To:          b.Version,
+		FromDeleted: a.Deleted,
+		ToDeleted:   b.Deleted,
+		Fields:      map[string]FieldDiff{},
+	}
+
+	from, to := rowState(a), rowState(b)
+	for field, value := range from {
+		if v, ok := to[field]; !ok || !reflect.DeepEqual(v, value) {
+			res.Fields[field] = FieldDiff{From: value, To: to[field]}
+		}
+	}
+	for field, value := range to {
+		if _, ok := from[field]; !ok {
+			res.Fields[field] = FieldDiff{To: value}
+		}
+	}
+	return res
+}
+
+// Plan 生成将记录恢复到指定版本的sql，记录已经是该状态时返回空
+// 当前状态为最后一个版本：目标为删除时生成DELETE，当前已删除时重新INSERT，否则UPDATE变化的字段
+func (r *Restorer) Plan(schema, table, id string, version int) ([]Statement, error) {
+	versions, err := r.Versions(schema, table, id)
+	if err != nil {
+		return nil, err
+	}
+	target, err := pick(versions, version)
+	if err != nil {
+		return nil, err
+	}
+	current := versions[len(versions)-1]
+
+	name := quoteIdent(schema) + "." + quoteIdent(table)
+	switch {
+	case target.Deleted && current.Deleted:
+		return nil, nil
+	case target.Deleted:
+		return []Statement{newStatement(
+			fmt.Sprintf("DELETE FROM %s WHERE %s = $1", name, quoteIdent(r.primaryKey)),
+			id,
+		)}, nil
+	case len(target.Row) == 0:
+		return nil, ErrEmptyRowImage
+	case current.Deleted:
+		columns := sortedKeys(target.Row)
+		idents := make([]string, len(columns))
+		params := make([]string, len(columns))
+		args := make([]interface{}, len(columns))
+		for i, column := range columns {
+			idents[i] = quoteIdent(column)
+			params[i] = "$" + strconv.Itoa(i+1)
+			args[i] = target.Row[column]
+		}
+		return []Statement{newStatement(
+			fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", name, strings.Join(idents, ", "), strings.Join(params, ", ")),
+			args...,
+		)}, nil
+	default:
+		diff := DiffVersions(current, target)
+		sets := []string{}
+		args := []interface{}{}
+		for _, column := range sortedKeys(target.Row) {
+			if _, ok := diff.Fields[column]; !ok {
+				continue
+			}
+			args = append(args, target.Row[column])
+			sets = append(sets, fmt.Sprintf("%s = $%d", quoteIdent(column), len(args)))
+		}
+		if len(sets) == 0 {
+			return nil, nil
+		}
+		args = append(args, id)
+		return []Statement{newStatement(
+			fmt.Sprintf("UPDATE %s SET %s WHERE %s = $%d", name, strings.Join(sets, ", "), quoteIdent(r.primaryKey), len(args)),
+			args...,
+		)}, nil
+	}
+}
+
+// Restore 生成并在一个事务中执行恢复的sql，confirm为false时只返回sql
+func (r *Restorer) Restore(ctx context.Context, schema, table, id string, version int, confirm bool) ([]Statement, error) {
+	statements, err := r.Plan(schema, table, id, version)
+	if err != nil || !confirm {
+		return statements, err
+	}
+	if r.db == nil {
+		return statements, ErrNoDatabase
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return statements, err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt.Query, stmt.Args...); err != nil {
+			return statements, err
+		}
+	}
+	return statements, tx.Commit()
+}
+
+func pick(versions []Version, version int) (Version, error) {
+	if len(versions) == 0 {
+		return Version{}, ErrNoVersion
+	}
+	if version < 1 || version > len(versions) {
+		return Version{}, fmt.Errorf("%w: %d (1-%d)", ErrVersionRange, version, len(versions))
+	}
+	return versions[version-1], nil
+}
+
+func rowState(v Version) map[string]interface{} {
+	if v.Deleted {
+		return map[string]interface{}{}
+	}
+	return v.Row
+}
+
+func newStatement(query string, args ...interface{}) Statement {
+	for i, arg := range args {
+		args[i] = normalizeArg(arg)
+	}
+	return Statement{Query: query, Args: args, Text: render(query, args)}
+}
+
+// normalizeArg json解析出的整数为float64，嵌套的对象和数组转换为json字符串
+func normalizeArg(v interface{}) interface{} {
+	switch value := v.(type) {
+	case float64:
+		if value == math.Trunc(value) && math.Abs(value) < 1<<53 {
+			return int64(value)
+		}
+		return value
+	case map[string]interface{}, []interface{}:
+		data, _ := json.Marshal(value)
+		return string(data)
+	default:
+		return v
+	}
+}
+
+// render 将参数替换为字面量，从左往右扫描一次，替换后的字面量以及引号中的内容不会再被替换
+func render(query string, args []interface{}) string {
+	var b strings.Builder
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(query[i+1:], c)
+			if end < 0 {
+				b.WriteString(query[i:])
+				return b.String() + ";"
+			}
+			b.WriteString(query[i : i+end+2])
+			i += end + 1
+		case c == '$':
+			j := i + 1
+			for j < len(query) && query[j] >= '0' && query[j] <= '9' {
+				j++
+			}
+			if n, err := strconv.Atoi(query[i+1 : j]); err == nil && n >= 1 && n <= len(args) {
+				b.WriteString(quoteLiteral(args[n-1]))
+				i = j - 1
+				continue
+			}
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String() + ";"
+}
+
+func quoteLiteral(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return "NULL"
+	case bool:
+		return strconv.FormatBool(value)
+	case int64:
+		return strconv.FormatInt(value, 10)
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case string:
+		return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+	default:
+		return quoteLiteral(fmt.Sprint(value))
+	}
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	res := make([]string, 0, len(m))
+	for key := range m {
+		res = append(res, key)
+	}
+	sort.Strings(res)
+	return res
+}
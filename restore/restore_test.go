@@ -0,0 +1,128 @@
+package restore
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/wwqdrh/datamanager/transport"
+	"github.com/wwqdrh/datamanager/transport/sqlite"
+)
+
+// newTestRestorer 历史记录: 插入 -> 修改 -> 删除
+// 执行使用sqlite，附加一个名为public的数据库模拟schema
+func newTestRestorer(t *testing.T) (*Restorer, *sql.DB) {
+	dir := t.TempDir()
+	source, err := sqlite.NewSqliteTransport(filepath.Join(dir, "history.db"))
+	require.Nil(t, err)
+	t.Cleanup(func() { source.Close() })
+
+	base := time.Date(2022, 7, 1, 9, 0, 0, 0, time.UTC)
+	require.Nil(t, source.SaveBatch([]transport.ILogData{
+		transport.Record{Schema: "public", Table: "notes", Label: "insert", Time: base,
+			Payload: map[string]interface{}{"id": 14, "name": "user1", "note": "it's a note"}},
+		transport.Record{Schema: "public", Table: "notes", Label: "update", Time: base.Add(time.Minute), Actor: "bob",
+			Payload: map[string]interface{}{"id": 14, "name": "user1", "note": "changed"},
+			Changes: map[string]interface{}{"note": "it's a note"}},
+		transport.Record{Schema: "public", Table: "notes", Label: "delete", Time: base.Add(2 * time.Minute),
+			Payload: map[string]interface{}{"id": 14, "name": "user1", "note": "changed"}},
+	}))
+
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "data.db"))
+	require.Nil(t, err)
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	_, err = db.Exec(`ATTACH DATABASE ? AS public`, filepath.Join(dir, "public.db"))
+	require.Nil(t, err)
+	_, err = db.Exec(`CREATE TABLE public.notes (id INTEGER PRIMARY KEY, name TEXT, note TEXT)`)
+	require.Nil(t, err)
+
+	return NewRestorer(source, db), db
+}
+
+func TestVersionsAndDiff(t *testing.T) {
+	r, _ := newTestRestorer(t)
+
+	versions, err := r.Versions("public", "notes", "14")
+	require.Nil(t, err)
+	require.Len(t, versions, 3)
+	require.Equal(t, "update", versions[1].Op)
+	require.Equal(t, "bob", versions[1].Actor)
+	require.True(t, versions[2].Deleted)
+
+	diff, err := r.Diff("public", "notes", "14", 1, 2)
+	require.Nil(t, err)
+	require.Equal(t, map[string]FieldDiff{"note": {From: "it's a note", To: "changed"}}, diff.Fields)
+
+	diff, err = r.Diff("public", "notes", "14", 2, 3)
+	require.Nil(t, err)
+	require.True(t, diff.ToDeleted)
+	require.Len(t, diff.Fields, 3)
+
+	_, err = r.Diff("public", "notes", "14", 1, 4)
+	require.ErrorIs(t, err, ErrVersionRange)
+
+	_, err = r.Versions("public", "notes", "15")
+	require.Nil(t, err)
+	_, err = r.Plan("public", "notes", "15", 1)
+	require.ErrorIs(t, err, ErrNoVersion)
+}
+
+func TestRestoreDeletedRow(t *testing.T) {
+	r, db := newTestRestorer(t)
+
+	statements, err := r.Restore(context.Background(), "public", "notes", "14", 1, false)
+	require.Nil(t, err)
+	require.Len(t, statements, 1)
+	require.Equal(t, `INSERT INTO "public"."notes" ("id", "name", "note") VALUES (14, 'user1', 'it''s a note');`, statements[0].String())
+
+	// 未确认时不执行
+	var count int
+	require.Nil(t, db.QueryRow(`SELECT count(*) FROM public.notes`).Scan(&count))
+	require.Zero(t, count)
+
+	_, err = r.Restore(context.Background(), "public", "notes", "14", 1, true)
+	require.Nil(t, err)
+
+	var note string
+	require.Nil(t, db.QueryRow(`SELECT note FROM public.notes WHERE id = 14`).Scan(&note))
+	require.Equal(t, "it's a note", note)
+
+	// 目标为删除状态且当前已经删除
+	statements, err = r.Plan("public", "notes", "14", 3)
+	require.Nil(t, err)
+	require.Empty(t, statements)
+}
+
+func TestPlanUpdateAndDelete(t *testing.T) {
+	r, _ := newTestRestorer(t)
+
+	// 追加一次重新插入，当前状态为 note=changed
+	source := r.source
+	require.Nil(t, source.Save(transport.Record{Schema: "public", Table: "notes", Label: "insert",
+		Time:    time.Date(2022, 7, 1, 9, 3, 0, 0, time.UTC),
+		Payload: map[string]interface{}{"id": 14, "name": "user1", "note": "changed"}}))
+
+	statements, err := r.Plan("public", "notes", "14", 1)
+	require.Nil(t, err)
+	require.Equal(t, `UPDATE "public"."notes" SET "note" = 'it''s a note' WHERE "id" = '14';`, statements[0].String())
+	require.Equal(t, []interface{}{"it's a note", "14"}, statements[0].Args)
+
+	statements, err = r.Plan("public", "notes", "14", 3)
+	require.Nil(t, err)
+	require.Equal(t, `DELETE FROM "public"."notes" WHERE "id" = '14';`, statements[0].String())
+
+	statements, err = r.Plan("public", "notes", "14", 2)
+	require.Nil(t, err)
+	require.Empty(t, statements)
+}
+
+func TestRenderPlaceholders(t *testing.T) {
+	// 替换后的字面量中的$1以及引号中的内容不会再被替换
+	statement := newStatement(`UPDATE "public"."a$1" SET "note" = $2, "name" = $10 WHERE "id" = $1`,
+		"14", "costs $1", nil, nil, nil, nil, nil, nil, nil, "$2")
+	require.Equal(t, `UPDATE "public"."a$1" SET "note" = 'costs $1', "name" = '$2' WHERE "id" = '14';`, statement.String())
+}
@@ -0,0 +1,197 @@
+package transport
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	ErrQueueFull        = errors.New("transport queue is full")
+	ErrDispatcherClosed = errors.New("dispatcher is closed")
+)
+
+// ErrorHandler 存储端保存失败或者队列已满时调用
+type ErrorHandler func(name string, logs []ILogData, err error)
+
+type DispatcherOptions struct {
+	BufferSize int          // 每个存储端的队列长度，默认1024
+	BatchSize  int          // 每次SaveBatch的最大条数，默认100
+	Block      bool         // 队列已满时阻塞等待，Close时放弃等待，默认丢弃并调用OnError
+	OnError    ErrorHandler // 为空时忽略错误
+}
+
+// SinkStats 每个存储端独立的处理进度
+type SinkStats struct {
+	Delivered uint64
+	Failed    uint64
+	Dropped   uint64
+}
+
+type sinkWorker struct {
+	name      string
+	transport Transport
+	queue     chan ILogData
+
+	delivered uint64
+	failed    uint64
+	dropped   uint64
+}
+
+// Dispatcher 将每条日志分发到全部存储端，每个存储端使用独立的队列和goroutine
+// 某个存储端变慢或者出错不会影响其他存储端
+type Dispatcher struct {
+	options DispatcherOptions
+	workers []*sinkWorker
+
+	mu        sync.RWMutex
+	closed    bool
+	done      chan struct{} // Close时关闭，唤醒阻塞在队列上的Dispatch
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+func NewDispatcher(transports map[string]Transport, options DispatcherOptions) *Dispatcher {
+	if options.BufferSize <= 0 {
+		options.BufferSize = 1024
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = 100
+	}
+
+	names := make([]string, 0, len(transports))
+	for name := range transports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	d := &Dispatcher{options: options, done: make(chan struct{})}
+	for _, name := range names {
+		w := &sinkWorker{
+			name:      name,
+			transport: transports[name],
+			queue:     make(chan ILogData, options.BufferSize),
+		}
+		d.workers = append(d.workers, w)
+
+		d.wg.Add(1)
+		go d.run(w)
+	}
+	return d
+}
+
+// Dispatch 将日志放入每个存储端的队列
+func (d *Dispatcher) Dispatch(log ILogData) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.closed {
+		return ErrDispatcherClosed
+	}
+
+	for _, w := range d.workers {
+		if d.options.Block {
+			select {
+			case w.queue <- log:
+			case <-d.done:
+				// 持有读锁阻塞时Close无法获取写锁，放弃剩余的存储端
+				atomic.AddUint64(&w.dropped, 1)
+				d.handleError(w.name, []ILogData{log}, ErrDispatcherClosed)
+				return ErrDispatcherClosed
+			}
+			continue
+		}
+
+		select {
+		case w.queue <- log:
+		default:
+			atomic.AddUint64(&w.dropped, 1)
+			d.handleError(w.name, []ILogData{log}, ErrQueueFull)
+		}
+	}
+	return nil
+}
+
+// Transport 根据名字获取存储端
+func (d *Dispatcher) Transport(name string) (Transport, bool) {
+	for _, w := range d.workers {
+		if w.name == name {
+			return w.transport, true
+		}
+	}
+	return nil, false
+}
+
+func (d *Dispatcher) Stats() map[string]SinkStats {
+	res := make(map[string]SinkStats, len(d.workers))
+	for _, w := range d.workers {
+		res[w.name] = SinkStats{
+			Delivered: atomic.LoadUint64(&w.delivered),
+			Failed:    atomic.LoadUint64(&w.failed),
+			Dropped:   atomic.LoadUint64(&w.dropped),
+		}
+	}
+	return res
+}
+
+// Close 等待队列中的日志处理完成后关闭全部存储端
+func (d *Dispatcher) Close() error {
+	d.closeOnce.Do(func() { close(d.done) })
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil
+	}
+	d.closed = true
+	for _, w := range d.workers {
+		close(w.queue)
+	}
+	d.mu.Unlock()
+
+	d.wg.Wait()
+
+	var res error
+	for _, w := range d.workers {
+		if err := w.transport.Close(); err != nil && res == nil {
+			res = err
+		}
+	}
+	return res
+}
+
+// run 每次取出队列中已有的日志批量保存
+func (d *Dispatcher) run(w *sinkWorker) {
+	defer d.wg.Done()
+
+	batch := make([]ILogData, 0, d.options.BatchSize)
+	for log := range w.queue {
+		batch = append(batch[:0], log)
+	drain:
+		for len(batch) < d.options.BatchSize {
+			select {
+			case log, ok := <-w.queue:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, log)
+			default:
+				break drain
+			}
+		}
+
+		logs := append([]ILogData(nil), batch...)
+		if err := w.transport.SaveBatch(logs); err != nil {
+			atomic.AddUint64(&w.failed, uint64(len(logs)))
+			d.handleError(w.name, logs, err)
+		} else {
+			atomic.AddUint64(&w.delivered, uint64(len(logs)))
+		}
+	}
+}
+
+func (d *Dispatcher) handleError(name string, logs []ILogData, err error) {
+	if d.options.OnError != nil {
+		d.options.OnError(name, logs, err)
+	}
+}
@@ -0,0 +1,316 @@
+package file
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wwqdrh/datamanager/transport"
+)
+
+var _ transport.Transport = &FileTransport{}
+
+func init() {
+	transport.Register("file", func(options map[string]string) (transport.Transport, error) {
+		opts, err := ParseOptions(options)
+		if err != nil {
+			return nil, err
+		}
+		return NewFileTransport(opts)
+	})
+}
+
+// SyncPolicy 写入后调用fsync的时机
+type SyncPolicy string
+
+const (
+	SyncNone     SyncPolicy = "none"     // 由操作系统决定
+	SyncAlways   SyncPolicy = "always"   // 每条记录之后
+	SyncBatch    SyncPolicy = "batch"    // 每次SaveBatch之后
+	SyncInterval SyncPolicy = "interval" // 每隔SyncInterval
+)
+
+var ErrSyncPolicy = errors.New("unsupported sync policy")
+
+// DefaultMaxOpenFiles 按照数据表拆分时同时打开的文件数
+var DefaultMaxOpenFiles = 256
+
+type Options struct {
+	Dir          string // 存储目录
+	Filename     string // 不按照数据表拆分时的文件名，默认history.jsonl
+	SplitByTable bool   // 按照数据表拆分为 <dir>/<schema>/<table>.jsonl
+
+	MaxSize        int           // 单个文件的最大大小，单位MB，默认100
+	MaxBackups     int           // 保留的轮转文件数量，0表示全部保留
+	MaxAge         int           // 轮转文件保留的天数，0表示不删除
+	Compress       bool          // 使用gzip压缩轮转的文件
+	LocalTime      bool          // 轮转文件名使用本地时间
+	RotateInterval time.Duration // 按照时间轮转，例如24h，0表示只按照大小轮转
+	MaxOpenFiles   int           // 同时打开的文件数，超过时关闭最久没有写入的文件，默认DefaultMaxOpenFiles
+
+	Sync         SyncPolicy
+	SyncInterval time.Duration // Sync为interval时使用，默认1s
+
+	PruneInterval time.Duration // 清理轮转文件中过期记录的间隔，默认DefaultPruneInterval，小于0时不清理
+}
+
+// ParseOptions 从registry的配置项中解析
+// dir filename split max_size max_backups max_age max_open compress local_time rotate sync sync_interval prune
+func ParseOptions(options map[string]string) (Options, error) {
+	opts := Options{
+		Dir:      options["dir"],
+		Filename: options["filename"],
+		Sync:     SyncPolicy(options["sync"]),
+	}
+
+	var err error
+	for key, target := range map[string]*bool{"split": &opts.SplitByTable, "compress": &opts.Compress, "local_time": &opts.LocalTime} {
+		if v, ok := options[key]; ok {
+			if *target, err = strconv.ParseBool(v); err != nil {
+				return opts, fmt.Errorf("file transport: invalid %s: %w", key, err)
+			}
+		}
+	}
+	for key, target := range map[string]*int{"max_size": &opts.MaxSize, "max_backups": &opts.MaxBackups, "max_age": &opts.MaxAge, "max_open": &opts.MaxOpenFiles} {
+		if v, ok := options[key]; ok {
+			if *target, err = strconv.Atoi(v); err != nil {
+				return opts, fmt.Errorf("file transport: invalid %s: %w", key, err)
+			}
+		}
+	}
+	for key, target := range map[string]*time.Duration{"rotate": &opts.RotateInterval, "sync_interval": &opts.SyncInterval, "prune": &opts.PruneInterval} {
+		if v, ok := options[key]; ok {
+			if *target, err = time.ParseDuration(v); err != nil {
+				return opts, fmt.Errorf("file transport: invalid %s: %w", key, err)
+			}
+		}
+	}
+	return opts, nil
+}
+
+// FileTransport 将日志以json lines格式写入文件，支持轮转、压缩以及按照数据表拆分
+type FileTransport struct {
+	options Options
+
+	mu    sync.Mutex
+	files map[string]*list.Element // filename => *rotateFile，按照最近写入的顺序
+	lru   *list.List
+	now   func() time.Time
+	mill  sync.WaitGroup
+
+	pruneMu   sync.Mutex
+	pruneNext map[string]time.Time // 轮转文件 => 剩余记录中最早的过期时间
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+func NewFileTransport(options Options) (*FileTransport, error) {
+	if options.Dir == "" {
+		options.Dir = "."
+	}
+	if options.Filename == "" {
+		options.Filename = "history.jsonl"
+	}
+	if options.MaxSize <= 0 {
+		options.MaxSize = 100
+	}
+	switch options.Sync {
+	case "":
+		options.Sync = SyncNone
+	case SyncNone, SyncAlways, SyncBatch:
+	case SyncInterval:
+		if options.SyncInterval <= 0 {
+			options.SyncInterval = time.Second
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrSyncPolicy, options.Sync)
+	}
+	if options.MaxOpenFiles <= 0 {
+		options.MaxOpenFiles = DefaultMaxOpenFiles
+	}
+	if options.PruneInterval == 0 {
+		options.PruneInterval = DefaultPruneInterval
+	}
+	if err := os.MkdirAll(options.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	t := &FileTransport{
+		options:   options,
+		files:     map[string]*list.Element{},
+		lru:       list.New(),
+		now:       time.Now,
+		pruneNext: map[string]time.Time{},
+		done:      make(chan struct{}),
+	}
+	if options.Sync == SyncInterval {
+		t.wg.Add(1)
+		go t.syncLoop()
+	}
+	if options.PruneInterval > 0 {
+		t.wg.Add(1)
+		go t.pruneLoop()
+	}
+	return t, nil
+}
+
+func (t *FileTransport) Save(log transport.ILogData) error {
+	return t.SaveBatch([]transport.ILogData{log})
+}
+
+func (t *FileTransport) SaveBatch(logs []transport.ILogData) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, log := range logs {
+		data, err := json.Marshal(transport.NewRecord(log))
+		if err != nil {
+			return err
+		}
+
+		f, err := t.file(log)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(append(data, '\n')); err != nil {
+			return err
+		}
+
+		if t.options.Sync == SyncAlways {
+			if err := f.Sync(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if t.options.Sync == SyncBatch {
+		return t.syncAll()
+	}
+	return nil
+}
+
+func (t *FileTransport) Query(transport.Query) ([]transport.Record, error) {
+	return nil, transport.ErrNotSupported
+}
+
+func (t *FileTransport) Close() error {
+	t.closeOnce.Do(func() { close(t.done) })
+	t.wg.Wait()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var res error
+	if t.options.Sync != SyncNone {
+		res = t.syncAll()
+	}
+	for name, e := range t.files {
+		if err := e.Value.(*rotateFile).Close(); err != nil && res == nil {
+			res = err
+		}
+		delete(t.files, name)
+	}
+	t.lru.Init()
+	t.mill.Wait()
+	return res
+}
+
+// file 获取日志对应的文件，时间轮转周期变化时轮转
+func (t *FileTransport) file(log transport.ILogData) (*rotateFile, error) {
+	name := filepath.Join(t.options.Dir, t.options.Filename)
+	if t.options.SplitByTable {
+		name = filepath.Join(t.options.Dir, sanitize(log.GetSchema()), sanitize(log.GetTable())+".jsonl")
+	}
+
+	var f *rotateFile
+	if e, ok := t.files[name]; ok {
+		t.lru.MoveToFront(e)
+		f = e.Value.(*rotateFile)
+	} else {
+		if err := t.evict(t.options.MaxOpenFiles - 1); err != nil {
+			return nil, err
+		}
+		f = &rotateFile{filename: name, options: &t.options, mill: &t.mill, period: t.period()}
+		if err := f.open(); err != nil {
+			return nil, err
+		}
+		t.files[name] = t.lru.PushFront(f)
+	}
+
+	if period := t.period(); !period.Equal(f.period) {
+		if err := f.Rotate(); err != nil {
+			return nil, err
+		}
+		f.period = period
+	}
+	return f, nil
+}
+
+// evict 关闭最久没有写入的文件，直到打开的文件数不超过max，需要刷盘时关闭之前先刷盘
+func (t *FileTransport) evict(max int) error {
+	for t.lru.Len() > max {
+		e := t.lru.Back()
+		f := e.Value.(*rotateFile)
+		if t.options.Sync != SyncNone {
+			if err := f.Sync(); err != nil {
+				return err
+			}
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		t.lru.Remove(e)
+		delete(t.files, f.filename)
+	}
+	return nil
+}
+
+func (t *FileTransport) period() time.Time {
+	if t.options.RotateInterval <= 0 {
+		return time.Time{}
+	}
+	return t.now().Truncate(t.options.RotateInterval)
+}
+
+func (t *FileTransport) syncAll() error {
+	for _, e := range t.files {
+		if err := e.Value.(*rotateFile).Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *FileTransport) syncLoop() {
+	defer t.wg.Done()
+
+	ticker := time.NewTicker(t.options.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.mu.Lock()
+			t.syncAll()
+			t.mu.Unlock()
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// sanitize 避免schema、table中的路径分隔符
+func sanitize(name string) string {
+	if name == "" {
+		return "_"
+	}
+	return strings.NewReplacer("/", "_", `\`, "_", "..", "_").Replace(name)
+}
@@ -0,0 +1,194 @@
+package file
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/wwqdrh/datamanager/transport"
+)
+
+func newTestRecord(schema, table string, id int) transport.Record {
+	return transport.Record{
+		Schema:  schema,
+		Table:   table,
+		Label:   "insert",
+		Time:    time.Now(),
+		Payload: map[string]interface{}{"id": id},
+	}
+}
+
+func readRecords(t *testing.T, name string) []transport.Record {
+	f, err := os.Open(name)
+	require.Nil(t, err)
+	defer f.Close()
+
+	res := []transport.Record{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 4*1024*1024)
+	for scanner.Scan() {
+		var r transport.Record
+		require.Nil(t, json.Unmarshal(scanner.Bytes(), &r))
+		res = append(res, r)
+	}
+	return res
+}
+
+func TestParseOptions(t *testing.T) {
+	opts, err := ParseOptions(map[string]string{
+		"dir": "/tmp/history", "split": "true", "max_size": "10", "compress": "1",
+		"rotate": "24h", "sync": "interval", "sync_interval": "5s",
+	})
+	require.Nil(t, err)
+	require.Equal(t, Options{
+		Dir: "/tmp/history", SplitByTable: true, MaxSize: 10, Compress: true,
+		RotateInterval: 24 * time.Hour, Sync: SyncInterval, SyncInterval: 5 * time.Second,
+	}, opts)
+
+	_, err = ParseOptions(map[string]string{"max_size": "big"})
+	require.NotNil(t, err)
+
+	_, err = NewFileTransport(Options{Dir: t.TempDir(), Sync: "sometimes"})
+	require.ErrorIs(t, err, ErrSyncPolicy)
+}
+
+func TestFileTransportSplitByTable(t *testing.T) {
+	dir := t.TempDir()
+	f, err := NewFileTransport(Options{Dir: dir, SplitByTable: true, Sync: SyncBatch})
+	require.Nil(t, err)
+
+	require.Nil(t, f.SaveBatch([]transport.ILogData{
+		newTestRecord("public", "notes", 1),
+		newTestRecord("public", "users", 2),
+		newTestRecord("public", "notes", 3),
+	}))
+	require.Nil(t, f.Save(newTestRecord("../etc", "passwd", 4)))
+	require.Nil(t, f.Close())
+
+	notes := readRecords(t, filepath.Join(dir, "public", "notes.jsonl"))
+	require.Len(t, notes, 2)
+	require.Equal(t, float64(3), notes[1].Payload["id"])
+	require.Len(t, readRecords(t, filepath.Join(dir, "public", "users.jsonl")), 1)
+	require.Len(t, readRecords(t, filepath.Join(dir, "__etc", "passwd.jsonl")), 1)
+}
+
+func TestFileTransportRotateInterval(t *testing.T) {
+	dir := t.TempDir()
+	f, err := NewFileTransport(Options{Dir: dir, RotateInterval: time.Hour, Sync: SyncAlways})
+	require.Nil(t, err)
+	defer f.Close()
+
+	now := time.Date(2022, 7, 1, 9, 30, 0, 0, time.UTC)
+	f.now = func() time.Time { return now }
+	require.Nil(t, f.Save(newTestRecord("public", "notes", 1)))
+	require.Nil(t, f.Save(newTestRecord("public", "notes", 2)))
+
+	now = now.Add(time.Hour)
+	require.Nil(t, f.Save(newTestRecord("public", "notes", 3)))
+
+	matches, err := filepath.Glob(filepath.Join(dir, "history-*.jsonl"))
+	require.Nil(t, err)
+	require.Len(t, matches, 1)
+	require.Len(t, readRecords(t, matches[0]), 2)
+
+	current := readRecords(t, filepath.Join(dir, "history.jsonl"))
+	require.Len(t, current, 1)
+	require.Equal(t, float64(3), current[0].Payload["id"])
+}
+
+func TestFileTransportPrune(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		dir := t.TempDir()
+		f, err := NewFileTransport(Options{Dir: dir, RotateInterval: time.Hour, Compress: compress, Sync: SyncBatch})
+		require.Nil(t, err)
+
+		now := time.Date(2022, 7, 1, 9, 30, 0, 0, time.UTC)
+		f.now = func() time.Time { return now }
+		expired, later := now.Add(time.Minute), now.Add(48*time.Hour)
+		for i, expireAt := range []*time.Time{&expired, &later, nil} {
+			r := newTestRecord("public", "notes", i)
+			r.ExpireAt = expireAt
+			require.Nil(t, f.Save(r))
+		}
+		now = now.Add(time.Hour)
+		r := newTestRecord("public", "notes", 3)
+		r.ExpireAt = &expired
+		require.Nil(t, f.Save(r))
+		require.Nil(t, f.Close())
+
+		pattern := filepath.Join(dir, "history-*.jsonl")
+		if compress {
+			pattern += ".gz"
+			// 等待后台压缩
+			require.Eventually(t, func() bool {
+				matches, _ := filepath.Glob(pattern)
+				return len(matches) == 1
+			}, time.Second, 10*time.Millisecond)
+		}
+
+		count, err := f.Prune()
+		require.Nil(t, err)
+		require.Equal(t, 1, count)
+
+		matches, err := filepath.Glob(pattern)
+		require.Nil(t, err)
+		require.Len(t, matches, 1)
+		lines, err := readLines(matches[0])
+		require.Nil(t, err)
+		require.Len(t, lines, 2)
+
+		// 只保留没有过期时间的记录，正在写入的文件不清理
+		now = now.Add(72 * time.Hour)
+		count, err = f.Prune()
+		require.Nil(t, err)
+		require.Equal(t, 1, count)
+		lines, err = readLines(matches[0])
+		require.Nil(t, err)
+		require.Len(t, lines, 1)
+		require.Len(t, readRecords(t, filepath.Join(dir, "history.jsonl")), 1)
+	}
+}
+
+func TestFileTransportMaxOpenFiles(t *testing.T) {
+	dir := t.TempDir()
+	f, err := NewFileTransport(Options{Dir: dir, SplitByTable: true, MaxOpenFiles: 2, Sync: SyncBatch})
+	require.Nil(t, err)
+
+	for i, table := range []string{"a", "b", "c", "a"} {
+		require.Nil(t, f.Save(newTestRecord("public", table, i)))
+		require.LessOrEqual(t, len(f.files), 2)
+	}
+	_, ok := f.files[filepath.Join(dir, "public", "b.jsonl")]
+	require.False(t, ok)
+	require.Nil(t, f.Close())
+
+	// 关闭之后重新打开时追加写入
+	require.Len(t, readRecords(t, filepath.Join(dir, "public", "a.jsonl")), 2)
+	require.Len(t, readRecords(t, filepath.Join(dir, "public", "b.jsonl")), 1)
+}
+
+func TestFileTransportRotateSize(t *testing.T) {
+	dir := t.TempDir()
+	f, err := NewFileTransport(Options{Dir: dir, MaxSize: 1, MaxBackups: 1, Sync: SyncAlways})
+	require.Nil(t, err)
+
+	large := strings.Repeat("x", 600*1024)
+	for i := 0; i < 3; i++ {
+		r := newTestRecord("public", "notes", i)
+		r.Payload["data"] = large
+		require.Nil(t, f.Save(r))
+	}
+	// Close等待后台清理
+	require.Nil(t, f.Close())
+
+	matches, err := filepath.Glob(filepath.Join(dir, "history-*.jsonl"))
+	require.Nil(t, err)
+	require.Len(t, matches, 1)
+	require.Equal(t, float64(1), readRecords(t, matches[0])[0].Payload["id"])
+	require.Equal(t, float64(2), readRecords(t, filepath.Join(dir, "history.jsonl"))[0].Payload["id"])
+}
@@ -0,0 +1,202 @@
+package file
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 记录中带有expire_at时(例如redis策略的HistoryTTL)，定期重写轮转的文件，删除已经过期的记录，全部过期时删除文件
+// 正在写入的文件在轮转之后才会清理；开启压缩时只处理已经压缩的文件，避免与后台的压缩同时修改
+
+// DefaultPruneInterval 清理轮转文件的默认间隔
+var DefaultPruneInterval = time.Hour
+
+// 轮转文件名中的时间，与lumberjack一致
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// Prune 清理目录中全部轮转文件内过期的记录，返回删除的记录数
+func (t *FileTransport) Prune() (int, error) {
+	t.pruneMu.Lock()
+	defer t.pruneMu.Unlock()
+
+	now := t.now()
+	seen := map[string]bool{}
+	count := 0
+	err := filepath.WalkDir(t.options.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || !t.isBackup(d.Name()) {
+			return nil
+		}
+		seen[path] = true
+		if next, ok := t.pruneNext[path]; ok && (next.IsZero() || next.After(now)) {
+			return nil
+		}
+
+		n, next, err := pruneBackup(path, now)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		count += n
+		t.pruneNext[path] = next
+		return nil
+	})
+	for path := range t.pruneNext {
+		if !seen[path] {
+			delete(t.pruneNext, path)
+		}
+	}
+	return count, err
+}
+
+// isBackup 轮转的文件，<name>-<time><ext>[.gz]
+func (t *FileTransport) isBackup(name string) bool {
+	if t.options.Compress {
+		if !strings.HasSuffix(name, ".gz") {
+			return false
+		}
+		name = strings.TrimSuffix(name, ".gz")
+	}
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	if len(name) <= len(backupTimeFormat) || name[len(name)-len(backupTimeFormat)-1] != '-' {
+		return false
+	}
+	_, err := time.Parse(backupTimeFormat, name[len(name)-len(backupTimeFormat):])
+	return err == nil
+}
+
+// pruneBackup 删除过期的记录，返回删除的记录数以及剩余记录中最早的过期时间，没有会过期的记录时为零值
+func pruneBackup(path string, now time.Time) (int, time.Time, error) {
+	lines, err := readLines(path)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	var (
+		kept  = make([][]byte, 0, len(lines))
+		next  time.Time
+		count int
+	)
+	for _, line := range lines {
+		var record struct {
+			ExpireAt *time.Time `json:"expire_at"`
+		}
+		// 无法解析的行保留
+		if json.Unmarshal(line, &record) != nil || record.ExpireAt == nil {
+			kept = append(kept, line)
+			continue
+		}
+		if !record.ExpireAt.After(now) {
+			count++
+			continue
+		}
+		if next.IsZero() || record.ExpireAt.Before(next) {
+			next = *record.ExpireAt
+		}
+		kept = append(kept, line)
+	}
+
+	switch {
+	case count == 0:
+		return 0, next, nil
+	case len(kept) == 0:
+		return count, time.Time{}, os.Remove(path)
+	default:
+		return count, next, writeLines(path, kept)
+	}
+}
+
+func readLines(path string) ([][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	lines := [][]byte{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, append([]byte(nil), scanner.Bytes()...))
+	}
+	return lines, scanner.Err()
+}
+
+// writeLines 写入临时文件之后替换
+func writeLines(path string, lines [][]byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".prune-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	var (
+		w  io.Writer = tmp
+		gz *gzip.Writer
+	)
+	if strings.HasSuffix(path, ".gz") {
+		gz = gzip.NewWriter(tmp)
+		w = gz
+	}
+	bw := bufio.NewWriter(w)
+	for _, line := range lines {
+		bw.Write(line)
+		bw.WriteByte('\n')
+	}
+	err = bw.Flush()
+	if gz != nil && err == nil {
+		err = gz.Close()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(path); err == nil {
+		os.Chmod(tmp.Name(), info.Mode())
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (t *FileTransport) pruneLoop() {
+	defer t.wg.Done()
+
+	ticker := time.NewTicker(t.options.PruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.Prune()
+		case <-t.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package file
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotateFile 按照大小以及时间轮转的文件，轮转的文件名与lumberjack一致: <name>-<time><ext>[.gz]
+// lumberjack不暴露写入的文件句柄，无法fsync，这里持有句柄，fsync作用于正在写入的文件，轮转之前先刷盘
+type rotateFile struct {
+	filename string
+	options  *Options
+	mill     *sync.WaitGroup // 后台压缩以及清理，Close时等待
+	millMu   sync.Mutex      // 同一个文件的清理依次执行
+
+	file   *os.File
+	size   int64
+	period time.Time
+	dirty  bool
+}
+
+func (f *rotateFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.filename), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+func (f *rotateFile) Write(p []byte) (int, error) {
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	if max := int64(f.options.MaxSize) * 1024 * 1024; f.size > 0 && f.size+int64(len(p)) > max {
+		if err := f.Rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	f.dirty = true
+	return n, err
+}
+
+// Sync 对写入的文件句柄调用fsync
+func (f *rotateFile) Sync() error {
+	if f.file == nil || !f.dirty {
+		return nil
+	}
+	if err := f.file.Sync(); err != nil {
+		return err
+	}
+	f.dirty = false
+	return nil
+}
+
+// Rotate 当前的文件刷盘之后重命名为轮转文件，然后在后台压缩并清理
+func (f *rotateFile) Rotate() error {
+	if f.file != nil {
+		if err := f.Sync(); err != nil {
+			return err
+		}
+		if err := f.file.Close(); err != nil {
+			return err
+		}
+		f.file = nil
+	}
+
+	if info, err := os.Stat(f.filename); err == nil && info.Size() > 0 {
+		if err := os.Rename(f.filename, f.backupName()); err != nil {
+			return err
+		}
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+
+	f.mill.Add(1)
+	go func() {
+		defer f.mill.Done()
+		f.millRun()
+	}()
+	return nil
+}
+
+func (f *rotateFile) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// backupName 同一毫秒内多次轮转时顺延
+func (f *rotateFile) backupName() string {
+	dir, base := filepath.Split(f.filename)
+	ext := filepath.Ext(base)
+	prefix := strings.TrimSuffix(base, ext) + "-"
+
+	t := time.Now()
+	for {
+		if !f.options.LocalTime {
+			t = t.UTC()
+		}
+		name := filepath.Join(dir, prefix+t.Format(backupTimeFormat)+ext)
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			if _, err := os.Stat(name + ".gz"); os.IsNotExist(err) {
+				return name
+			}
+		}
+		t = t.Add(time.Millisecond)
+	}
+}
+
+type backupFile struct {
+	path string
+	time time.Time
+}
+
+// backups 当前文件的轮转文件，按照时间从新到旧
+func (f *rotateFile) backups() ([]backupFile, error) {
+	dir, base := filepath.Split(f.filename)
+	ext := filepath.Ext(base)
+	prefix := strings.TrimSuffix(base, ext) + "-"
+
+	entries, err := os.ReadDir(filepath.Clean(dir))
+	if err != nil {
+		return nil, err
+	}
+	res := []backupFile{}
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), ".gz")
+		if e.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		t, err := time.Parse(backupTimeFormat, strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext))
+		if err != nil {
+			continue
+		}
+		res = append(res, backupFile{path: filepath.Join(dir, e.Name()), time: t})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].time.After(res[j].time) })
+	return res, nil
+}
+
+// millRun 按照MaxBackups以及MaxAge删除轮转文件，压缩剩余的文件
+func (f *rotateFile) millRun() {
+	f.millMu.Lock()
+	defer f.millMu.Unlock()
+
+	backups, err := f.backups()
+	if err != nil {
+		return
+	}
+
+	var (
+		cutoff = time.Now().Add(-time.Duration(f.options.MaxAge) * 24 * time.Hour)
+		kept   = map[time.Time]bool{}
+	)
+	for _, b := range backups {
+		// 同一时间的压缩文件以及未压缩文件算作一个
+		remove := f.options.MaxAge > 0 && b.time.Before(cutoff)
+		if !kept[b.time] && f.options.MaxBackups > 0 && len(kept) >= f.options.MaxBackups {
+			remove = true
+		}
+		if remove {
+			os.Remove(b.path)
+			continue
+		}
+		kept[b.time] = true
+
+		if f.options.Compress && !strings.HasSuffix(b.path, ".gz") {
+			compressFile(b.path)
+		}
+	}
+}
+
+// compressFile 写入临时文件之后重命名为.gz，再删除原来的文件
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".compress-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	gz := gzip.NewWriter(tmp)
+	_, err = io.Copy(gz, src)
+	if err == nil {
+		err = gz.Close()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
@@ -1,17 +1,66 @@
 package plain
 
 import (
-	"errors"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/wwqdrh/datamanager/transport"
 )
 
+var _ transport.Transport = &PlainTransport{}
+
+func init() {
+	transport.Register("plain", func(map[string]string) (transport.Transport, error) {
+		return new(PlainTransport), nil
+	})
+}
+
+// PlainTransport 将日志以json格式逐行输出，Writer为空时输出到stdout
 type PlainTransport struct {
+	Writer io.Writer
+
+	mu sync.Mutex
+}
+
+func (p *PlainTransport) Save(log transport.ILogData) error {
+	data, err := json.Marshal(transport.NewRecord(log))
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	w := p.Writer
+	if w == nil {
+		w = os.Stdout
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
+
+func (p *PlainTransport) SaveBatch(logs []transport.ILogData) error {
+	for _, log := range logs {
+		if err := p.Save(log); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *PlainTransport) Query(transport.Query) ([]transport.Record, error) {
+	return nil, transport.ErrNotSupported
 }
 
-func (p *PlainTransport) Save(log string) {
-	fmt.Println(log)
+// Load 输出的日志无法读取，不支持回放
+func (p *PlainTransport) Load(context.Context, transport.Query, transport.ReplayOptions, transport.ReplayFunc) (transport.ReplayStats, error) {
+	return transport.ReplayStats{}, transport.ErrNotSupported
 }
 
-func (p *PlainTransport) Load(string) ([]string, error) {
-	return nil, errors.New("plain transport no implete this")
+func (p *PlainTransport) Close() error {
+	return nil
 }
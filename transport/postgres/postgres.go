@@ -0,0 +1,339 @@
+package postgres
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+	"github.com/wwqdrh/datamanager/transport"
+)
+
+var _ transport.Transport = &PostgresTransport{}
+
+func init() {
+	transport.Register("postgres", func(options map[string]string) (transport.Transport, error) {
+		if options["dsn"] == "" {
+			return nil, errors.New("postgres transport: dsn is required")
+		}
+
+		opts := Options{HistorySchema: options["schema"]}
+		if v := options["in_place"]; v == "true" || v == "1" {
+			opts.InPlace = true
+		}
+		return NewPostgresTransport(options["dsn"], opts)
+	})
+}
+
+var (
+	sqlCreateSchema = `CREATE SCHEMA IF NOT EXISTS %s`
+
+	// 每个数据表一张历史表，valid_to为空表示当前版本
+	// 删除的记录valid_from与valid_to相同，只用于记录删除的操作人以及删除前的数据
+	sqlCreateTable = `
+CREATE TABLE IF NOT EXISTS %[1]s (
+    history_id  BIGSERIAL PRIMARY KEY,
+    record_id   TEXT NOT NULL DEFAULT '',
+    op          TEXT NOT NULL,
+    valid_from  TIMESTAMPTZ NOT NULL,
+    valid_to    TIMESTAMPTZ,
+    actor       TEXT NOT NULL DEFAULT '',
+    tx_id       TEXT NOT NULL DEFAULT '',
+    row_data    JSONB NOT NULL DEFAULT '{}',
+    changes     JSONB NOT NULL DEFAULT '{}'
+);
+CREATE INDEX IF NOT EXISTS %[2]s ON %[1]s (record_id, valid_from);
+CREATE INDEX IF NOT EXISTS %[3]s ON %[1]s (valid_from, valid_to);
+`
+
+	// 关闭记录当前的版本
+	sqlCloseVersion = `UPDATE %s SET valid_to = $1 WHERE record_id = $2 AND valid_to IS NULL AND valid_from <= $1`
+
+	// truncate关闭全部当前的版本
+	sqlCloseAll = `UPDATE %s SET valid_to = $1 WHERE valid_to IS NULL AND valid_from <= $1`
+
+	sqlInsertVersion = `
+INSERT INTO %s (record_id, op, valid_from, valid_to, actor, tx_id, row_data, changes)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+`
+
+	sqlQueryVersion = `
+SELECT record_id, op, valid_from, actor, tx_id, row_data, changes FROM %s%s
+ORDER BY valid_from %s, history_id %s LIMIT %s OFFSET %d
+`
+)
+
+// postgres标识符的最大长度
+const maxIdentLength = 63
+
+type Options struct {
+	// HistorySchema 历史表所在的schema，默认history，历史表名为<schema>.<table>，名称中的.以及%使用%2E、%25转义
+	HistorySchema string
+	// InPlace 历史表与数据表在同一个schema中，表名为<table>_history
+	InPlace bool
+}
+
+// PostgresTransport 在postgres中为每个数据表维护一张系统版本的历史表
+// 可以直接使用sql与当前的数据表关联查询，例如查询某个时间点的数据
+//
+//	SELECT * FROM history."public.notes"
+//	WHERE valid_from <= '2022-07-01 09:00' AND (valid_to IS NULL OR valid_to > '2022-07-01 09:00')
+type PostgresTransport struct {
+	db      *sql.DB
+	options Options
+
+	mu     sync.Mutex
+	tables map[string]bool // 已经创建的历史表
+}
+
+func NewPostgresTransport(dsn string, options Options) (*PostgresTransport, error) {
+	if options.HistorySchema == "" {
+		options.HistorySchema = "history"
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &PostgresTransport{
+		db:      db,
+		options: options,
+		tables:  map[string]bool{},
+	}, nil
+}
+
+// HistoryTable 数据表对应的历史表，返回schema以及表名
+func (p *PostgresTransport) HistoryTable(schema, table string) (string, string) {
+	return historyTable(p.options, schema, table)
+}
+
+func historyTable(options Options, schema, table string) (string, string) {
+	if options.InPlace {
+		return schema, limitIdent(table + "_history")
+	}
+	escape := strings.NewReplacer("%", "%25", ".", "%2E")
+	return options.HistorySchema, limitIdent(escape.Replace(schema) + "." + escape.Replace(table))
+}
+
+// limitIdent 超过postgres标识符长度时截断并追加摘要，避免postgres截断之后重名
+func limitIdent(name string) string {
+	if len(name) <= maxIdentLength {
+		return name
+	}
+	sum := sha256.Sum256([]byte(name))
+	suffix := "~" + hex.EncodeToString(sum[:])[:12]
+	prefix := name[:maxIdentLength-len(suffix)]
+	for !utf8.ValidString(prefix) {
+		prefix = prefix[:len(prefix)-1]
+	}
+	return prefix + suffix
+}
+
+func (p *PostgresTransport) Save(log transport.ILogData) error {
+	return p.SaveBatch([]transport.ILogData{log})
+}
+
+// SaveBatch 在同一个事务中创建历史表并写入，ddl等没有数据表的日志会被忽略
+func (p *PostgresTransport) SaveBatch(logs []transport.ILogData) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	created := map[string]bool{}
+	for _, log := range logs {
+		if log.GetTable() == "" {
+			continue
+		}
+
+		name, err := p.ensureTable(tx, created, log.GetSchema(), log.GetTable())
+		if err != nil {
+			return err
+		}
+		if err := saveVersion(tx, name, log); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	// 提交之后才记录已经创建的历史表
+	for name := range created {
+		p.tables[name] = true
+	}
+	return nil
+}
+
+// saveVersion 关闭当前的版本并写入新的版本
+func saveVersion(tx *sql.Tx, name string, log transport.ILogData) error {
+	var (
+		op    = log.GetLabel()
+		id    = transport.GetId(log)
+		from  = log.GetTime()
+		to    interface{}
+		image = log.GetPaylod()
+	)
+	if from.IsZero() {
+		from = time.Now()
+	}
+
+	switch op {
+	case "truncate":
+		if _, err := tx.Exec(fmt.Sprintf(sqlCloseAll, name), from); err != nil {
+			return err
+		}
+		to = from
+	default:
+		if id != "" {
+			if _, err := tx.Exec(fmt.Sprintf(sqlCloseVersion, name), from, id); err != nil {
+				return err
+			}
+		}
+		if op == "delete" {
+			to = from
+		}
+	}
+
+	rowData, err := json.Marshal(image)
+	if err != nil {
+		return err
+	}
+	if image == nil {
+		rowData = []byte("{}")
+	}
+	changes, err := json.Marshal(log.GetChange())
+	if err != nil {
+		return err
+	}
+	if log.GetChange() == nil {
+		changes = []byte("{}")
+	}
+
+	_, err = tx.Exec(fmt.Sprintf(sqlInsertVersion, name),
+		id, op, from, to, transport.GetActor(log), transport.GetTxId(log), string(rowData), string(changes),
+	)
+	return err
+}
+
+// ensureTable 在写入的事务中创建历史表，返回带有schema的表名
+func (p *PostgresTransport) ensureTable(tx *sql.Tx, created map[string]bool, schema, table string) (string, error) {
+	historySchema, historyName := p.HistoryTable(schema, table)
+	name := quoteIdent(historySchema) + "." + quoteIdent(historyName)
+	if p.tables[name] || created[name] {
+		return name, nil
+	}
+
+	if _, err := tx.Exec(fmt.Sprintf(sqlCreateSchema, quoteIdent(historySchema))); err != nil {
+		return "", err
+	}
+	_, err := tx.Exec(fmt.Sprintf(sqlCreateTable, name,
+		quoteIdent(indexName(historyName, "record")), quoteIdent(indexName(historyName, "valid")),
+	))
+	if err != nil {
+		return "", err
+	}
+	created[name] = true
+	return name, nil
+}
+
+func indexName(historyName, kind string) string {
+	return limitIdent(historyName + "_" + kind + "_idx")
+}
+
+// Query 查询某个数据表的历史版本，需要指定Schema以及Table
+// 时间范围按照valid_from过滤，不支持字段条件以及全文搜索
+func (p *PostgresTransport) Query(q transport.Query) ([]transport.Record, error) {
+	if q.Schema == "" || q.Table == "" || len(q.Fields) > 0 || len(q.Changed) > 0 || q.Text != "" {
+		return nil, transport.ErrNotSupported
+	}
+
+	stmt, args := buildQuery(p.options, q)
+	rows, err := p.db.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []transport.Record{}
+	for rows.Next() {
+		var (
+			record           = transport.Record{Schema: q.Schema, Table: q.Table, Type: "dml"}
+			rowData, changes []byte
+		)
+		err := rows.Scan(&record.Id, &record.Label, &record.Time, &record.Actor, &record.TxId, &rowData, &changes)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(rowData, &record.Payload); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(changes, &record.Changes); err != nil {
+			return nil, err
+		}
+		res = append(res, record)
+	}
+	return res, rows.Err()
+}
+
+func buildQuery(options Options, q transport.Query) (string, []interface{}) {
+	historySchema, historyName := historyTable(options, q.Schema, q.Table)
+
+	where := []string{}
+	args := []interface{}{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if !q.Start.IsZero() {
+		add("valid_from >= $%d", q.Start)
+	}
+	if !q.End.IsZero() {
+		add("valid_from < $%d", q.End)
+	}
+	if q.RecordId != "" {
+		add("record_id = $%d", q.RecordId)
+	}
+	if len(q.Ops) > 0 {
+		add("op = ANY($%d)", pq.Array(q.Ops))
+	}
+
+	clause := ""
+	if len(where) > 0 {
+		clause = " WHERE " + strings.Join(where, " AND ")
+	}
+	order := "ASC"
+	if q.Desc {
+		order = "DESC"
+	}
+	limit := "ALL"
+	if q.Limit > 0 {
+		limit = fmt.Sprint(q.Limit)
+	}
+
+	name := quoteIdent(historySchema) + "." + quoteIdent(historyName)
+	return fmt.Sprintf(sqlQueryVersion, name, clause, order, order, limit, q.Offset), args
+}
+
+func (p *PostgresTransport) Close() error {
+	return p.db.Close()
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
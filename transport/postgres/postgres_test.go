@@ -0,0 +1,87 @@
+package postgres
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+	"github.com/wwqdrh/datamanager/transport"
+)
+
+func TestHistoryTable(t *testing.T) {
+	schema, table := historyTable(Options{HistorySchema: "history"}, "public", "notes")
+	require.Equal(t, "history", schema)
+	require.Equal(t, "public.notes", table)
+
+	// 下划线以及.不会重名
+	_, a := historyTable(Options{HistorySchema: "history"}, "a_b", "c")
+	_, b := historyTable(Options{HistorySchema: "history"}, "a", "b_c")
+	require.NotEqual(t, a, b)
+	_, a = historyTable(Options{HistorySchema: "history"}, "a.b", "c")
+	_, b = historyTable(Options{HistorySchema: "history"}, "a", "b.c")
+	require.NotEqual(t, a, b)
+
+	// 超过标识符长度时截断并追加摘要
+	_, a = historyTable(Options{HistorySchema: "history"}, "public", strings.Repeat("x", 70)+"a")
+	_, b = historyTable(Options{HistorySchema: "history"}, "public", strings.Repeat("x", 70)+"b")
+	require.Len(t, a, maxIdentLength)
+	require.NotEqual(t, a, b)
+
+	schema, table = historyTable(Options{InPlace: true}, "public", "notes")
+	require.Equal(t, "public", schema)
+	require.Equal(t, "notes_history", table)
+
+	require.Equal(t, `"a""b"`, quoteIdent(`a"b`))
+}
+
+func TestBuildQuery(t *testing.T) {
+	start := time.Date(2022, 7, 1, 9, 0, 0, 0, time.UTC)
+	stmt, args := buildQuery(Options{HistorySchema: "history"}, transport.Query{
+		Schema: "public", Table: "notes", Start: start, RecordId: "14", Ops: []string{"update", "delete"},
+		Desc: true, Limit: 10, Offset: 20,
+	})
+
+	require.Contains(t, stmt, `FROM "history"."public.notes" WHERE valid_from >= $1 AND record_id = $2 AND op = ANY($3)`)
+	require.Contains(t, stmt, "ORDER BY valid_from DESC, history_id DESC LIMIT 10 OFFSET 20")
+	require.Equal(t, []interface{}{start, "14", pq.Array([]string{"update", "delete"})}, args)
+}
+
+// 需要设置DB_DSN
+func TestPostgresTransport(t *testing.T) {
+	dsn := os.Getenv("DB_DSN")
+	if dsn == "" {
+		fmt.Println("未设置DB_DSN，跳过测试")
+		return
+	}
+
+	p, err := NewPostgresTransport(dsn, Options{HistorySchema: "history_test"})
+	require.Nil(t, err)
+	defer p.Close()
+	defer p.db.Exec(`DROP SCHEMA history_test CASCADE`)
+
+	base := time.Now().Truncate(time.Second)
+	require.Nil(t, p.SaveBatch([]transport.ILogData{
+		transport.Record{Schema: "public", Table: "notes", Label: "insert", Time: base, Payload: map[string]interface{}{"id": 1, "note": "a"}},
+		transport.Record{Schema: "public", Table: "notes", Label: "update", Time: base.Add(time.Minute), Payload: map[string]interface{}{"id": 1, "note": "b"}},
+		transport.Record{Schema: "public", Table: "notes", Label: "delete", Time: base.Add(2 * time.Minute), Payload: map[string]interface{}{"id": 1, "note": "b"}},
+	}))
+
+	var current int
+	require.Nil(t, p.db.QueryRow(`SELECT count(*) FROM history_test."public.notes" WHERE valid_to IS NULL`).Scan(&current))
+	require.Zero(t, current)
+
+	var note string
+	err = p.db.QueryRow(`SELECT row_data->>'note' FROM history_test."public.notes"
+		WHERE valid_from <= $1 AND (valid_to IS NULL OR valid_to > $1)`, base.Add(90*time.Second)).Scan(&note)
+	require.Nil(t, err)
+	require.Equal(t, "b", note)
+
+	records, err := p.Query(transport.Query{Schema: "public", Table: "notes"})
+	require.Nil(t, err)
+	require.Len(t, records, 3)
+	require.Equal(t, "delete", records[2].Label)
+}
@@ -0,0 +1,123 @@
+package transport
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Factory 根据配置项创建存储端
+type Factory func(options map[string]string) (Transport, error)
+
+// Config 存储端配置，Name为空时使用Type
+type Config struct {
+	Name    string            `mapstructure:"name" yaml:"name"`
+	Type    string            `mapstructure:"type" yaml:"type"`
+	Options map[string]string `mapstructure:"options" yaml:"options"`
+}
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = map[string]Factory{}
+)
+
+// Register 注册存储端类型，通常在存储端包的init中调用
+func Register(typ string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if factory == nil {
+		panic("transport: register factory is nil")
+	}
+	if _, ok := factories[typ]; ok {
+		panic("transport: register called twice for " + typ)
+	}
+	factories[typ] = factory
+}
+
+// Types 已经注册的存储端类型
+func Types() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	res := make([]string, 0, len(factories))
+	for typ := range factories {
+		res = append(res, typ)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func New(conf Config) (Transport, error) {
+	factoriesMu.RLock()
+	factory, ok := factories[conf.Type]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("transport: unknown type %q (forgotten import?)", conf.Type)
+	}
+
+	options := conf.Options
+	if options == nil {
+		options = map[string]string{}
+	}
+	return factory(options)
+}
+
+// NewAll 按照配置创建全部存储端，任意一个失败时关闭已经创建的存储端
+func NewAll(confs []Config) (map[string]Transport, error) {
+	res := map[string]Transport{}
+	for _, conf := range confs {
+		name := conf.Name
+		if name == "" {
+			name = conf.Type
+		}
+		if _, ok := res[name]; ok {
+			closeAll(res)
+			return nil, fmt.Errorf("transport: duplicate name %q", name)
+		}
+
+		t, err := New(conf)
+		if err != nil {
+			closeAll(res)
+			return nil, err
+		}
+		res[name] = t
+	}
+	return res, nil
+}
+
+func closeAll(transports map[string]Transport) {
+	for _, t := range transports {
+		t.Close()
+	}
+}
+
+// ParseConfigs 解析命令行格式的配置，多个存储端使用逗号分隔，配置项使用query格式
+// 例如: plain,sqlite?path=data.db
+func ParseConfigs(spec string) ([]Config, error) {
+	res := []Config{}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		conf := Config{Type: item, Options: map[string]string{}}
+		if index := strings.Index(item, "?"); index >= 0 {
+			conf.Type = item[:index]
+			values, err := url.ParseQuery(item[index+1:])
+			if err != nil {
+				return nil, fmt.Errorf("transport: invalid options %q: %w", item, err)
+			}
+			for key := range values {
+				conf.Options[key] = values.Get(key)
+			}
+		}
+		conf.Name = conf.Options["name"]
+		delete(conf.Options, "name")
+		res = append(res, conf)
+	}
+	return res, nil
+}
@@ -0,0 +1,105 @@
+package transport
+
+import (
+	"context"
+	"time"
+)
+
+// ReplayFunc 处理回放的每一条记录，返回错误时停止回放
+type ReplayFunc func(log ILogData) error
+
+type ReplayOptions struct {
+	// Speed 回放速度，0表示尽快回放，1表示按照原始的时间间隔，2表示两倍速
+	Speed float64
+	// MaxWait 按照原始间隔回放时单次等待的上限，0表示不限制
+	MaxWait time.Duration
+	// DryRun 只统计满足条件的记录，不调用ReplayFunc也不等待
+	DryRun bool
+	// PageSize 每次从存储端读取的条数，默认500
+	PageSize int
+}
+
+type ReplayStats struct {
+	Events int
+	First  time.Time
+	Last   time.Time
+}
+
+// Replay 按照时间顺序回放存储端中满足条件的记录
+// 可以用于重建datamanager.Repo的缓存、通过Watcher重新触发回调或者将历史写入新的存储端
+func Replay(ctx context.Context, source Transport, q Query, options ReplayOptions, fn ReplayFunc) (ReplayStats, error) {
+	var stats ReplayStats
+
+	pageSize := options.PageSize
+	if pageSize <= 0 {
+		pageSize = 500
+	}
+	remain := q.Limit
+	q.Desc = false
+
+	var prev time.Time
+	for {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+
+		q.Limit = pageSize
+		if remain > 0 && remain < pageSize {
+			q.Limit = remain
+		}
+		records, err := source.Query(q)
+		if err != nil {
+			return stats, err
+		}
+
+		for _, record := range records {
+			if !options.DryRun {
+				if err := wait(ctx, prev, record.Time, options); err != nil {
+					return stats, err
+				}
+				if err := fn(record); err != nil {
+					return stats, err
+				}
+			}
+
+			if stats.Events == 0 {
+				stats.First = record.Time
+			}
+			stats.Last = record.Time
+			stats.Events++
+			prev = record.Time
+		}
+
+		if remain > 0 {
+			remain -= len(records)
+			if remain <= 0 {
+				return stats, nil
+			}
+		}
+		if len(records) < q.Limit {
+			return stats, nil
+		}
+		q.Offset += len(records)
+	}
+}
+
+// wait 按照回放速度等待两条记录之间的间隔
+func wait(ctx context.Context, prev, next time.Time, options ReplayOptions) error {
+	if options.Speed <= 0 || prev.IsZero() || !next.After(prev) {
+		return nil
+	}
+
+	d := time.Duration(float64(next.Sub(prev)) / options.Speed)
+	if options.MaxWait > 0 && d > options.MaxWait {
+		d = options.MaxWait
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
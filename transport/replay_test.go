@@ -0,0 +1,109 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// queryTransport 按照Limit、Offset返回固定的记录
+type queryTransport struct {
+	memoryTransport
+	queries int
+}
+
+func (q *queryTransport) Query(query Query) ([]Record, error) {
+	q.queries++
+	if query.Offset >= len(q.records) {
+		return nil, nil
+	}
+	end := len(q.records)
+	if query.Limit > 0 && query.Offset+query.Limit < end {
+		end = query.Offset + query.Limit
+	}
+	return q.records[query.Offset:end], nil
+}
+
+func newReplaySource(n int, interval time.Duration) *queryTransport {
+	source := &queryTransport{}
+	base := time.Now()
+	for i := 0; i < n; i++ {
+		r := newTestRecord("notes")
+		r.Time = base.Add(time.Duration(i) * interval)
+		r.Payload = map[string]interface{}{"id": i}
+		source.records = append(source.records, r)
+	}
+	return source
+}
+
+func TestReplay(t *testing.T) {
+	source := newReplaySource(7, time.Second)
+	sink := &memoryTransport{}
+
+	stats, err := Replay(context.Background(), source, Query{Table: "notes"}, ReplayOptions{PageSize: 3}, sink.Save)
+	require.Nil(t, err)
+	require.Equal(t, 7, stats.Events)
+	require.Equal(t, source.records[0].Time, stats.First)
+	require.Equal(t, source.records[6].Time, stats.Last)
+	require.Equal(t, 3, source.queries)
+	require.Len(t, sink.records, 7)
+	for i, r := range sink.records {
+		require.Equal(t, i, r.Payload["id"])
+	}
+
+	// Limit限制回放的总条数
+	stats, err = Replay(context.Background(), source, Query{Limit: 4}, ReplayOptions{PageSize: 3}, func(ILogData) error { return nil })
+	require.Nil(t, err)
+	require.Equal(t, 4, stats.Events)
+}
+
+func TestReplayDryRun(t *testing.T) {
+	source := newReplaySource(5, time.Hour)
+
+	stats, err := Replay(context.Background(), source, Query{}, ReplayOptions{Speed: 1, DryRun: true}, func(ILogData) error {
+		return errors.New("should not be called")
+	})
+	require.Nil(t, err)
+	require.Equal(t, 5, stats.Events)
+}
+
+func TestReplayPacing(t *testing.T) {
+	source := newReplaySource(3, 100*time.Millisecond)
+
+	start := time.Now()
+	_, err := Replay(context.Background(), source, Query{}, ReplayOptions{Speed: 2}, func(ILogData) error { return nil })
+	require.Nil(t, err)
+	require.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+
+	// 超过MaxWait的间隔按照MaxWait等待
+	source = newReplaySource(3, time.Hour)
+	start = time.Now()
+	_, err = Replay(context.Background(), source, Query{}, ReplayOptions{Speed: 1, MaxWait: 10 * time.Millisecond}, func(ILogData) error { return nil })
+	require.Nil(t, err)
+	require.Less(t, time.Since(start), time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	stats, err := Replay(ctx, source, Query{}, ReplayOptions{Speed: 1}, func(ILogData) error { return nil })
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Equal(t, 1, stats.Events)
+}
+
+func TestReplayStopOnError(t *testing.T) {
+	source := newReplaySource(5, time.Second)
+	failed := errors.New("failed")
+
+	count := 0
+	stats, err := Replay(context.Background(), source, Query{}, ReplayOptions{}, func(ILogData) error {
+		count++
+		if count == 3 {
+			return failed
+		}
+		return nil
+	})
+	require.Equal(t, failed, err)
+	require.Equal(t, 2, stats.Events)
+}
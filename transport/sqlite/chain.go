@@ -0,0 +1,598 @@
+package sqlite
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// 每条记录使用哈希与前一条记录关联，hash为全局的链，table_hash为同一个数据表的链
+// 修改、删除中间的记录都会导致之后的哈希不一致
+// 设置了签名密钥时链使用HMAC计算，没有密钥无法重新计算哈希；第一次使用密钥写入时使用密钥重新计算已有的记录
+// 截断末尾的记录需要通过签名的检查点发现，每个检查点的签名包含上一个检查点的签名，
+// 检查点的id需要连续，最后一个检查点之后的记录不能超过检查点的间隔
+//
+// 哈希在写入时按照顺序计算，同一个数据库文件只能有一个写入的进程
+
+var (
+	ErrNoSigningKey       = errors.New("no checkpoint signing key")
+	ErrSigningKeyMismatch = errors.New("signing key does not match the history chain")
+)
+
+// history_meta中记录链的计算方式
+const (
+	chainPlain = "sha256"
+	chainKeyed = "hmac"
+
+	keyCheckMessage = "datamanager history chain"
+)
+
+// DefaultCheckpointEvery 每写入多少条记录生成一个检查点
+var DefaultCheckpointEvery = 1000
+
+var (
+	checkpointCreate = `CREATE TABLE IF NOT EXISTS history_checkpoint (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		history_id INTEGER NOT NULL,
+		hash       TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		signature  TEXT NOT NULL
+	)`
+
+	checkpointInsert = `INSERT INTO history_checkpoint (id, history_id, hash, created_at, signature) VALUES (?, ?, ?, ?, ?)`
+
+	metaCreate = `CREATE TABLE IF NOT EXISTS history_meta (
+		name  TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	)`
+)
+
+// Checkpoint 某条记录的全局哈希以及签名
+type Checkpoint struct {
+	Id        int64     `json:"id"`
+	HistoryId int64     `json:"history_id"`
+	Hash      string    `json:"hash"`
+	CreatedAt time.Time `json:"created_at"`
+	Signature string    `json:"signature"`
+}
+
+// BrokenLink 第一处校验失败的位置
+type BrokenLink struct {
+	Id         int64  `json:"id"`                   // 记录的id
+	Checkpoint int64  `json:"checkpoint,omitempty"` // 检查点的id
+	Schema     string `json:"schema,omitempty"`
+	Table      string `json:"table,omitempty"`
+	Chain      string `json:"chain"` // global、table或者checkpoint
+	Reason     string `json:"reason"`
+}
+
+type VerifyResult struct {
+	Records     int         `json:"records"`
+	Checkpoints int         `json:"checkpoints"`
+	Signed      bool        `json:"signed"` // 是否校验了检查点的签名
+	Ok          bool        `json:"ok"`
+	Broken      *BrokenLink `json:"broken,omitempty"`
+}
+
+// LoadKey 读取签名密钥文件，忽略首尾的空白
+func LoadKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key := bytes.TrimSpace(data)
+	if len(key) == 0 {
+		return nil, fmt.Errorf("empty key file: %s", path)
+	}
+	return key, nil
+}
+
+// chainState 最后一条记录的哈希，在第一次写入时从数据库加载
+type chainState struct {
+	mu     sync.Mutex
+	loaded bool
+	last   string
+	tables map[string]string
+	// 上一个检查点之后写入的记录数
+	pending int
+	// 最后一个检查点
+	checkpointId int64
+	checkpoint   string
+
+	key   []byte
+	every int
+}
+
+// load 设置了密钥而链还没有使用密钥计算时重新计算全部记录的哈希以及检查点的签名
+func (c *chainState) load(db *sql.DB) error {
+	if c.loaded {
+		return nil
+	}
+
+	mode, check, err := chainMode(db)
+	if err != nil {
+		return err
+	}
+	switch {
+	case c.key == nil && mode == chainKeyed:
+		return ErrNoSigningKey
+	case c.key != nil && mode == chainKeyed && !hmac.Equal([]byte(check), []byte(keyCheck(c.key))):
+		return ErrSigningKeyMismatch
+	case c.key != nil && mode != chainKeyed:
+		if err := keyChain(db, c.key); err != nil {
+			return err
+		}
+	}
+
+	c.last = ""
+	err = db.QueryRow(`SELECT hash FROM history ORDER BY id DESC LIMIT 1`).Scan(&c.last)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	c.checkpointId, c.checkpoint = 0, ""
+	err = db.QueryRow(`SELECT id, signature FROM history_checkpoint ORDER BY id DESC LIMIT 1`).Scan(&c.checkpointId, &c.checkpoint)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	err = db.QueryRow(`
+	SELECT count(*) FROM history
+	WHERE id > (SELECT coalesce(max(history_id), 0) FROM history_checkpoint)
+	`).Scan(&c.pending)
+	if err != nil {
+		return err
+	}
+	c.tables = map[string]string{}
+	c.loaded = true
+	return nil
+}
+
+func (c *chainState) tableHash(db *sql.DB, schema, table string) (string, error) {
+	key := schema + "." + table
+	if hash, ok := c.tables[key]; ok {
+		return hash, nil
+	}
+
+	var hash string
+	err := db.QueryRow(
+		`SELECT table_hash FROM history WHERE schema_name = ? AND table_name = ? ORDER BY id DESC LIMIT 1`,
+		schema, table,
+	).Scan(&hash)
+	if err != nil && err != sql.ErrNoRows {
+		return "", err
+	}
+	c.tables[key] = hash
+	return hash, nil
+}
+
+// chainLink 写入事务中计算的哈希，提交之后更新chainState
+type chainLink struct {
+	last         string
+	tables       map[string]string
+	pending      int
+	checkpointId int64
+	checkpoint   string
+}
+
+func (c *chainState) begin() *chainLink {
+	return &chainLink{last: c.last, tables: map[string]string{}, pending: c.pending,
+		checkpointId: c.checkpointId, checkpoint: c.checkpoint}
+}
+
+func (c *chainState) commit(link *chainLink) {
+	c.last = link.last
+	for key, hash := range link.tables {
+		c.tables[key] = hash
+	}
+	c.pending = link.pending
+	c.checkpointId, c.checkpoint = link.checkpointId, link.checkpoint
+}
+
+// chainMode 链的计算方式以及密钥的校验值，没有记录时为chainPlain
+func chainMode(db queryer) (string, string, error) {
+	meta := map[string]string{}
+	rows, err := db.Query(`SELECT name, value FROM history_meta WHERE name IN ('chain', 'key_check')`)
+	if err != nil {
+		return "", "", err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return "", "", err
+		}
+		meta[name] = value
+	}
+	if meta["chain"] == "" {
+		meta["chain"] = chainPlain
+	}
+	return meta["chain"], meta["key_check"], rows.Err()
+}
+
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// keyChain 使用密钥重新计算全部记录的哈希，没有密钥时无法生成检查点，不需要重新签名
+// 没有密钥的链需要先校验通过，避免使用密钥掩盖之前的修改
+func keyChain(db *sql.DB, key []byte) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	broken, err := verifyRecords(tx, nil, &VerifyResult{})
+	if err != nil {
+		return err
+	}
+	if broken != nil {
+		return fmt.Errorf("history chain broken at %d: %s", broken.Id, broken.Reason)
+	}
+
+	if err := rechain(tx, key); err != nil {
+		return err
+	}
+	for name, value := range map[string]string{"chain": chainKeyed, "key_check": keyCheck(key)} {
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO history_meta (name, value) VALUES (?, ?)`, name, value); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// recordDigest 记录内容的摘要，不包含自增的id
+func recordDigest(schema, table, typ, op, recordId string, eventTime int64, txId, actor, payload, changes string) string {
+	data, _ := json.Marshal([]interface{}{schema, table, typ, op, recordId, eventTime, txId, actor, payload, changes})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// expiringDigest 会过期的记录使用payload以及changes的摘要，清理内容之后仍然可以校验
+func expiringDigest(schema, table, typ, op, recordId string, eventTime int64, txId, actor, content string, expireAt int64) string {
+	data, _ := json.Marshal([]interface{}{schema, table, typ, op, recordId, eventTime, txId, actor, content, expireAt, "expire"})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func contentDigest(payload, changes string) string {
+	data, _ := json.Marshal([]string{payload, changes})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// historyColumns 计算哈希需要的列
+const historyColumns = `id, schema_name, table_name, type, op, record_id, event_time, tx_id, actor, payload, changes, hash, table_hash, expire_at, content_hash`
+
+// historyRow history中的一条记录
+type historyRow struct {
+	id                                            int64
+	schema, table, typ, op, recordId, txId, actor string
+	eventTime, expireAt                           int64
+	payload, changes, hash, tableHash             string
+	contentHash                                   string // 清理内容之后记录原来内容的摘要
+}
+
+func scanHistory(rows *sql.Rows) (historyRow, error) {
+	var r historyRow
+	err := rows.Scan(&r.id, &r.schema, &r.table, &r.typ, &r.op, &r.recordId, &r.eventTime, &r.txId, &r.actor,
+		&r.payload, &r.changes, &r.hash, &r.tableHash, &r.expireAt, &r.contentHash)
+	return r, err
+}
+
+func (r historyRow) digest() string {
+	if r.expireAt == 0 {
+		return recordDigest(r.schema, r.table, r.typ, r.op, r.recordId, r.eventTime, r.txId, r.actor, r.payload, r.changes)
+	}
+	content := r.contentHash
+	if content == "" {
+		content = contentDigest(r.payload, r.changes)
+	}
+	return expiringDigest(r.schema, r.table, r.typ, r.op, r.recordId, r.eventTime, r.txId, r.actor, content, r.expireAt)
+}
+
+// prunable 记录已经过期并且内容已经清理
+func (r historyRow) prunable(now int64) bool {
+	return r.expireAt > 0 && r.expireAt <= now && r.payload == emptyContent && r.changes == emptyContent
+}
+
+// chainHash 没有密钥时为sha256，否则为HMAC-SHA256
+func chainHash(key []byte, prev, digest string) string {
+	if key == nil {
+		sum := sha256.Sum256([]byte(prev + ":" + digest))
+		return hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s:%s", prev, digest)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signCheckpoint prev为上一个检查点的签名，删除或者替换中间的检查点会导致之后的签名不一致
+func signCheckpoint(key []byte, prev string, historyId int64, hash string, createdAt int64) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s:%d:%s:%d", prev, historyId, hash, createdAt)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// keyCheck 用于确认写入时使用的是同一个密钥
+func keyCheck(key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(keyCheckMessage))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SetSigningKey 设置检查点的签名密钥，every为每写入多少条记录生成一个检查点，<=0时使用默认值
+func (p *SqliteTransport) SetSigningKey(key []byte, every int) *SqliteTransport {
+	if every <= 0 {
+		every = DefaultCheckpointEvery
+	}
+	p.chain.mu.Lock()
+	defer p.chain.mu.Unlock()
+	p.chain.key = key
+	p.chain.every = every
+	p.chain.loaded = false
+	return p
+}
+
+// Checkpoint 为最后一条记录生成检查点，没有记录时返回空
+func (p *SqliteTransport) Checkpoint() (*Checkpoint, error) {
+	p.chain.mu.Lock()
+	defer p.chain.mu.Unlock()
+	if p.chain.key == nil {
+		return nil, ErrNoSigningKey
+	}
+	if err := p.chain.load(p.driver.db); err != nil {
+		return nil, err
+	}
+
+	var (
+		id   int64
+		hash string
+	)
+	err := p.driver.db.QueryRow(`SELECT id, hash FROM history ORDER BY id DESC LIMIT 1`).Scan(&id, &hash)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	tx, err := p.driver.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	link := p.chain.begin()
+	checkpoint, err := p.writeCheckpoint(tx, link, id, hash)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	link.pending = 0
+	p.chain.commit(link)
+	return checkpoint, nil
+}
+
+// writeCheckpoint 检查点的id连续，签名包含上一个检查点的签名
+func (p *SqliteTransport) writeCheckpoint(tx *sql.Tx, link *chainLink, historyId int64, hash string) (*Checkpoint, error) {
+	now := time.Now()
+	checkpoint := &Checkpoint{
+		Id:        link.checkpointId + 1,
+		HistoryId: historyId,
+		Hash:      hash,
+		CreatedAt: now,
+		Signature: signCheckpoint(p.chain.key, link.checkpoint, historyId, hash, now.UnixNano()),
+	}
+	_, err := tx.Exec(checkpointInsert, checkpoint.Id, historyId, hash, now.UnixNano(), checkpoint.Signature)
+	if err != nil {
+		return nil, err
+	}
+	link.checkpointId, link.checkpoint = checkpoint.Id, checkpoint.Signature
+	return checkpoint, nil
+}
+
+// Checkpoints 全部的检查点
+func (p *SqliteTransport) Checkpoints() ([]Checkpoint, error) {
+	rows, err := p.driver.db.Query(`SELECT id, history_id, hash, created_at, signature FROM history_checkpoint ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []Checkpoint{}
+	for rows.Next() {
+		var (
+			c         Checkpoint
+			createdAt int64
+		)
+		if err := rows.Scan(&c.Id, &c.HistoryId, &c.Hash, &createdAt, &c.Signature); err != nil {
+			return nil, err
+		}
+		c.CreatedAt = time.Unix(0, createdAt)
+		res = append(res, c)
+	}
+	return res, rows.Err()
+}
+
+// Verify 按照id顺序重新计算全部记录的哈希，并校验检查点，返回第一处不一致的位置
+// 没有设置签名密钥时只能校验没有使用密钥的链，并且不校验检查点的签名以及最后一个检查点之后的记录数
+func (p *SqliteTransport) Verify() (VerifyResult, error) {
+	p.chain.mu.Lock()
+	key, every := p.chain.key, p.chain.every
+	p.chain.mu.Unlock()
+
+	res := VerifyResult{Signed: key != nil}
+	mode, check, err := chainMode(p.driver.db)
+	if err != nil {
+		return res, err
+	}
+	switch {
+	case key == nil && mode == chainKeyed:
+		return res, ErrNoSigningKey
+	case key != nil && mode == chainKeyed && !hmac.Equal([]byte(check), []byte(keyCheck(key))):
+		return res, ErrSigningKeyMismatch
+	}
+
+	recordKey := key
+	if mode != chainKeyed {
+		recordKey = nil
+	}
+	broken, err := verifyRecords(p.driver.db, recordKey, &res)
+	if err != nil || broken != nil {
+		res.Broken = broken
+		return res, err
+	}
+	if key != nil && mode != chainKeyed && res.Records > 0 {
+		res.Broken = &BrokenLink{Chain: "global", Reason: "the chain is not keyed with the signing key"}
+		return res, nil
+	}
+
+	checkpoints, err := p.Checkpoints()
+	if err != nil {
+		return res, err
+	}
+	var prev Checkpoint
+	for _, c := range checkpoints {
+		res.Checkpoints++
+		if broken := p.verifyCheckpoint(key, prev, c); broken != nil {
+			res.Broken = broken
+			return res, nil
+		}
+		prev = c
+	}
+
+	if key != nil {
+		// 删除末尾的检查点之后可以随意修改之后的记录
+		var (
+			count int
+			first int64
+		)
+		err := p.driver.db.QueryRow(`SELECT count(*), coalesce(min(id), 0) FROM history WHERE id > ?`, prev.HistoryId).Scan(&count, &first)
+		if err != nil {
+			return res, err
+		}
+		if count > every {
+			res.Broken = &BrokenLink{Id: first, Checkpoint: prev.Id, Chain: "checkpoint",
+				Reason: fmt.Sprintf("%d records after the last checkpoint, more than the interval %d, checkpoints were removed", count, every)}
+			return res, nil
+		}
+	}
+	res.Ok = true
+	return res, nil
+}
+
+func verifyRecords(db queryer, key []byte, res *VerifyResult) (*BrokenLink, error) {
+	rows, err := db.Query(`SELECT ` + historyColumns + ` FROM history ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var (
+		last   string
+		tables = map[string]string{}
+		now    = time.Now().UnixNano()
+	)
+	for rows.Next() {
+		r, err := scanHistory(rows)
+		if err != nil {
+			return nil, err
+		}
+		res.Records++
+
+		if r.contentHash != "" && !r.prunable(now) {
+			return &BrokenLink{Id: r.id, Schema: r.schema, Table: r.table, Chain: "global",
+				Reason: "content removed before the record expired"}, nil
+		}
+		digest := r.digest()
+		if expected := chainHash(key, last, digest); r.hash != expected {
+			return &BrokenLink{Id: r.id, Schema: r.schema, Table: r.table, Chain: "global",
+				Reason: "hash mismatch, the record or a previous one was modified or removed"}, nil
+		}
+		name := r.schema + "." + r.table
+		if expected := chainHash(key, tables[name], digest); r.tableHash != expected {
+			return &BrokenLink{Id: r.id, Schema: r.schema, Table: r.table, Chain: "table",
+				Reason: "table hash mismatch, a previous record of the table was modified or removed"}, nil
+		}
+		last, tables[name] = r.hash, r.tableHash
+	}
+	return nil, rows.Err()
+}
+
+// verifyCheckpoint prev为上一个检查点，第一个检查点时为零值
+func (p *SqliteTransport) verifyCheckpoint(key []byte, prev, c Checkpoint) *BrokenLink {
+	broken := &BrokenLink{Id: c.HistoryId, Checkpoint: c.Id, Chain: "checkpoint"}
+	switch {
+	case c.Id != prev.Id+1:
+		broken.Reason = fmt.Sprintf("checkpoint %d is missing", prev.Id+1)
+		return broken
+	case c.HistoryId < prev.HistoryId:
+		broken.Reason = "checkpoint is before the previous one"
+		return broken
+	case key != nil && !hmac.Equal([]byte(c.Signature), []byte(signCheckpoint(key, prev.Signature, c.HistoryId, c.Hash, c.CreatedAt.UnixNano()))):
+		broken.Reason = "invalid checkpoint signature"
+		return broken
+	}
+
+	var hash string
+	err := p.driver.db.QueryRow(`SELECT hash FROM history WHERE id = ?`, c.HistoryId).Scan(&hash)
+	switch {
+	case err == sql.ErrNoRows:
+		broken.Reason = "checkpointed record is missing, the history was truncated"
+		return broken
+	case err != nil:
+		broken.Reason = err.Error()
+		return broken
+	case hash != c.Hash:
+		broken.Reason = "checkpoint hash mismatch"
+		return broken
+	}
+	return nil
+}
+
+// rechain 重新计算全部记录的哈希，用于从没有哈希的版本迁移以及第一次使用密钥写入
+func rechain(tx *sql.Tx, key []byte) error {
+	rows, err := tx.Query(`SELECT ` + historyColumns + ` FROM history ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	type link struct {
+		id              int64
+		hash, tableHash string
+	}
+	var (
+		links  = []link{}
+		last   string
+		tables = map[string]string{}
+	)
+	for rows.Next() {
+		r, err := scanHistory(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		digest := r.digest()
+		name := r.schema + "." + r.table
+		last, tables[name] = chainHash(key, last, digest), chainHash(key, tables[name], digest)
+		links = append(links, link{id: r.id, hash: last, tableHash: tables[name]})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, l := range links {
+		if _, err := tx.Exec(`UPDATE history SET hash = ?, table_hash = ? WHERE id = ?`, l.hash, l.tableHash, l.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
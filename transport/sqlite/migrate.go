@@ -0,0 +1,239 @@
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// schemaVersion 记录在PRAGMA user_version中
+// 0: 每个数据表一张schema_table表(id, op, recordID, payload, changes)
+// 1: 全部记录存储在history表中，记录之间的哈希链、检查点表history_checkpoint以及history_meta
+const schemaVersion = 1
+
+var (
+	ftsCreate = `CREATE VIRTUAL TABLE IF NOT EXISTS history_fts USING fts5(payload, changes)`
+	ftsInsert = `INSERT INTO history_fts (rowid, payload, changes) VALUES (?, ?, ?)`
+	// 补齐未建立索引的记录，例如使用不支持fts5的版本写入的记录
+	ftsSync = `
+	INSERT INTO history_fts (rowid, payload, changes)
+	SELECT id, payload, changes FROM history WHERE id > (SELECT coalesce(max(rowid), 0) FROM history_fts)
+	`
+)
+
+var legacyColumns = []string{"id", "op", "recordID", "payload", "changes"}
+
+// LegacySchemas 旧版本的表名为<schema>_<table>，按照匹配的最长的schema拆分
+// 没有匹配并且包含多个下划线时无法确定schema，schema_name为空，table_name为原来的表名，并记录在history_meta中
+var LegacySchemas = []string{"public"}
+
+// migrate 创建history表，并将旧版本的schema_table表迁移到history中，迁移之后重新计算哈希链
+func migrate(db *sql.DB, schemas []string) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version >= schemaVersion {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range historyCreate {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	tables, err := legacyTables(tx)
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		if err := migrateLegacyTable(tx, table, schemas); err != nil {
+			return fmt.Errorf("migrate %s: %w", table, err)
+		}
+	}
+
+	// 旧版本的记录没有哈希，迁移之后计算，使用密钥写入时会使用密钥重新计算
+	if err := rechain(tx, nil); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", schemaVersion)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// legacyTables 列名与旧版本一致的表
+func legacyTables(tx *sql.Tx) ([]string, error) {
+	rows, err := tx.Query(`SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND name NOT LIKE 'history%'`)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+
+	res := []string{}
+	for _, name := range names {
+		columns, err := tableColumns(tx, name)
+		if err != nil {
+			return nil, err
+		}
+		if strings.Join(columns, ",") == strings.Join(legacyColumns, ",") {
+			res = append(res, name)
+		}
+	}
+	return res, nil
+}
+
+func tableColumns(tx *sql.Tx, table string) ([]string, error) {
+	rows, err := tx.Query("SELECT name FROM pragma_table_info(?) ORDER BY cid", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		res = append(res, name)
+	}
+	return res, rows.Err()
+}
+
+// migrateLegacyTable 旧版本没有记录时间和操作类型
+func migrateLegacyTable(tx *sql.Tx, table string, schemas []string) error {
+	schema, name, ok := splitLegacyTable(table, schemas)
+	if !ok {
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO history_meta (name, value) VALUES (?, 'ambiguous')`, legacyMetaPrefix+table); err != nil {
+			return err
+		}
+	}
+
+	rows, err := tx.Query(fmt.Sprintf("SELECT payload, changes FROM %s ORDER BY id", quoteIdentifier(table)))
+	if err != nil {
+		return err
+	}
+	type legacyRecord struct {
+		payload, changes sql.NullString
+	}
+	records := []legacyRecord{}
+	for rows.Next() {
+		var r legacyRecord
+		if err := rows.Scan(&r.payload, &r.changes); err != nil {
+			rows.Close()
+			return err
+		}
+		records = append(records, r)
+	}
+	rows.Close()
+
+	for _, r := range records {
+		payload, changes := "{}", "{}"
+		if r.payload.Valid && r.payload.String != "" {
+			payload = r.payload.String
+		}
+		if r.changes.Valid && r.changes.String != "" && r.changes.String != "null" {
+			changes = r.changes.String
+		}
+
+		_, err := tx.Exec(recordInsert, schema, name, "", "", legacyRecordId(payload), 0, "", "", payload, changes, "", "", 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("DROP TABLE %s", quoteIdentifier(table)))
+	return err
+}
+
+const legacyMetaPrefix = "legacy_table:"
+
+// splitLegacyTable 只有一个下划线时没有歧义，否则需要匹配已知的schema
+func splitLegacyTable(table string, schemas []string) (string, string, bool) {
+	if schema := matchSchema(table, schemas); schema != "" {
+		return schema, table[len(schema)+1:], true
+	}
+	if strings.Count(table, "_") == 1 {
+		index := strings.Index(table, "_")
+		return table[:index], table[index+1:], true
+	}
+	return "", table, false
+}
+
+// matchSchema 表名以<schema>_开头的最长的schema，没有时返回空
+func matchSchema(table string, schemas []string) string {
+	schema := ""
+	for _, s := range schemas {
+		if len(s) > len(schema) && strings.HasPrefix(table, s+"_") && len(table) > len(s)+1 {
+			schema = s
+		}
+	}
+	return schema
+}
+
+// AmbiguousLegacyTables 迁移时无法确定schema的旧版本表名，记录的schema_name为空
+func (p *SqliteTransport) AmbiguousLegacyTables() ([]string, error) {
+	rows, err := p.driver.db.Query(`SELECT name FROM history_meta WHERE name LIKE ? ORDER BY name`, legacyMetaPrefix+"%")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		res = append(res, strings.TrimPrefix(name, legacyMetaPrefix))
+	}
+	return res, rows.Err()
+}
+
+func legacyRecordId(payload string) string {
+	var data map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&data); err != nil {
+		return ""
+	}
+	if id, ok := data["id"]; ok && id != nil {
+		return fmt.Sprint(id)
+	}
+	return ""
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// ensureFts 创建全文索引，sqlite不支持fts5时返回false，此时全文搜索使用LIKE
+func ensureFts(db *sql.DB) (bool, error) {
+	for _, stmt := range []string{ftsCreate, ftsSync} {
+		if _, err := db.Exec(stmt); err != nil {
+			if strings.Contains(err.Error(), "no such module: fts5") {
+				return false, nil
+			}
+			return false, err
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,81 @@
+package sqlite
+
+import (
+	"database/sql"
+	"time"
+)
+
+// 记录的过期时间来自日志的transport.IExpireAt，例如redis策略的HistoryTTL
+// 过期之后清理payload以及changes，保留记录的其他字段以及内容的摘要，哈希链仍然可以校验
+
+// PruneInterval SaveBatch中清理过期记录的最小间隔
+var PruneInterval = time.Minute
+
+const emptyContent = "{}"
+
+// Prune 清理已经过期的记录的内容，返回清理的记录数
+func (p *SqliteTransport) Prune() (int, error) {
+	now := time.Now()
+	p.chain.mu.Lock()
+	defer p.chain.mu.Unlock()
+
+	tx, err := p.driver.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	count, err := p.prune(tx, now)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	p.pruned = now
+	return count, nil
+}
+
+func (p *SqliteTransport) prune(tx *sql.Tx, now time.Time) (int, error) {
+	rows, err := tx.Query(
+		`SELECT id, payload, changes FROM history WHERE expire_at > 0 AND expire_at <= ? AND content_hash = ''`,
+		now.UnixNano(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	type expired struct {
+		id      int64
+		content string
+	}
+	records := []expired{}
+	for rows.Next() {
+		var (
+			id               int64
+			payload, changes string
+		)
+		if err := rows.Scan(&id, &payload, &changes); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		records = append(records, expired{id: id, content: contentDigest(payload, changes)})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, r := range records {
+		_, err := tx.Exec(`UPDATE history SET payload = ?, changes = ?, content_hash = ? WHERE id = ?`,
+			emptyContent, emptyContent, r.content, r.id)
+		if err != nil {
+			return 0, err
+		}
+		if p.fts {
+			if _, err := tx.Exec(`DELETE FROM history_fts WHERE rowid = ?`, r.id); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return len(records), nil
+}
@@ -0,0 +1,254 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/wwqdrh/datamanager/transport"
+)
+
+var (
+	ErrFieldOperator = errors.New("unsupported field operator")
+	ErrFieldPath     = errors.New("field path can't be empty")
+
+	fieldOperators = map[string]bool{"=": true, "!=": true, ">": true, ">=": true, "<": true, "<=": true}
+
+	recordColumns = `h.schema_name, h.table_name, h.type, h.op, h.record_id, h.event_time, h.tx_id, h.actor, h.payload, h.changes, h.expire_at`
+)
+
+// queryBuilder 将transport.Query转换为参数化的sql条件
+type queryBuilder struct {
+	where []string
+	args  []interface{}
+}
+
+func (b *queryBuilder) add(cond string, args ...interface{}) {
+	b.where = append(b.where, cond)
+	b.args = append(b.args, args...)
+}
+
+func (p *SqliteTransport) buildQuery(q transport.Query) (*queryBuilder, error) {
+	b := &queryBuilder{}
+
+	if q.Schema != "" {
+		b.add("h.schema_name = ?", q.Schema)
+	}
+	if q.Table != "" {
+		b.add("h.table_name = ?", q.Table)
+	}
+	if !q.Start.IsZero() {
+		b.add("h.event_time >= ?", toEventTime(q.Start))
+	}
+	if !q.End.IsZero() {
+		b.add("h.event_time < ?", toEventTime(q.End))
+	}
+	if len(q.Ops) > 0 {
+		b.add("h.op IN ("+placeholders(len(q.Ops))+")", toArgs(q.Ops)...)
+	}
+	if q.RecordId != "" {
+		b.add("h.record_id = ?", q.RecordId)
+	}
+
+	for _, field := range q.Fields {
+		if err := b.addField(field); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(q.Changed) > 0 {
+		conds := make([]string, 0, len(q.Changed))
+		args := make([]interface{}, 0, len(q.Changed))
+		for _, field := range q.Changed {
+			path, err := jsonPath(field)
+			if err != nil {
+				return nil, err
+			}
+			// json_type能够区分字段不存在与值为null
+			conds = append(conds, "json_type(h.changes, ?) IS NOT NULL")
+			args = append(args, path)
+		}
+		b.add("("+strings.Join(conds, " OR ")+")", args...)
+	}
+
+	if q.Text != "" {
+		if p.fts {
+			b.add("h.id IN (SELECT rowid FROM history_fts WHERE history_fts MATCH ?)", ftsPhrase(q.Text))
+		} else {
+			pattern := "%" + escapeLike(q.Text) + "%"
+			b.add(`(h.payload LIKE ? ESCAPE '\' OR h.changes LIKE ? ESCAPE '\')`, pattern, pattern)
+		}
+	}
+	return b, nil
+}
+
+func (b *queryBuilder) addField(field transport.FieldCondition) error {
+	op := field.Op
+	if op == "" {
+		op = "="
+	}
+	if !fieldOperators[op] {
+		return fmt.Errorf("%w: %s", ErrFieldOperator, field.Op)
+	}
+	path, err := jsonPath(field.Path)
+	if err != nil {
+		return err
+	}
+
+	value := field.Value
+	if v, ok := value.(bool); ok {
+		// json中的true false经过json_extract后为1 0
+		if v {
+			value = 1
+		} else {
+			value = 0
+		}
+	}
+
+	// 数字字符串同时匹配字符串和数字
+	if s, ok := value.(string); ok {
+		if n, err := strconv.ParseFloat(s, 64); err == nil {
+			switch op {
+			case "=":
+				b.add("json_extract(h.payload, ?) IN (?, ?)", path, s, n)
+			case "!=":
+				b.add("json_extract(h.payload, ?) NOT IN (?, ?)", path, s, n)
+			default:
+				b.add("json_extract(h.payload, ?) "+op+" ?", path, n)
+			}
+			return nil
+		}
+	}
+
+	if value == nil {
+		switch op {
+		case "=":
+			b.add("json_extract(h.payload, ?) IS NULL", path)
+			return nil
+		case "!=":
+			b.add("json_extract(h.payload, ?) IS NOT NULL", path)
+			return nil
+		}
+	}
+
+	b.add("json_extract(h.payload, ?) "+op+" ?", path, value)
+	return nil
+}
+
+func (b *queryBuilder) whereClause() string {
+	if len(b.where) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(b.where, " AND ")
+}
+
+// Query 按照条件查询记录，返回结构化的记录
+func (p *SqliteTransport) Query(q transport.Query) ([]transport.Record, error) {
+	b, err := p.buildQuery(q)
+	if err != nil {
+		return nil, err
+	}
+
+	order := "ASC"
+	if q.Desc {
+		order = "DESC"
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+
+	stmt := fmt.Sprintf("SELECT %s FROM history h%s ORDER BY h.event_time %s, h.id %s LIMIT ? OFFSET ?",
+		recordColumns, b.whereClause(), order, order)
+	rows, err := p.driver.db.Query(stmt, append(b.args, limit, q.Offset)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []transport.Record{}
+	for rows.Next() {
+		record, err := scanRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, record)
+	}
+	return res, rows.Err()
+}
+
+// Count 满足条件的记录总数，忽略Limit和Offset，用于分页
+func (p *SqliteTransport) Count(q transport.Query) (int, error) {
+	b, err := p.buildQuery(q)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	stmt := fmt.Sprintf("SELECT count(*) FROM history h%s", b.whereClause())
+	err = p.driver.db.QueryRow(stmt, b.args...).Scan(&count)
+	return count, err
+}
+
+func scanRecord(rows *sql.Rows) (transport.Record, error) {
+	var (
+		record           transport.Record
+		eventTime        int64
+		expireAt         int64
+		payload, changes string
+	)
+	err := rows.Scan(
+		&record.Schema, &record.Table, &record.Type, &record.Label, &record.Id,
+		&eventTime, &record.TxId, &record.Actor, &payload, &changes, &expireAt,
+	)
+	if err != nil {
+		return record, err
+	}
+
+	record.Time = fromEventTime(eventTime)
+	if expireAt > 0 {
+		t := fromEventTime(expireAt)
+		record.ExpireAt = &t
+	}
+	if err := unmarshalJson(payload, &record.Payload); err != nil {
+		return record, err
+	}
+	err = unmarshalJson(changes, &record.Changes)
+	return record, err
+}
+
+// jsonPath address.city => $."address"."city"
+func jsonPath(field string) (string, error) {
+	if strings.TrimSpace(field) == "" {
+		return "", ErrFieldPath
+	}
+
+	parts := strings.Split(field, ".")
+	for i, part := range parts {
+		parts[i] = `"` + strings.ReplaceAll(part, `"`, `\"`) + `"`
+	}
+	return "$." + strings.Join(parts, "."), nil
+}
+
+// ftsPhrase 将输入作为一个短语，避免fts5的查询语法
+func ftsPhrase(text string) string {
+	return `"` + strings.ReplaceAll(text, `"`, `""`) + `"`
+}
+
+func escapeLike(text string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(text)
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func toArgs(values []string) []interface{} {
+	res := make([]interface{}, len(values))
+	for i, v := range values {
+		res[i] = v
+	}
+	return res
+}
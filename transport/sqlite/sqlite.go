@@ -1,105 +1,263 @@
 package sqlite
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
-	"errors"
-	"fmt"
-	"regexp"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/wwqdrh/datamanager/transport"
 )
 
-type ILogData interface {
-	GetSchema() string
-	GetTable() string
-	GetType() string                   // 获取日志记录类型 ddl dml
-	GetLabel() string                  // 具体标签 insert update delete | alter column, table
-	GetTime() time.Time                // 获取日志记录时间
-	GetPaylod() map[string]interface{} // 获取具体的负载对象
-	GetChange() map[string]interface{} // 获取具体的负载对象
+type ILogData = transport.ILogData
+
+var _ transport.Transport = &SqliteTransport{}
+
+func init() {
+	transport.Register("sqlite", func(options map[string]string) (transport.Transport, error) {
+		path := options["path"]
+		if path == "" {
+			path = "data.db"
+		}
+		schemas := LegacySchemas
+		if v := options["legacy_schemas"]; v != "" {
+			schemas = strings.Split(v, ",")
+		}
+		t, err := newSqliteTransport(path, schemas)
+		if err != nil || options["key_file"] == "" {
+			return t, err
+		}
+
+		key, err := LoadKey(options["key_file"])
+		if err != nil {
+			t.Close()
+			return nil, err
+		}
+		every, _ := strconv.Atoi(options["checkpoint"])
+		return t.SetSigningKey(key, every), nil
+	})
 }
 
 var (
-	escape = regexp.MustCompile(`"`)
-	// create table `schema.table``
-	tableCreate = `
-	CREATE TABLE IF NOT EXISTS %s (
-		id     INTEGER PRIMARY KEY,
-		op           INTEGER,
-		recordID           INTEGER,
-		payload TEXT,
-		changes TEXT
-	);
-	`
+	// 全部数据表的修改记录存储在同一张表中
+	// event_time为unix纳秒，便于范围查询
+	historyCreate = []string{
+		`CREATE TABLE IF NOT EXISTS history (
+			id          INTEGER PRIMARY KEY AUTOINCREMENT,
+			schema_name TEXT NOT NULL,
+			table_name  TEXT NOT NULL,
+			type        TEXT NOT NULL DEFAULT '',
+			op          TEXT NOT NULL DEFAULT '',
+			record_id   TEXT NOT NULL DEFAULT '',
+			event_time  INTEGER NOT NULL DEFAULT 0,
+			tx_id       TEXT NOT NULL DEFAULT '',
+			actor       TEXT NOT NULL DEFAULT '',
+			payload     TEXT NOT NULL DEFAULT '{}',
+			changes     TEXT NOT NULL DEFAULT '{}',
+			hash        TEXT NOT NULL DEFAULT '',
+			table_hash  TEXT NOT NULL DEFAULT '',
+			expire_at    INTEGER NOT NULL DEFAULT 0,
+			content_hash TEXT NOT NULL DEFAULT ''
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_history_table_time ON history (schema_name, table_name, event_time)`,
+		`CREATE INDEX IF NOT EXISTS idx_history_record ON history (schema_name, table_name, record_id, event_time)`,
+		`CREATE INDEX IF NOT EXISTS idx_history_tx ON history (tx_id)`,
+		// 只索引没有清理的会过期的记录
+		`CREATE INDEX IF NOT EXISTS idx_history_expire ON history (expire_at) WHERE expire_at > 0 AND content_hash = ''`,
+		checkpointCreate,
+		metaCreate,
+	}
 
 	// insert record change
 	recordInsert = `
-	INSERT INTO %s (op, recordID, payload, changes) values (%d, %d, "%s", "%s")
-	`
-
-	// record search
-	recordSearch = `
-	select op, recordID, payload, changes from %s where payload like "%%%s%%"
+	INSERT INTO history (schema_name, table_name, type, op, record_id, event_time, tx_id, actor, payload, changes, hash, table_hash, expire_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 )
 
 type SqliteTransport struct {
 	driver *SqliteDriver
+	insert *sql.Stmt
+	fts    bool // 是否支持fts5，需要使用sqlite_fts5 tag编译
+	chain  chainState
+	pruned time.Time // 上一次清理过期记录的时间
 }
 
 func NewSqliteTransport(dbName string) (*SqliteTransport, error) {
+	return newSqliteTransport(dbName, LegacySchemas)
+}
+
+// newSqliteTransport schemas用于拆分旧版本的表名
+func newSqliteTransport(dbName string, schemas []string) (*SqliteTransport, error) {
 	driver, err := NewDriver(dbName)
 	if err != nil {
 		return nil, err
 	}
+	if err := migrate(driver.db, schemas); err != nil {
+		driver.db.Close()
+		return nil, err
+	}
+
+	fts, err := ensureFts(driver.db)
+	if err != nil {
+		driver.db.Close()
+		return nil, err
+	}
+
+	insert, err := driver.db.Prepare(recordInsert)
+	if err != nil {
+		driver.db.Close()
+		return nil, err
+	}
 	return &SqliteTransport{
 		driver: driver,
+		insert: insert,
+		fts:    fts,
 	}, nil
 }
 
 func (p *SqliteTransport) Save(log ILogData) error {
-	tableName := fmt.Sprintf("%s_%s", log.GetSchema(), log.GetTable())
-	_, err := p.driver.db.Exec(fmt.Sprintf(tableCreate, tableName))
+	return p.SaveBatch([]ILogData{log})
+}
+
+// SaveBatch 在同一个事务中写入，设置了签名密钥时达到间隔后在同一个事务中写入检查点
+// 距离上一次清理超过PruneInterval时在同一个事务中清理过期的记录
+func (p *SqliteTransport) SaveBatch(logs []ILogData) error {
+	p.chain.mu.Lock()
+	defer p.chain.mu.Unlock()
+	if err := p.chain.load(p.driver.db); err != nil {
+		return err
+	}
+
+	tx, err := p.driver.db.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
+
+	var (
+		stmt   = tx.Stmt(p.insert)
+		link   = p.chain.begin()
+		lastId int64
+	)
+	for _, log := range logs {
+		if lastId, err = p.save(tx, stmt, link, log); err != nil {
+			return err
+		}
+	}
+	if p.chain.key != nil && lastId > 0 && link.pending >= p.chain.every {
+		if _, err := p.writeCheckpoint(tx, link, lastId, link.last); err != nil {
+			return err
+		}
+		link.pending = 0
+	}
+	pruned := p.pruned
+	if now := time.Now(); now.Sub(pruned) >= PruneInterval {
+		if _, err := p.prune(tx, now); err != nil {
+			return err
+		}
+		pruned = now
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	p.chain.commit(link)
+	p.pruned = pruned
+	return nil
+}
 
-	// save
-	payload, _ := json.Marshal(log.GetPaylod())
-	changes, _ := json.Marshal(log.GetChange())
-	_, err = p.driver.db.Exec(
-		fmt.Sprintf(recordInsert, tableName, 0, 0, escape.ReplaceAllString(string(payload), `""`), escape.ReplaceAllString(string(changes), `""`)),
+func (p *SqliteTransport) save(tx *sql.Tx, stmt *sql.Stmt, link *chainLink, log ILogData) (int64, error) {
+	payload, err := json.Marshal(log.GetPaylod())
+	if err != nil {
+		return 0, err
+	}
+	changes, err := json.Marshal(log.GetChange())
+	if err != nil {
+		return 0, err
+	}
+
+	r := historyRow{
+		schema: log.GetSchema(), table: log.GetTable(), typ: log.GetType(), op: log.GetLabel(),
+		recordId: transport.GetId(log), eventTime: toEventTime(log.GetTime()),
+		txId: transport.GetTxId(log), actor: transport.GetActor(log),
+		payload: string(payload), changes: string(changes),
+		expireAt: toEventTime(transport.GetExpireAt(log)),
+	}
+	key := r.schema + "." + r.table
+	prevTable, ok := link.tables[key]
+	if !ok {
+		if prevTable, err = p.chain.tableHash(p.driver.db, r.schema, r.table); err != nil {
+			return 0, err
+		}
+	}
+	digest := r.digest()
+	hash, tableHash := chainHash(p.chain.key, link.last, digest), chainHash(p.chain.key, prevTable, digest)
+
+	result, err := stmt.Exec(
+		r.schema, r.table, r.typ, r.op, r.recordId,
+		r.eventTime, r.txId, r.actor, r.payload, r.changes, hash, tableHash, r.expireAt,
 	)
-	return err
+	if err != nil {
+		return 0, err
+	}
+	link.last, link.tables[key] = hash, tableHash
+	link.pending++
+
+	id, err := result.LastInsertId()
+	if err != nil || !p.fts {
+		return id, err
+	}
+	_, err = tx.Exec(ftsInsert, id, r.payload, r.changes)
+	return id, err
+}
+
+func (p *SqliteTransport) Close() error {
+	p.insert.Close()
+	return p.driver.db.Close()
 }
 
-func (p *SqliteTransport) Load(string) ([]string, error) {
-	return nil, errors.New("plain transport no implete this")
+// Load 按照时间顺序回放满足条件的历史记录
+func (p *SqliteTransport) Load(ctx context.Context, q transport.Query, options transport.ReplayOptions, fn transport.ReplayFunc) (transport.ReplayStats, error) {
+	return transport.Replay(ctx, p, q, options, fn)
 }
 
 // search record by keyword(field=value)
-func (p *SqliteTransport) Search(tableName, key string, value interface{}) ([]string, error) {
-	keyword := fmt.Sprintf(`"%s":"%s"`, key, value)
-	fmt.Printf(recordSearch, tableName, escape.ReplaceAllString(keyword, `""`))
-	rows, err := p.driver.db.Query(
-		fmt.Sprintf(recordSearch, tableName, escape.ReplaceAllString(keyword, `""`)),
-	)
-	if err != nil {
-		return nil, err
+// tableName为schema.table格式，兼容旧版本以LegacySchemas开头的schema_table格式，其他情况schema为public
+func (p *SqliteTransport) Search(tableName, key string, value interface{}) ([]transport.Record, error) {
+	schema, table := "public", tableName
+	if index := strings.Index(tableName, "."); index >= 0 {
+		schema, table = tableName[:index], tableName[index+1:]
+	} else if s := matchSchema(tableName, LegacySchemas); s != "" {
+		schema, table = s, tableName[len(s)+1:]
 	}
-	defer rows.Close()
 
-	res := []string{} // payloads: ..., changes: ...
-	for rows.Next() {
-		var (
-			op, recordID     int
-			payload, changes string
-		)
-		err = rows.Scan(&op, &recordID, &payload, &changes)
-		if err != nil {
-			continue
-		}
-		res = append(res, fmt.Sprintf("payloads:%s;changes:%s", payload, changes))
+	return p.Query(transport.Query{
+		Schema: schema,
+		Table:  table,
+		Fields: []transport.FieldCondition{{Path: key, Op: "=", Value: value}},
+	})
+}
+
+func unmarshalJson(data string, v interface{}) error {
+	if data == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(data), v)
+}
+
+// 时间为零值时记录为0
+func toEventTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
 	}
+	return t.UnixNano()
+}
 
-	return res, nil
+func fromEventTime(t int64) time.Time {
+	if t == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, t)
 }
@@ -0,0 +1,418 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/wwqdrh/datamanager/transport"
+)
+
+func newTestTransport(t *testing.T) *SqliteTransport {
+	s, err := NewSqliteTransport(filepath.Join(t.TempDir(), "data.db"))
+	require.Nil(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestSqliteSaveAndQuery(t *testing.T) {
+	s := newTestTransport(t)
+
+	now := time.Now()
+	require.Nil(t, s.SaveBatch([]ILogData{
+		transport.Record{
+			Schema: "public", Table: "notes", Label: "insert", Time: now.Add(-time.Hour),
+			Payload: map[string]interface{}{"id": 1, "note": `it's a "quoted" note`},
+			TxId:    "100", Actor: "alice",
+		},
+		transport.Record{
+			Schema: "public", Table: "notes", Label: "update", Time: now,
+			Payload: map[string]interface{}{"id": 1, "note": "changed"},
+			Changes: map[string]interface{}{"note": `it's a "quoted" note`},
+		},
+	}))
+	require.Nil(t, s.Save(transport.Record{
+		Schema: "public", Table: "users", Label: "delete", Time: now,
+		Payload: map[string]interface{}{"id": 2},
+	}))
+
+	records, err := s.Query(transport.Query{Schema: "public", Table: "notes"})
+	require.Nil(t, err)
+	require.Len(t, records, 2)
+	require.Equal(t, "insert", records[0].Label)
+	require.Equal(t, "1", records[0].Id)
+	require.Equal(t, "100", records[0].TxId)
+	require.Equal(t, "alice", records[0].Actor)
+	require.Equal(t, `it's a "quoted" note`, records[0].Payload["note"])
+	require.Equal(t, now.Add(-time.Hour).UnixNano(), records[0].Time.UnixNano())
+
+	records, err = s.Query(transport.Query{Table: "notes", Start: now.Add(-time.Minute)})
+	require.Nil(t, err)
+	require.Len(t, records, 1)
+	require.Equal(t, "update", records[0].Label)
+
+	records, err = s.Query(transport.Query{Limit: 1, Offset: 2})
+	require.Nil(t, err)
+	require.Len(t, records, 1)
+
+	res, err := s.Search("public_notes", "note", "changed")
+	require.Nil(t, err)
+	require.Len(t, res, 1)
+
+	// 参数化查询，关键字中的引号不会破坏语句
+	res, err = s.Search(`public_notes" or 1=1 --`, "note", `"`)
+	require.Nil(t, err)
+	require.Empty(t, res)
+}
+
+func TestSqliteMigrateLegacyTables(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.db")
+
+	db, err := sql.Open("sqlite3", path)
+	require.Nil(t, err)
+	_, err = db.Exec(`CREATE TABLE public_notes (id INTEGER PRIMARY KEY, op INTEGER, recordID INTEGER, payload TEXT, changes TEXT)`)
+	require.Nil(t, err)
+	_, err = db.Exec(`INSERT INTO public_notes (op, recordID, payload, changes) VALUES (0, 0, '{"id":14,"name":"user1"}', 'null')`)
+	require.Nil(t, err)
+	_, err = db.Exec(`CREATE TABLE other (name TEXT)`)
+	require.Nil(t, err)
+	// 匹配已知的schema，只有一个下划线时直接拆分，否则无法确定
+	for _, table := range []string{"public_user_roles", "app_orders", "my_app_orders"} {
+		_, err = db.Exec(fmt.Sprintf(`CREATE TABLE %s (id INTEGER PRIMARY KEY, op INTEGER, recordID INTEGER, payload TEXT, changes TEXT)`, table))
+		require.Nil(t, err)
+		_, err = db.Exec(fmt.Sprintf(`INSERT INTO %s (op, recordID, payload, changes) VALUES (0, 0, '{"id":1}', 'null')`, table))
+		require.Nil(t, err)
+	}
+	require.Nil(t, db.Close())
+
+	s, err := NewSqliteTransport(path)
+	require.Nil(t, err)
+	defer s.Close()
+
+	for _, table := range [][2]string{{"public", "user_roles"}, {"app", "orders"}, {"", "my_app_orders"}} {
+		records, err := s.Query(transport.Query{Schema: table[0], Table: table[1]})
+		require.Nil(t, err)
+		require.Len(t, records, 1, table)
+	}
+	ambiguous, err := s.AmbiguousLegacyTables()
+	require.Nil(t, err)
+	require.Equal(t, []string{"my_app_orders"}, ambiguous)
+
+	records, err := s.Query(transport.Query{Schema: "public", Table: "notes"})
+	require.Nil(t, err)
+	require.Len(t, records, 1)
+	require.Equal(t, "14", records[0].Id)
+	require.Equal(t, "user1", records[0].Payload["name"])
+	require.Empty(t, records[0].Changes)
+
+	var count int
+	require.Nil(t, s.driver.db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE name = 'public_notes'`).Scan(&count))
+	require.Zero(t, count)
+	require.Nil(t, s.driver.db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE name = 'other'`).Scan(&count))
+	require.Equal(t, 1, count)
+
+	// 迁移的记录同样在哈希链中
+	require.Nil(t, s.Save(transport.Record{Schema: "public", Table: "notes", Label: "delete",
+		Payload: map[string]interface{}{"id": 14}}))
+	res, err := s.Verify()
+	require.Nil(t, err)
+	require.True(t, res.Ok)
+	require.Equal(t, 5, res.Records)
+}
+
+func TestSqliteStructuredQuery(t *testing.T) {
+	s := newTestTransport(t)
+
+	base := time.Date(2022, 7, 1, 9, 0, 0, 0, time.UTC)
+	require.Nil(t, s.SaveBatch([]ILogData{
+		transport.Record{
+			Schema: "public", Table: "users", Label: "insert", Time: base,
+			Payload: map[string]interface{}{"id": 1, "age": 17, "name": "tom", "address": map[string]interface{}{"city": "beijing"}},
+		},
+		transport.Record{
+			Schema: "public", Table: "users", Label: "update", Time: base.Add(time.Minute),
+			Payload: map[string]interface{}{"id": 1, "age": 18, "name": "tom", "address": map[string]interface{}{"city": "shanghai"}},
+			Changes: map[string]interface{}{"age": 17, "address": map[string]interface{}{"city": "beijing"}},
+		},
+		transport.Record{
+			Schema: "public", Table: "users", Label: "insert", Time: base.Add(2 * time.Minute),
+			Payload: map[string]interface{}{"id": "2", "age": 30, "name": "jerry", "nickname": nil},
+			Changes: map[string]interface{}{"nickname": nil},
+		},
+		transport.Record{
+			Schema: "public", Table: "users", Label: "delete", Time: base.Add(3 * time.Minute),
+			Payload: map[string]interface{}{"id": 1, "age": 18, "name": "tom"},
+		},
+	}))
+
+	cases := []struct {
+		name  string
+		query transport.Query
+		want  []string // labels
+	}{
+		{"number string matches number", transport.Query{Fields: []transport.FieldCondition{{Path: "id", Value: "1"}}}, []string{"insert", "update", "delete"}},
+		{"number string matches string", transport.Query{Fields: []transport.FieldCondition{{Path: "id", Value: "2"}}}, []string{"insert"}},
+		{"range", transport.Query{Fields: []transport.FieldCondition{{Path: "age", Op: ">=", Value: 18}, {Path: "age", Op: "<", Value: "30"}}}, []string{"update", "delete"}},
+		{"nested", transport.Query{Fields: []transport.FieldCondition{{Path: "address.city", Value: "shanghai"}}}, []string{"update"}},
+		{"changed", transport.Query{Changed: []string{"age"}}, []string{"update"}},
+		{"changed to null", transport.Query{Changed: []string{"nickname", "missing"}}, []string{"insert"}},
+		{"ops", transport.Query{Ops: []string{"update", "delete"}}, []string{"update", "delete"}},
+		{"time range", transport.Query{Start: base.Add(time.Minute), End: base.Add(3 * time.Minute)}, []string{"update", "insert"}},
+		{"record id", transport.Query{RecordId: "2"}, []string{"insert"}},
+		{"text", transport.Query{Text: "jerry"}, []string{"insert"}},
+		{"text like escape", transport.Query{Text: "%"}, []string{}},
+		{"desc page", transport.Query{Desc: true, Limit: 2, Offset: 1}, []string{"insert", "update"}},
+	}
+	for _, c := range cases {
+		records, err := s.Query(c.query)
+		require.Nil(t, err, c.name)
+
+		labels := []string{}
+		for _, r := range records {
+			labels = append(labels, r.Label)
+		}
+		require.Equal(t, c.want, labels, c.name)
+	}
+
+	total, err := s.Count(transport.Query{Fields: []transport.FieldCondition{{Path: "name", Value: "tom"}}, Limit: 1})
+	require.Nil(t, err)
+	require.Equal(t, 3, total)
+
+	_, err = s.Query(transport.Query{Fields: []transport.FieldCondition{{Path: "age", Op: "like", Value: 1}}})
+	require.ErrorIs(t, err, ErrFieldOperator)
+}
+
+func TestSqliteLoad(t *testing.T) {
+	s := newTestTransport(t)
+
+	base := time.Date(2022, 7, 1, 9, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		require.Nil(t, s.Save(transport.Record{
+			Schema: "public", Table: "notes", Label: "insert", Time: base.Add(time.Duration(i) * time.Minute),
+			Payload: map[string]interface{}{"id": i},
+		}))
+	}
+
+	ids := []interface{}{}
+	stats, err := s.Load(context.Background(), transport.Query{
+		Schema: "public", Table: "notes", Start: base.Add(time.Minute), End: base.Add(4 * time.Minute),
+	}, transport.ReplayOptions{PageSize: 2}, func(log ILogData) error {
+		ids = append(ids, log.GetPaylod()["id"])
+		return nil
+	})
+	require.Nil(t, err)
+	require.Equal(t, 3, stats.Events)
+	require.Equal(t, []interface{}{float64(1), float64(2), float64(3)}, ids)
+}
+
+// newChainTransport 4条记录，第3条记录之后生成检查点
+func newChainTransport(t *testing.T) *SqliteTransport {
+	s := newTestTransport(t)
+	s.SetSigningKey([]byte("secret"), 3)
+
+	for i := 1; i <= 4; i++ {
+		table := "notes"
+		if i%2 == 0 {
+			table = "users"
+		}
+		require.Nil(t, s.Save(transport.Record{Schema: "public", Table: table, Label: "insert",
+			Payload: map[string]interface{}{"id": i}}))
+	}
+	return s
+}
+
+func TestSqliteHashChain(t *testing.T) {
+	s := newChainTransport(t)
+
+	checkpoints, err := s.Checkpoints()
+	require.Nil(t, err)
+	require.Len(t, checkpoints, 1)
+	require.Equal(t, int64(3), checkpoints[0].HistoryId)
+
+	res, err := s.Verify()
+	require.Nil(t, err)
+	require.True(t, res.Ok)
+	require.True(t, res.Signed)
+	require.Equal(t, 4, res.Records)
+	require.Equal(t, 1, res.Checkpoints)
+
+	// 手动生成检查点
+	checkpoint, err := s.Checkpoint()
+	require.Nil(t, err)
+	require.Equal(t, int64(4), checkpoint.HistoryId)
+
+	_, err = newTestTransport(t).Checkpoint()
+	require.ErrorIs(t, err, ErrNoSigningKey)
+}
+
+func TestSqliteHashChainTampered(t *testing.T) {
+	tamper := func(stmt string) *BrokenLink {
+		s := newChainTransport(t)
+		_, err := s.driver.db.Exec(stmt)
+		require.Nil(t, err)
+
+		res, err := s.Verify()
+		require.Nil(t, err)
+		require.Equal(t, res.Broken == nil, res.Ok)
+		return res.Broken
+	}
+
+	broken := tamper(`UPDATE history SET payload = '{"id":9}' WHERE id = 2`)
+	require.Equal(t, int64(2), broken.Id)
+	require.Equal(t, "global", broken.Chain)
+	require.Equal(t, "users", broken.Table)
+
+	broken = tamper(`DELETE FROM history WHERE id = 2`)
+	require.Equal(t, int64(3), broken.Id)
+	require.Equal(t, "global", broken.Chain)
+
+	// 持有密钥重新计算了全局哈希，但是数据表的链不一致
+	s := newChainTransport(t)
+	_, err := s.driver.db.Exec(`UPDATE history SET payload = '{"id":9}' WHERE id = 4`)
+	require.Nil(t, err)
+	digest := recordDigest("public", "users", "", "insert", "4", 0, "", "", `{"id":9}`, "null")
+	var prev string
+	require.Nil(t, s.driver.db.QueryRow(`SELECT hash FROM history WHERE id = 3`).Scan(&prev))
+	_, err = s.driver.db.Exec(`UPDATE history SET hash = ? WHERE id = 4`, chainHash([]byte("secret"), prev, digest))
+	require.Nil(t, err)
+	res, err := s.Verify()
+	require.Nil(t, err)
+	require.Equal(t, "table", res.Broken.Chain)
+	require.Equal(t, int64(4), res.Broken.Id)
+
+	// 截断末尾的记录只能通过检查点发现
+	broken = tamper(`DELETE FROM history WHERE id >= 3`)
+	require.Equal(t, "checkpoint", broken.Chain)
+	require.Equal(t, int64(3), broken.Id)
+
+	broken = tamper(`UPDATE history_checkpoint SET history_id = 4`)
+	require.Equal(t, "checkpoint", broken.Chain)
+	require.Contains(t, broken.Reason, "signature")
+
+	require.Nil(t, tamper(`UPDATE history SET actor = actor`))
+}
+
+func TestSqliteHashChainCheckpointRemoved(t *testing.T) {
+	// 删除全部检查点并且不使用密钥重新计算链
+	s := newChainTransport(t)
+	_, err := s.driver.db.Exec(`DELETE FROM history_checkpoint`)
+	require.Nil(t, err)
+	tx, err := s.driver.db.Begin()
+	require.Nil(t, err)
+	require.Nil(t, rechain(tx, nil))
+	require.Nil(t, tx.Commit())
+	res, err := s.Verify()
+	require.Nil(t, err)
+	require.False(t, res.Ok)
+	require.Equal(t, "global", res.Broken.Chain)
+	require.Equal(t, int64(1), res.Broken.Id)
+
+	// 只删除检查点，最后一个检查点之后的记录超过间隔
+	s = newChainTransport(t)
+	_, err = s.driver.db.Exec(`DELETE FROM history_checkpoint`)
+	require.Nil(t, err)
+	res, err = s.Verify()
+	require.Nil(t, err)
+	require.Equal(t, "checkpoint", res.Broken.Chain)
+	require.Equal(t, int64(1), res.Broken.Id)
+
+	// 删除中间的检查点
+	s = newChainTransport(t)
+	_, err = s.Checkpoint()
+	require.Nil(t, err)
+	_, err = s.driver.db.Exec(`DELETE FROM history_checkpoint WHERE id = 1`)
+	require.Nil(t, err)
+	res, err = s.Verify()
+	require.Nil(t, err)
+	require.Equal(t, int64(2), res.Broken.Checkpoint)
+	require.Contains(t, res.Broken.Reason, "missing")
+
+	// 删除之后重新编号，签名不一致
+	_, err = s.driver.db.Exec(`UPDATE history_checkpoint SET id = 1`)
+	require.Nil(t, err)
+	res, err = s.Verify()
+	require.Nil(t, err)
+	require.Contains(t, res.Broken.Reason, "signature")
+}
+
+func TestSqliteHashChainKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.db")
+	s, err := NewSqliteTransport(path)
+	require.Nil(t, err)
+	require.Nil(t, s.Save(transport.Record{Schema: "public", Table: "notes", Label: "insert",
+		Payload: map[string]interface{}{"id": 1}}))
+
+	// 第一次使用密钥写入时使用密钥重新计算已有的记录
+	s.SetSigningKey([]byte("secret"), 1)
+	require.Nil(t, s.Save(transport.Record{Schema: "public", Table: "notes", Label: "update",
+		Payload: map[string]interface{}{"id": 1}}))
+	res, err := s.Verify()
+	require.Nil(t, err)
+	require.True(t, res.Ok)
+	require.Equal(t, 2, res.Records)
+	require.Nil(t, s.Close())
+
+	s, err = NewSqliteTransport(path)
+	require.Nil(t, err)
+	defer s.Close()
+	_, err = s.Verify()
+	require.ErrorIs(t, err, ErrNoSigningKey)
+	require.ErrorIs(t, s.Save(transport.Record{Schema: "public", Table: "notes"}), ErrNoSigningKey)
+
+	s.SetSigningKey([]byte("other"), 1)
+	_, err = s.Verify()
+	require.ErrorIs(t, err, ErrSigningKeyMismatch)
+}
+
+func TestSqlitePrune(t *testing.T) {
+	s := newTestTransport(t)
+	s.SetSigningKey([]byte("secret"), 10)
+
+	now := time.Now()
+	expired, later := now.Add(-time.Minute), now.Add(50*time.Millisecond)
+	require.Nil(t, s.SaveBatch([]ILogData{
+		transport.Record{Schema: "db0", Table: "user:1", Label: "set", Time: now.Add(-time.Hour),
+			Payload: map[string]interface{}{"value": "a"}, ExpireAt: &expired},
+		transport.Record{Schema: "db0", Table: "user:1", Label: "set", Time: now,
+			Payload: map[string]interface{}{"value": "b"}, ExpireAt: &later},
+		transport.Record{Schema: "db0", Table: "user:1", Label: "del", Time: now,
+			Payload: map[string]interface{}{"value": "c"}},
+	}))
+
+	// SaveBatch中已经清理
+	records, err := s.Query(transport.Query{Table: "user:1"})
+	require.Nil(t, err)
+	require.Len(t, records, 3)
+	require.Empty(t, records[0].Payload)
+	require.Equal(t, expired.UnixNano(), records[0].GetExpireAt().UnixNano())
+	require.Equal(t, "b", records[1].Payload["value"])
+	require.Nil(t, records[2].ExpireAt)
+
+	res, err := s.Verify()
+	require.Nil(t, err)
+	require.True(t, res.Ok)
+
+	time.Sleep(80 * time.Millisecond)
+	count, err := s.Prune()
+	require.Nil(t, err)
+	require.Equal(t, 1, count)
+	records, err = s.Query(transport.Query{Text: "b"})
+	require.Nil(t, err)
+	require.Empty(t, records)
+
+	res, err = s.Verify()
+	require.Nil(t, err)
+	require.True(t, res.Ok)
+
+	// 没有过期时间的记录不能清理内容
+	_, err = s.driver.db.Exec(`UPDATE history SET payload = '{}', content_hash = 'x' WHERE id = 3`)
+	require.Nil(t, err)
+	res, err = s.Verify()
+	require.Nil(t, err)
+	require.Equal(t, int64(3), res.Broken.Id)
+	require.Contains(t, res.Broken.Reason, "expired")
+}
@@ -1,6 +1,205 @@
 package transport
 
-type ITransport interface {
-	Save(string)
-	Load(string) ([]string, error)
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrNotSupported = errors.New("transport not support this")
+)
+
+// ILogData 与dialet.ILogData保持一致，避免transport依赖具体的dialet
+type ILogData interface {
+	GetSchema() string
+	GetTable() string
+	GetType() string                   // 获取日志记录类型 ddl dml
+	GetLabel() string                  // 具体标签 insert update delete | alter column, table
+	GetTime() time.Time                // 获取日志记录时间
+	GetPaylod() map[string]interface{} // 获取具体的负载对象
+	GetChange() map[string]interface{}
+}
+
+// 以下为可选的接口，日志实现时存储端会记录对应的字段
+
+// IRecordId 记录的主键
+type IRecordId interface {
+	GetId() string
+}
+
+// ITxId 记录所属的事务id
+type ITxId interface {
+	GetTxId() string
+}
+
+// IPosition 事件在源数据库中的位置，例如postgres的WAL位置，0表示没有
+type IPosition interface {
+	GetPosition() uint64
+}
+
+// IActor 执行修改的用户
+type IActor interface {
+	GetActor() string
+}
+
+// IExpireAt 历史记录的过期时间，零值表示不过期，存储端在过期之后清理记录的内容
+type IExpireAt interface {
+	GetExpireAt() time.Time
+}
+
+// Transport 日志记录的存储端
+type Transport interface {
+	Save(log ILogData) error
+	SaveBatch(logs []ILogData) error
+	Query(q Query) ([]Record, error) // 不支持查询的存储端返回ErrNotSupported
+	Close() error
+}
+
+// Query 历史记录查询条件，零值表示不限制
+// 存储端不支持的条件返回ErrNotSupported
+type Query struct {
+	Schema   string
+	Table    string
+	Start    time.Time // 包含
+	End      time.Time // 不包含
+	Ops      []string  // insert update delete ...
+	RecordId string
+	Fields   []FieldCondition // payload中字段的条件，全部满足
+	Changed  []string         // changes中包含任意一个字段
+	Text     string           // 全文搜索payload以及changes
+	Desc     bool             // 默认按照时间升序
+	Limit    int
+	Offset   int
+}
+
+// FieldCondition payload字段条件，Path使用.分隔嵌套字段，例如address.city
+// Op支持 = != > >= < <=，Value为数字字符串时同时匹配数字
+type FieldCondition struct {
+	Path  string
+	Op    string
+	Value interface{}
+}
+
+// Record 存储端返回的日志记录，同样实现了ILogData
+type Record struct {
+	Schema  string                 `json:"schema"`
+	Table   string                 `json:"table"`
+	Type    string                 `json:"type"`
+	Label   string                 `json:"label"`
+	Time    time.Time              `json:"time"`
+	Payload map[string]interface{} `json:"payload"`
+	Changes map[string]interface{} `json:"changes"`
+	Id      string                 `json:"id,omitempty"`
+	TxId    string                 `json:"tx_id,omitempty"`
+	Actor   string                 `json:"actor,omitempty"`
+
+	ExpireAt *time.Time `json:"expire_at,omitempty"`
+}
+
+func NewRecord(log ILogData) Record {
+	record := Record{
+		Schema:  log.GetSchema(),
+		Table:   log.GetTable(),
+		Type:    log.GetType(),
+		Label:   log.GetLabel(),
+		Time:    log.GetTime(),
+		Payload: log.GetPaylod(),
+		Changes: log.GetChange(),
+		Id:      GetId(log),
+		TxId:    GetTxId(log),
+		Actor:   GetActor(log),
+	}
+	if expireAt := GetExpireAt(log); !expireAt.IsZero() {
+		record.ExpireAt = &expireAt
+	}
+	return record
+}
+
+// GetId 日志没有实现IRecordId或者返回空时使用payload中的id字段
+func GetId(log ILogData) string {
+	if l, ok := log.(IRecordId); ok {
+		if id := l.GetId(); id != "" {
+			return id
+		}
+	}
+	if id, ok := log.GetPaylod()["id"]; ok && id != nil {
+		return fmt.Sprint(id)
+	}
+	return ""
+}
+
+func GetTxId(log ILogData) string {
+	if l, ok := log.(ITxId); ok {
+		return l.GetTxId()
+	}
+	return ""
+}
+
+func GetPosition(log ILogData) uint64 {
+	if l, ok := log.(IPosition); ok {
+		return l.GetPosition()
+	}
+	return 0
+}
+
+func GetActor(log ILogData) string {
+	if l, ok := log.(IActor); ok {
+		return l.GetActor()
+	}
+	return ""
+}
+
+func GetExpireAt(log ILogData) time.Time {
+	if l, ok := log.(IExpireAt); ok {
+		return l.GetExpireAt()
+	}
+	return time.Time{}
+}
+
+func (r Record) GetSchema() string {
+	return r.Schema
+}
+
+func (r Record) GetTable() string {
+	return r.Table
+}
+
+func (r Record) GetType() string {
+	return r.Type
+}
+
+func (r Record) GetLabel() string {
+	return r.Label
+}
+
+func (r Record) GetTime() time.Time {
+	return r.Time
+}
+
+func (r Record) GetPaylod() map[string]interface{} {
+	return r.Payload
+}
+
+func (r Record) GetChange() map[string]interface{} {
+	return r.Changes
+}
+
+func (r Record) GetId() string {
+	return r.Id
+}
+
+func (r Record) GetTxId() string {
+	return r.TxId
+}
+
+func (r Record) GetActor() string {
+	return r.Actor
+}
+
+func (r Record) GetExpireAt() time.Time {
+	if r.ExpireAt == nil {
+		return time.Time{}
+	}
+	return *r.ExpireAt
 }
@@ -0,0 +1,163 @@
+package transport
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type memoryTransport struct {
+	mu      sync.Mutex
+	records []Record
+	err     error
+	block   chan struct{}
+	closed  bool
+}
+
+func (m *memoryTransport) Save(log ILogData) error {
+	return m.SaveBatch([]ILogData{log})
+}
+
+func (m *memoryTransport) SaveBatch(logs []ILogData) error {
+	if m.block != nil {
+		<-m.block
+	}
+	if m.err != nil {
+		return m.err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, log := range logs {
+		m.records = append(m.records, NewRecord(log))
+	}
+	return nil
+}
+
+func (m *memoryTransport) Query(Query) ([]Record, error) {
+	return nil, ErrNotSupported
+}
+
+func (m *memoryTransport) Close() error {
+	m.closed = true
+	return nil
+}
+
+func newTestRecord(table string) Record {
+	return Record{
+		Schema:  "public",
+		Table:   table,
+		Label:   "insert",
+		Time:    time.Now(),
+		Payload: map[string]interface{}{"id": 1},
+	}
+}
+
+func TestParseConfigs(t *testing.T) {
+	confs, err := ParseConfigs("plain, sqlite?path=/tmp/a.db&name=history,")
+	require.Nil(t, err)
+	require.Equal(t, []Config{
+		{Type: "plain", Options: map[string]string{}},
+		{Name: "history", Type: "sqlite", Options: map[string]string{"path": "/tmp/a.db"}},
+	}, confs)
+}
+
+func TestRegistry(t *testing.T) {
+	Register("memory", func(options map[string]string) (Transport, error) {
+		return &memoryTransport{}, nil
+	})
+	require.Contains(t, Types(), "memory")
+
+	sinks, err := NewAll([]Config{{Type: "memory"}, {Name: "backup", Type: "memory"}})
+	require.Nil(t, err)
+	require.Len(t, sinks, 2)
+
+	_, err = NewAll([]Config{{Type: "memory"}, {Type: "memory"}})
+	require.NotNil(t, err)
+
+	_, err = New(Config{Type: "unknown"})
+	require.NotNil(t, err)
+}
+
+func TestDispatcherFanOut(t *testing.T) {
+	good := &memoryTransport{}
+	bad := &memoryTransport{err: errors.New("disk full")}
+
+	var (
+		mu     sync.Mutex
+		failed = map[string]int{}
+	)
+	d := NewDispatcher(map[string]Transport{"good": good, "bad": bad}, DispatcherOptions{
+		OnError: func(name string, logs []ILogData, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			failed[name] += len(logs)
+		},
+	})
+
+	for i := 0; i < 10; i++ {
+		require.Nil(t, d.Dispatch(newTestRecord("notes")))
+	}
+	require.Nil(t, d.Close())
+	require.Equal(t, ErrDispatcherClosed, d.Dispatch(newTestRecord("notes")))
+
+	require.Len(t, good.records, 10)
+	require.True(t, good.closed)
+	require.Equal(t, 10, failed["bad"])
+
+	stats := d.Stats()
+	require.Equal(t, SinkStats{Delivered: 10}, stats["good"])
+	require.Equal(t, SinkStats{Failed: 10}, stats["bad"])
+}
+
+func TestDispatcherSlowSink(t *testing.T) {
+	fast := &memoryTransport{}
+	slow := &memoryTransport{block: make(chan struct{})}
+
+	d := NewDispatcher(map[string]Transport{"fast": fast, "slow": slow}, DispatcherOptions{BufferSize: 2, BatchSize: 1})
+	for i := 0; i < 10; i++ {
+		require.Nil(t, d.Dispatch(newTestRecord("notes")))
+		time.Sleep(time.Millisecond)
+	}
+	close(slow.block)
+	require.Nil(t, d.Close())
+
+	// 慢的存储端队列已满时丢弃，不影响其他存储端
+	require.Len(t, fast.records, 10)
+	stats := d.Stats()
+	require.Equal(t, uint64(10), stats["slow"].Delivered+stats["slow"].Dropped)
+	require.NotZero(t, stats["slow"].Dropped)
+}
+
+func TestDispatcherBlockClose(t *testing.T) {
+	slow := &memoryTransport{block: make(chan struct{})}
+	d := NewDispatcher(map[string]Transport{"slow": slow}, DispatcherOptions{BufferSize: 1, BatchSize: 1, Block: true})
+
+	// 第一条被取出后阻塞在SaveBatch中，第二条放入队列，第三条阻塞在Dispatch中
+	require.Nil(t, d.Dispatch(newTestRecord("notes")))
+	time.Sleep(10 * time.Millisecond)
+	require.Nil(t, d.Dispatch(newTestRecord("notes")))
+	blocked := make(chan error, 1)
+	go func() {
+		blocked <- d.Dispatch(newTestRecord("notes"))
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- d.Close()
+	}()
+	select {
+	case err := <-blocked:
+		require.Equal(t, ErrDispatcherClosed, err)
+	case <-time.After(time.Second):
+		t.Fatal("dispatch should return after close")
+	}
+	close(slow.block)
+	require.Nil(t, <-closed)
+	require.Len(t, slow.records, 2)
+	require.Equal(t, SinkStats{Delivered: 2, Dropped: 1}, d.Stats()["slow"])
+}
@@ -0,0 +1,274 @@
+package datamanager
+
+import (
+	"context"
+	"time"
+
+	"github.com/wwqdrh/datamanager/dialet"
+	"github.com/wwqdrh/datamanager/transport"
+)
+
+// 缓存key的版本
+// 每个变更事件的序号为事件在源数据库中的位置(transport.IPosition，例如postgres的WAL位置)，
+// 位置不大于之前的事件或者事件没有位置时为上一个序号加1，序号不小于事件的位置并且按照处理的顺序递增；
+// key被该事件刷新或者失效之后版本更新为该序号，写入方使用修改之前的位置作为WaitFor的minVersion，
+// 其他节点通过一致性消息使用相同的序号
+// 只记录本地缓存中的key以及正在等待的key的版本，其他key的版本为已经删除的版本中最大的值，
+// 等待没有缓存的key时可能被其他key的失效提前唤醒
+// 事件带有事务标识时(例如postgres的txid)记录事务影响的key，用于等待刚提交的事务反映到缓存中
+// 同一个事务的事件是连续到达的，收到其他事务的事件或者队列空闲超过TxIdleTimeout时认为事务已经处理完成，
+// 之后又收到该事务的事件时合并到已经完成的记录中
+
+var (
+	// maxTrackedTx 保留最近完成的事务数量
+	maxTrackedTx = 4096
+
+	// TxIdleTimeout 队列空闲超过该时间时认为当前的事务已经处理完成
+	TxIdleTimeout = 100 * time.Millisecond
+)
+
+type keyState struct {
+	version uint64
+	changed chan struct{} // 版本变化时关闭
+	waiters int
+}
+
+// advance 版本只增不减，调用方需要持有锁
+func (s *keyState) advance(version uint64) {
+	if version <= s.version {
+		return
+	}
+	s.version = version
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+type txTracker struct {
+	current string
+	keys    map[string]uint64 // 当前事务影响的key => 需要达到的版本
+	version uint64            // 当前事务最后一个事件的序号
+	done    map[string]*txRecord
+	order   []string
+	changed chan struct{} // 有事务完成时关闭
+}
+
+// txRecord 已经完成的事务
+type txRecord struct {
+	keys    map[string]uint64
+	version uint64
+}
+
+// state 没有记录时从floor开始，调用方需要持有锁
+func (r *Repo) state(key string) *keyState {
+	if r.states == nil {
+		r.states = map[string]*keyState{}
+	}
+	s, ok := r.states[key]
+	if !ok {
+		s = &keyState{version: r.floor, changed: make(chan struct{})}
+		r.states[key] = s
+	}
+	return s
+}
+
+// beginEvent 分配事件的序号，事务变化时完成上一个事务
+func (r *Repo) beginEvent(log dialet.ILogData) uint64 {
+	txid := transport.GetTxId(log)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.tx.current != txid {
+		r.completeTx()
+	}
+	r.tx.current = txid
+	r.observe(transport.GetPosition(log))
+	if txid != "" {
+		r.tx.version = r.seq
+	}
+	return r.seq
+}
+
+// observe 分配下一个序号，不小于position，调用方需要持有锁
+func (r *Repo) observe(position uint64) uint64 {
+	if position > r.seq {
+		r.seq = position
+	} else {
+		r.seq++
+	}
+	return r.seq
+}
+
+// touch 记录当前事务影响的key
+func (r *Repo) touch(key string, seq uint64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.tx.current == "" {
+		return
+	}
+	if r.tx.keys == nil {
+		r.tx.keys = map[string]uint64{}
+	}
+	r.tx.keys[key] = seq
+}
+
+// dropState 删除没有等待的key的版本，调用方需要持有锁
+func (r *Repo) dropState(key string) {
+	if s, ok := r.states[key]; ok && s.waiters == 0 {
+		if s.version > r.floor {
+			r.floor = s.version
+		}
+		delete(r.states, key)
+	}
+}
+
+// advance 更新key的版本并唤醒等待的调用，没有缓存并且没有等待的key只更新floor
+func (r *Repo) advance(key string, seq uint64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if c, ok := r.WaitMap[key]; ok {
+		c.L.Lock()
+		c.Broadcast()
+		c.L.Unlock()
+	}
+	if _, ok := r.states[key]; !ok {
+		if _, cached := r.ValueMap.Load(key); !cached {
+			if seq > r.floor {
+				r.floor = seq
+			}
+			return
+		}
+	}
+	r.state(key).advance(seq)
+}
+
+// completeTx 已经完成的事务再次出现时合并，调用方需要持有锁
+func (r *Repo) completeTx() {
+	if r.tx.current == "" {
+		return
+	}
+	if r.tx.done == nil {
+		r.tx.done = map[string]*txRecord{}
+	}
+	record, ok := r.tx.done[r.tx.current]
+	if !ok {
+		record = &txRecord{keys: map[string]uint64{}}
+		r.tx.done[r.tx.current] = record
+		r.tx.order = append(r.tx.order, r.tx.current)
+	}
+	for key, version := range r.tx.keys {
+		if version > record.keys[key] {
+			record.keys[key] = version
+		}
+	}
+	if r.tx.version > record.version {
+		record.version = r.tx.version
+	}
+	for len(r.tx.order) > maxTrackedTx {
+		delete(r.tx.done, r.tx.order[0])
+		r.tx.order = r.tx.order[1:]
+	}
+	r.tx.current, r.tx.keys, r.tx.version = "", nil, 0
+
+	if r.tx.changed != nil {
+		close(r.tx.changed)
+	}
+	r.tx.changed = make(chan struct{})
+}
+
+// flushTx 队列空闲时完成当前的事务
+func (r *Repo) flushTx() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.completeTx()
+}
+
+// Version key当前的版本，没有记录时为已经删除的版本中最大的值，读取不会记录key
+func (r *Repo) Version(key string) uint64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if s, ok := r.states[key]; ok {
+		return s.version
+	}
+	return r.floor
+}
+
+// TxVersion 事务处理完成时的序号，事务没有完成或者已经不再保留时返回false
+// 版本不小于该序号的key已经反映了事务的修改
+func (r *Repo) TxVersion(txid string) (uint64, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if record, ok := r.tx.done[txid]; ok {
+		return record.version, true
+	}
+	return 0, false
+}
+
+// WaitFor 等待key的版本达到minVersion，返回达到时的版本
+// 先记录版本再修改数据，不会错过在等待之前发生的更新
+func (r *Repo) WaitFor(ctx context.Context, key string, minVersion uint64) (uint64, error) {
+	r.lock.Lock()
+	s := r.state(key)
+	s.waiters++
+	r.lock.Unlock()
+	defer func() {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		s.waiters--
+		if _, cached := r.ValueMap.Load(key); !cached {
+			r.dropState(key)
+		}
+	}()
+
+	for {
+		r.lock.Lock()
+		version, changed := s.version, s.changed
+		r.lock.Unlock()
+		if version >= minVersion {
+			return version, nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return version, ctx.Err()
+		}
+	}
+}
+
+// WaitTx 等待刚提交的事务处理完成，并且事务影响的key都已经刷新或者失效
+// txid为事件中的事务标识，例如在事务中执行 SELECT txid_current()
+func (r *Repo) WaitTx(ctx context.Context, txid string) error {
+	var keys map[string]uint64
+	for {
+		r.lock.Lock()
+		record, ok := r.tx.done[txid]
+		if ok {
+			// 之后合并时会修改record
+			keys = make(map[string]uint64, len(record.keys))
+			for key, version := range record.keys {
+				keys[key] = version
+			}
+		}
+		if r.tx.changed == nil {
+			r.tx.changed = make(chan struct{})
+		}
+		changed := r.tx.changed
+		r.lock.Unlock()
+		if ok {
+			break
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	for key, version := range keys {
+		if _, err := r.WaitFor(ctx, key, version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 
@@ -13,6 +14,8 @@ import (
 // 提供基于http的远程调用，用户能够进行注册
 // 当程序检测到自定义表的更新时自动调用http接口进行通知
 
+var ErrNotRegistered = errors.New("未注册")
+
 func GetEvent() chan *Event {
 	return make(chan *Event, 10)
 }
@@ -48,16 +51,8 @@ func (w *Watcher) Notify(ctx context.Context) {
 		case e := <-eventChan:
 			if val, ok := e.(dialet.ILogData); !ok {
 				fmt.Println("数据错误")
-			} else if url, ok := w.cb[val.GetTable()]; ok {
-				err := w.HTTPPost(url, map[string]interface{}{
-					"table":   val.GetTable(),
-					"payload": val.GetPaylod(),
-				})
-				if err != nil {
-					fmt.Println(err)
-				}
-			} else {
-				fmt.Println("未注册")
+			} else if err := w.Dispatch(val); err != nil {
+				fmt.Println(err)
 			}
 		case <-ctx.Done():
 			return
@@ -65,6 +60,19 @@ func (w *Watcher) Notify(ctx context.Context) {
 	}
 }
 
+// Dispatch 调用数据表注册的回调，也可以用于回放历史记录重新触发回调
+func (w *Watcher) Dispatch(val dialet.ILogData) error {
+	url, ok := w.cb[val.GetTable()]
+	if !ok {
+		return ErrNotRegistered
+	}
+
+	return w.HTTPPost(url, map[string]interface{}{
+		"table":   val.GetTable(),
+		"payload": val.GetPaylod(),
+	})
+}
+
 // send data to url, the method is post
 func (w *Watcher) HTTPPost(url string, data interface{}) error {
 	body, err := json.Marshal(data)